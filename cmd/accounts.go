@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"google-photos-backup/internal/config"
+	"google-photos-backup/internal/registry"
+
+	"github.com/spf13/viper"
+)
+
+// accounts.go holds the helpers for multi-account installations: every account has its
+// own working and backup path (see config.SelectAccount) while the object store and,
+// with immich_master_mode: merged, the Immich Master live in the shared backup_path.
+
+// getSharedBackupPath returns the top-level backup_path, common to all accounts
+func getSharedBackupPath() string {
+	backupPath := config.SharedBackupPath
+	if backupPath == "" {
+		backupPath = viper.GetString("backup_path")
+	}
+	return expandPath(backupPath)
+}
+
+// getImmichRoot returns the Immich Master directory used for backupPath
+func getImmichRoot(backupPath string) string {
+	if config.ActiveAccount != "" && config.AppConfig.ImmichMasterMode == "merged" {
+		return filepath.Join(getSharedBackupPath(), getImmichPath())
+	}
+	return filepath.Join(backupPath, getImmichPath())
+}
+
+// accountBackupPaths returns the backup path of every configured account (name -> path),
+// or nil for single-account installations.
+func accountBackupPaths() map[string]string {
+	if len(config.AppConfig.Accounts) == 0 {
+		return nil
+	}
+	shared := getSharedBackupPath()
+	paths := make(map[string]string)
+	for name, account := range config.AppConfig.Accounts {
+		path := account.BackupPath
+		if path == "" {
+			path = filepath.Join(shared, "accounts", name)
+		}
+		paths[name] = expandPath(path)
+	}
+	return paths
+}
+
+// otherAccountHashes maps Hash -> file in the latest snapshot of every other account, so
+// content shared between accounts (shared albums, partner sharing) is stored once. Older
+// snapshots are not read: what is still shared upstream is in the latest one.
+func otherAccountHashes() map[string]string {
+	hashes := make(map[string]string)
+	for name, backupPath := range accountBackupPaths() {
+		if name == config.ActiveAccount {
+			continue
+		}
+		latest := findLatestBackup(backupPath)
+		if latest == "" {
+			continue
+		}
+		idx, err := registry.LoadIndex(filepath.Join(latest, "index.json"))
+		if err != nil {
+			continue
+		}
+		idx.ForEachHash(func(hash string, relPaths []string) error {
+			if _, ok := hashes[hash]; !ok {
+				hashes[hash] = filepath.Join(latest, relPaths[0])
+			}
+			return nil
+		})
+		idx.Close()
+	}
+	return hashes
+}
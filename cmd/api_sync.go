@@ -0,0 +1,346 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google-photos-backup/internal/browser"
+	"google-photos-backup/internal/config"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/persist"
+	"google-photos-backup/internal/photosapi"
+	"google-photos-backup/internal/registry"
+	"google-photos-backup/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// apiDownloadMode marks api-sync exports in history.json
+const apiDownloadMode = "api"
+
+// apiSyncStateFile (in working_path) remembers how far the library has been downloaded
+const apiSyncStateFile = "api_sync.json"
+
+var errAPILimit = errors.New("download limit reached")
+
+// apiSyncState is the incremental cursor of api-sync
+type apiSyncState struct {
+	Cursor    time.Time `json:"cursor"`            // Creation time of the newest downloaded item
+	CursorIDs []string  `json:"cursor_ids"`        // Items downloaded with exactly that creation time
+	Pending   string    `json:"pending,omitempty"` // Export being filled by an interrupted run
+}
+
+var apiSyncCmd = &cobra.Command{
+	Use:           "api-sync",
+	Short:         "Download new items through the Google Photos Library API",
+	Long:          `Incremental alternative to Takeout: pages mediaItems by creation time from where the previous run stopped, downloads the originals and writes them as a new export (downloads/api-<timestamp>/raw/Takeout/Google Photos/Photos from <year>/) with JSON sidecars and a synthetic state.json, so 'process' and 'update-backup' handle it like any other export. Needs client_id/client_secret of a Google Cloud OAuth client; the token is kept in token_path. Note: Google withdrew the photoslibrary.readonly scope this command relies on in 2025; it only works for projects that still have access to it (or against a compatible endpoint set with photos_api_url).`,
+	Annotations:   locks(lockWorking),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		workingPath := getWorkingPath()
+		if config.AppConfig.ClientID == "" {
+			logger.Error("client_id is not configured. Create an OAuth client (Desktop app) and set client_id / client_secret.")
+			return failed(exitConfig, fmt.Errorf("client_id is not set"))
+		}
+		forceLogin, _ := cmd.Flags().GetBool("login")
+		device, _ := cmd.Flags().GetBool("device")
+		limit, _ := cmd.Flags().GetInt("limit")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		oauth := &photosapi.OAuth{
+			ClientID:     config.AppConfig.ClientID,
+			ClientSecret: config.AppConfig.ClientSecret,
+			Endpoints: photosapi.Endpoints{
+				AuthURL:   config.AppConfig.OAuthAuthURL,
+				TokenURL:  config.AppConfig.OAuthTokenURL,
+				DeviceURL: config.AppConfig.OAuthDeviceURL,
+				APIURL:    strings.TrimRight(config.AppConfig.PhotosAPIURL, "/"),
+			},
+		}
+
+		// 1. Token
+		tokenPath := expandPath(config.AppConfig.TokenPath)
+		token, err := photosapi.LoadToken(tokenPath)
+		if err != nil || forceLogin {
+			token, err = apiLogin(ctx, oauth, device)
+			if err != nil {
+				return logFailed(exitSync, fmt.Errorf("login failed: %w", err))
+			}
+			if err := token.Save(tokenPath); err != nil {
+				return logFailed(exitConfig, fmt.Errorf("failed to save token: %w", err))
+			}
+			logger.Info("🔑 Token saved to %s", tokenPath)
+		}
+		client := &photosapi.Client{OAuth: oauth, Token: token, TokenPath: tokenPath}
+
+		// 2. Cursor and export directory (an interrupted run is resumed in place)
+		statePath := filepath.Join(workingPath, apiSyncStateFile)
+		state, err := loadAPISyncState(statePath)
+		if err != nil {
+			return logFailed(exitSync, err)
+		}
+		exportID := state.Pending
+		if exportID == "" {
+			exportID = "api-" + time.Now().Format("20060102-150405")
+			for n := 2; ; n++ {
+				if _, err := os.Stat(filepath.Join(workingPath, "downloads", exportID)); os.IsNotExist(err) {
+					break
+				}
+				exportID = fmt.Sprintf("api-%s-%d", time.Now().Format("20060102-150405"), n)
+			}
+		}
+		exportDir := filepath.Join(workingPath, "downloads", exportID)
+		contentRoot := filepath.Join(exportDir, "raw", "Takeout", "Google Photos")
+		if state.Cursor.IsZero() {
+			logger.Info("☁️  Listing the whole library (first run)...")
+		} else {
+			logger.Info("☁️  Listing items created since %s...", state.Cursor.Local().Format("02/01/2006 15:04"))
+		}
+
+		// Names already used by the latest snapshot or by exports not backed up yet must not
+		// be reused for other items
+		prevRoot := ""
+		if prev := findLatestBackup(getBackupPath()); prev != "" {
+			prevRoot = filepath.Join(prev, "Google Photos")
+		}
+		takenRoots := []string{contentRoot}
+		if prevRoot != "" {
+			takenRoots = append(takenRoots, prevRoot)
+		}
+		if entries, err := os.ReadDir(filepath.Join(workingPath, "downloads")); err == nil {
+			for _, e := range entries {
+				if e.IsDir() && strings.HasPrefix(e.Name(), "api-") && e.Name() != exportID {
+					takenRoots = append(takenRoots, filepath.Join(workingPath, "downloads", e.Name(), "raw", "Takeout", "Google Photos"))
+				}
+			}
+		}
+
+		// 3. Page and download
+		downloadGuard := spaceGuard(exportDir, "downloads")
+		count, known := 0, 0
+		var bytes int64
+		err = client.ListSince(ctx, state.Cursor, state.CursorIDs, func(item photosapi.MediaItem) error {
+			created := item.MediaMetadata.CreationTime
+			if limit > 0 && count >= limit {
+				return errAPILimit
+			}
+			count++
+			if dryRun {
+				logger.Info("[Dry Run] %s (%s)", item.Filename, created.Local().Format("2006-01-02 15:04"))
+				return nil
+			}
+
+			if state.Pending == "" {
+				state.Pending = exportID
+			}
+			yearDir := fmt.Sprintf("Photos from %d", created.Local().Year())
+			name := uniqueMediaName(takenRoots, yearDir, item.Filename)
+			dest := filepath.Join(contentRoot, yearDir, name)
+
+			if err := downloadGuard.Ensure(ctx, 0); err != nil {
+				return err
+			}
+			n, err := client.Download(ctx, item, dest)
+			if err != nil {
+				return fmt.Errorf("%s: %w", item.Filename, err)
+			}
+			os.Chtimes(dest, created, created)
+
+			// Already in the backup under its own name (e.g. from a Takeout export)
+			prevFile := filepath.Join(prevRoot, yearDir, item.Filename)
+			if prevRoot != "" && name != item.Filename && sameContent(dest, prevFile) {
+				os.Remove(dest)
+				known++
+			} else {
+				if err := writeSidecar(dest+".json", item); err != nil {
+					return err
+				}
+				bytes += n
+				logger.Info("⬇️  %s (%s)", filepath.Join(yearDir, name), browser.FormatSize(n))
+			}
+
+			// Advance the cursor after every item so an interruption loses nothing
+			if created.After(state.Cursor) {
+				state.Cursor = created
+				state.CursorIDs = nil
+			}
+			state.CursorIDs = append(state.CursorIDs, item.ID)
+			return state.save(statePath)
+		})
+		limited := err == errAPILimit
+		if err != nil && !limited {
+			logger.Error("api-sync stopped: %v", err)
+			if count > 0 && !dryRun {
+				logger.Info("Downloaded items are kept; the next run continues export %s.", exportID)
+			}
+			return failed(exitSync, err)
+		}
+		if dryRun {
+			logger.Info("[Dry Run] %d new items.", count)
+			return nil
+		}
+		if known > 0 {
+			logger.Info("%d items were already in the backup.", known)
+		}
+		if state.Pending == "" || countFiles(contentRoot) == 0 {
+			if state.Pending != "" {
+				os.RemoveAll(exportDir)
+				state.Pending = ""
+				state.save(statePath)
+			}
+			logger.Info("✅ Library is up to date.")
+			return nil
+		}
+
+		// 4. Publish the export: synthetic state.json (no archives) + history entry
+		downloadState := registry.DownloadState{ID: exportID, LastUpdated: time.Now(), Incremental: true}
+		if err := downloadState.Save(filepath.Join(exportDir, "state.json")); err != nil {
+			return logFailed(exitSync, fmt.Errorf("failed to write state.json: %w", err))
+		}
+		reg, err := registry.New(filepath.Join(workingPath, "history.json"))
+		if err != nil {
+			return logFailed(exitSync, fmt.Errorf("failed to load history: %w", err))
+		}
+		if reg.Get(exportID) == nil {
+			reg.Add(registry.ExportEntry{ID: exportID, RequestedAt: time.Now(), Status: registry.StatusDownloaded, DownloadMode: apiDownloadMode})
+		}
+		entry := reg.Get(exportID)
+		entry.Status = registry.StatusDownloaded
+		entry.CompletedAt = time.Now()
+		entry.FileCount = countFiles(contentRoot) / 2 // Media + sidecar
+		if err := reg.Update(*entry); err != nil {
+			logger.Error("%v", err)
+		}
+		if err := reg.Save(); err != nil {
+			return logFailed(exitSync, fmt.Errorf("failed to save history: %w", err))
+		}
+
+		state.Pending = ""
+		if err := state.save(statePath); err != nil {
+			return logFailed(exitSync, fmt.Errorf("failed to save %s: %w", statePath, err))
+		}
+		if limited {
+			logger.Info("⏸️  Stopped after %d items (--limit). Run again to continue.", limit)
+		}
+		logger.Info("✅ Export %s ready: %d new items (%s). Run 'process' and 'update-backup'.", exportID, entry.FileCount, browser.FormatSize(bytes))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(apiSyncCmd)
+	apiSyncCmd.Flags().Bool("login", false, "Authorize again even if a token exists")
+	apiSyncCmd.Flags().Bool("device", false, "Use the device code flow (headless machines)")
+	apiSyncCmd.Flags().Int("limit", 0, "Download at most this many items")
+	apiSyncCmd.Flags().Bool("dry-run", false, "Only list the new items")
+}
+
+// apiLogin obtains a token with the loopback flow (browser on this machine) or the device flow
+func apiLogin(ctx context.Context, oauth *photosapi.OAuth, device bool) (*photosapi.Token, error) {
+	if device {
+		return oauth.DeviceLogin(ctx, func(userCode, verificationURL string) {
+			logger.Info("🔑 Visit %s and enter the code: %s", verificationURL, userCode)
+		})
+	}
+	return oauth.LoopbackLogin(ctx, func(authURL string) {
+		logger.Info("🔑 Opening the browser to authorize access. If it does not open, visit:\n%s", authURL)
+		utils.OpenBrowser(authURL)
+	})
+}
+
+func loadAPISyncState(path string) (*apiSyncState, error) {
+	state := &apiSyncState{}
+	err := persist.Load(path, func(data []byte) error {
+		*state = apiSyncState{}
+		return json.Unmarshal(data, state)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *apiSyncState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return persist.WriteState(path, data, 0644)
+}
+
+// uniqueMediaName returns name, or "name(n).ext" like Takeout does when <root>/<dir>/name
+// is already taken by another item in any of roots.
+func uniqueMediaName(roots []string, dir, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for n := 1; ; n++ {
+		taken := false
+		for _, root := range roots {
+			if _, err := os.Lstat(filepath.Join(root, dir, candidate)); err == nil {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			return candidate
+		}
+		candidate = base + "(" + strconv.Itoa(n) + ")" + ext
+	}
+}
+
+// writeSidecar writes a Takeout-style JSON sidecar so 'process' dates the file as usual
+func writeSidecar(path string, item photosapi.MediaItem) error {
+	created := item.MediaMetadata.CreationTime
+	ts := map[string]string{
+		"timestamp": strconv.FormatInt(created.Unix(), 10),
+		"formatted": created.UTC().Format("Jan 2, 2006, 3:04:05 PM UTC"),
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"title":          item.Filename,
+		"description":    item.Description,
+		"creationTime":   ts,
+		"photoTakenTime": ts,
+		"googlePhotosId": item.ID,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// countFiles returns the number of regular files below root
+func countFiles(root string) int {
+	count := 0
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+// sameContent reports whether two files exist and have the same hash
+func sameContent(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA != nil || errB != nil || infoA.Size() != infoB.Size() {
+		return false
+	}
+	hashA, errA := calculateHash(a)
+	hashB, errB := calculateHash(b)
+	return errA == nil && errB == nil && hashA == hashB
+}
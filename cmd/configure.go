@@ -16,8 +16,9 @@ import (
 )
 
 var configureCmd = &cobra.Command{
-	Use:   "configure",
-	Short: "Configure credentials and directories",
+	Use:         "configure",
+	Short:       "Configure credentials and directories",
+	Annotations: locks(lockWorking),
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("========================================")
 		fmt.Println(i18n.T("header_title"))
@@ -30,6 +31,17 @@ var configureCmd = &cobra.Command{
 		fmt.Println("========================================")
 		fmt.Println("")
 
+		// Account profiles share the global settings: only log in to the account's browser profile
+		if config.ActiveAccount != "" {
+			fmt.Printf("Account: %s (%s)\n", config.ActiveAccount, config.AppConfig.WorkingPath)
+			if err := os.MkdirAll(config.AppConfig.WorkingPath, 0755); err != nil {
+				fmt.Printf(i18n.T("error_mkdir")+"\n", err)
+				return
+			}
+			loginFlow(config.AppConfig.WorkingPath)
+			return
+		}
+
 		// 1. Working Dir (Download/Process)
 		workingPath := prompt(i18n.T("prompt_working_dir"), config.AppConfig.WorkingPath)
 		absWorkingPath, _ := filepath.Abs(workingPath)
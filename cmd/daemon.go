@@ -0,0 +1,434 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/registry"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// daemon.go runs the pipeline on a schedule in one long-running process. Each cycle takes
+// the run locks and calls runPipeline, so it resumes run_state.json exactly like 'run';
+// between cycles the locks are released and manual commands can run. The daemon state is
+// served as JSON on a unix socket, which 'status' reads.
+
+// Daemon states
+const (
+	daemonStarting = "starting"
+	daemonRunning  = "running"
+	daemonSleeping = "sleeping"
+	daemonQuiet    = "quiet" // Inside daemon_quiet_hours
+	daemonStopping = "stopping"
+)
+
+// daemonStatus is what the daemon serves on its socket
+type daemonStatus struct {
+	PID       int        `json:"pid"`
+	StartedAt time.Time  `json:"started_at"`
+	State     string     `json:"state"`
+	Stage     string     `json:"stage,omitempty"` // While running
+	NextRunAt time.Time  `json:"next_run_at,omitempty"`
+	PollDelay string     `json:"poll_delay"` // Next delay while Takeout prepares an export
+	Cycles    int        `json:"cycles"`
+	LastRun   *daemonRun `json:"last_run,omitempty"`
+}
+
+// daemonRun is the outcome of the last cycle
+type daemonRun struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Downloaded []string  `json:"downloaded_exports,omitempty"`
+	Snapshot   string    `json:"snapshot,omitempty"`
+	Waiting    bool      `json:"waiting"` // Takeout was preparing an export
+	Error      string    `json:"error,omitempty"`
+	ExitCode   int       `json:"exit_code"`
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run the pipeline on a schedule in a long-running process",
+	Long: `Runs sync, process, update-backup and the Immich master update like 'run', on a schedule:
+
+  - after a complete run, the next one is due backup_frequency after the last
+    downloaded export, snapshotted or not (checked again at least every
+    daemon_poll_interval);
+  - while Takeout prepares an export, or after a failure, the next check comes after
+    daemon_poll_interval, doubling up to daemon_poll_max;
+  - no run starts inside daemon_quiet_hours (e.g. "23:00-07:00,12:00-13:00", local time),
+    and a run still going when they begin is stopped like on SIGTERM and resumed after them.
+
+The run locks are only held while a cycle runs. On SIGTERM or Ctrl-C the current download
+saves its state.json and the browser is closed; process and update-backup finish the
+running stage (a second signal kills the process). The next start resumes from
+run_state.json.
+
+The daemon state is served on the unix socket daemon_socket (default
+<working_path>/daemon.sock) and shown by 'status'.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		workingPath := getWorkingPath()
+		if workingPath == "" || getBackupPath() == "" {
+			return logFailed(exitConfig, fmt.Errorf("working_path and backup_path must be configured (run 'configure')"))
+		}
+		quiet, err := parseQuietHours(viper.GetString("daemon_quiet_hours"))
+		if err != nil {
+			return logFailed(exitConfig, fmt.Errorf("invalid daemon_quiet_hours: %w", err))
+		}
+		pollMin := viper.GetDuration("daemon_poll_interval")
+		pollMax := viper.GetDuration("daemon_poll_max")
+		if pollMin <= 0 {
+			return logFailed(exitConfig, fmt.Errorf("daemon_poll_interval must be positive"))
+		}
+		if pollMax < pollMin {
+			pollMax = pollMin
+		}
+		if err := os.MkdirAll(workingPath, 0755); err != nil {
+			return logFailed(exitConfig, err)
+		}
+
+		// No progress bars or prompts in the background
+		viper.Set("non_interactive", true)
+
+		d := &daemon{
+			status:  daemonStatus{PID: os.Getpid(), StartedAt: time.Now(), State: daemonStarting},
+			quiet:   quiet,
+			pollMin: pollMin,
+			pollMax: pollMax,
+			delay:   pollMin,
+		}
+		d.status.PollDelay = pollMin.String()
+
+		socketPath := getDaemonSocket(workingPath)
+		if running, err := readDaemonStatus(socketPath); err == nil {
+			return logFailed(exitLocked, fmt.Errorf("a daemon (pid %d) is already running, status on %s", running.PID, socketPath))
+		}
+		listener, err := listenDaemonSocket(socketPath)
+		if err != nil {
+			return logFailed(exitFailure, err)
+		}
+		defer listener.Close() // Also removes the socket file
+		go d.serve(listener)
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			d.update(func(s *daemonStatus) { s.State = daemonStopping })
+			logger.Info("⏹️  Shutting down (send the signal again to force)...")
+			stop() // A second signal gets the default behaviour
+		}()
+
+		logger.Info("🤖 Daemon started (pid %d), status on %s", os.Getpid(), socketPath)
+		d.loop(ctx)
+		logger.Info("👋 Daemon stopped")
+		return nil
+	},
+}
+
+// daemon holds the scheduler state; status is guarded by mu for the socket server
+type daemon struct {
+	mu     sync.Mutex
+	status daemonStatus
+
+	quiet            []quietWindow
+	pollMin, pollMax time.Duration
+	delay            time.Duration // Current backoff
+}
+
+func (d *daemon) update(edit func(*daemonStatus)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	edit(&d.status)
+}
+
+// loop waits out the quiet hours, runs a cycle and sleeps until the next one is due
+func (d *daemon) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		if end, ok := quietUntil(time.Now(), d.quiet); ok {
+			logger.Info("🌙 Quiet hours until %s", end.Format("02/01/2006 15:04"))
+			d.update(func(s *daemonStatus) { s.State, s.NextRunAt = daemonQuiet, end })
+			if !sleepUntil(ctx, end) {
+				return
+			}
+			continue
+		}
+
+		next := d.cycle(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Info("💤 Next check at %s", next.Format("02/01/2006 15:04"))
+		d.update(func(s *daemonStatus) { s.State, s.Stage, s.NextRunAt = daemonSleeping, "", next })
+		if !sleepUntil(ctx, next) {
+			return
+		}
+	}
+}
+
+// cycle runs the pipeline once and returns when the next run is due
+func (d *daemon) cycle(ctx context.Context) time.Time {
+	run := &daemonRun{StartedAt: time.Now()}
+	d.update(func(s *daemonStatus) { s.State, s.NextRunAt = daemonRunning, time.Time{} })
+
+	// The run stops at the next quiet window like on shutdown
+	runCtx := ctx
+	if start, ok := nextQuietStart(run.StartedAt, d.quiet); ok {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithDeadline(ctx, start)
+		defer cancel()
+	}
+
+	var state *runState
+	err := lockScopes([]string{lockWorking, lockBackup}, "daemon", false)
+	if err == nil {
+		state, err = runPipeline(pipelineOptions{
+			Context: runCtx,
+			OnStage: func(stage string) { d.update(func(s *daemonStatus) { s.Stage = stage }) },
+		})
+		releaseLocks()
+	} else {
+		logger.Info("⏳ %v. Trying again later.", err)
+	}
+
+	run.FinishedAt = time.Now()
+	if state != nil {
+		run.Downloaded = state.Downloaded
+		if state.Snapshot != "" {
+			run.Snapshot = filepath.Base(state.Snapshot)
+		}
+		run.Waiting = state.Waiting
+	}
+	if err != nil {
+		run.Error = err.Error()
+		run.ExitCode = exitCode(err)
+	}
+
+	// Stopped by the quiet hours: the loop waits them out and resumes the run
+	if errors.Is(err, errInterrupted) && ctx.Err() == nil {
+		logger.Info("🌙 Quiet hours started, the run resumes after them")
+		d.update(func(s *daemonStatus) {
+			s.Cycles++
+			s.LastRun = run
+		})
+		return run.FinishedAt
+	}
+
+	// The deletion alert is reported, but the snapshot is complete
+	retry := (err != nil && run.ExitCode != exitDeletionAlert) || run.Waiting
+	next := d.nextRun(run.FinishedAt, retry)
+	d.update(func(s *daemonStatus) {
+		s.Cycles++
+		s.LastRun = run
+		s.PollDelay = d.delay.String()
+	})
+	return next
+}
+
+// nextRun schedules the next cycle: with backoff while an export is pending or failing,
+// otherwise when backup_frequency is due again
+func (d *daemon) nextRun(now time.Time, retry bool) time.Time {
+	if retry {
+		next := now.Add(d.delay)
+		d.delay *= 2
+		if d.delay > d.pollMax {
+			d.delay = d.pollMax
+		}
+		return next
+	}
+	d.delay = d.pollMin
+
+	// Check at least every poll interval the pipeline has nothing to resume
+	next := now.Add(d.pollMin)
+	reg, err := registry.New(filepath.Join(getWorkingPath(), "history.json"))
+	if err != nil {
+		return next
+	}
+	if last := lastDownloadedExport(reg, getWorkingPath()); last != nil {
+		if due := last.CompletedAt.Add(viper.GetDuration("backup_frequency")); due.After(next) {
+			next = due
+		}
+	}
+	return next
+}
+
+// serve writes the daemon status as one JSON object to every connection
+func (d *daemon) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return // Listener closed
+		}
+		d.mu.Lock()
+		data, _ := json.Marshal(d.status)
+		d.mu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+		conn.Write(append(data, '\n'))
+		conn.Close()
+	}
+}
+
+// sleepUntil waits until t (wall clock, so a suspend does not delay it) and reports
+// false if ctx was cancelled first
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	for {
+		wait := time.Until(t)
+		if wait <= 0 {
+			return true
+		}
+		if wait > time.Minute {
+			wait = time.Minute
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+// getDaemonSocket returns daemon_socket or <working_path>/daemon.sock
+func getDaemonSocket(workingPath string) string {
+	if path := viper.GetString("daemon_socket"); path != "" {
+		return expandPath(path)
+	}
+	return filepath.Join(workingPath, "daemon.sock")
+}
+
+// listenDaemonSocket listens on path, replacing a socket left by a daemon that died
+func listenDaemonSocket(path string) (net.Listener, error) {
+	if _, err := os.Lstat(path); err == nil {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("cannot remove stale socket %s: %w", path, err)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// readDaemonStatus asks the daemon listening on path for its status
+func readDaemonStatus(path string) (*daemonStatus, error) {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var status daemonStatus
+	if err := json.NewDecoder(conn).Decode(&status); err != nil {
+		return nil, fmt.Errorf("invalid daemon status: %w", err)
+	}
+	return &status, nil
+}
+
+// quietWindow is a daily time range in minutes after midnight; end < start wraps midnight
+type quietWindow struct {
+	start, end int
+}
+
+// parseQuietHours parses comma-separated "HH:MM-HH:MM" windows
+func parseQuietHours(s string) ([]quietWindow, error) {
+	var windows []quietWindow
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("%q is not HH:MM-HH:MM", part)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("%q is empty", part)
+		}
+		windows = append(windows, quietWindow{start: start, end: end})
+	}
+	return windows, nil
+}
+
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(strings.TrimSpace(s), ":")
+	hours, err1 := strconv.Atoi(h)
+	minutes, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hours < 0 || hours > 24 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// quietUntil reports whether now is inside a quiet window and when the quiet period ends
+// (following windows that start as another ends)
+func quietUntil(now time.Time, windows []quietWindow) (time.Time, bool) {
+	end, quiet := now, false
+	for range len(windows) + 1 {
+		t, ok := windowEnd(end, windows)
+		if !ok {
+			break
+		}
+		end, quiet = t, true
+	}
+	return end, quiet
+}
+
+// windowEnd returns the end of the window containing t
+func windowEnd(t time.Time, windows []quietWindow) (time.Time, bool) {
+	at := func(day, minutes int) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day()+day, minutes/60, minutes%60, 0, 0, t.Location())
+	}
+	// Wall clock, not the time since midnight: the two differ on DST change days
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range windows {
+		switch {
+		case w.start < w.end && minute >= w.start && minute < w.end:
+			return at(0, w.end), true
+		case w.start > w.end && minute >= w.start:
+			return at(1, w.end), true
+		case w.start > w.end && minute < w.end:
+			return at(0, w.end), true
+		}
+	}
+	return t, false
+}
+
+// nextQuietStart returns when the first quiet window after now begins
+func nextQuietStart(now time.Time, windows []quietWindow) (time.Time, bool) {
+	var next time.Time
+	for _, w := range windows {
+		start := time.Date(now.Year(), now.Month(), now.Day(), w.start/60, w.start%60, 0, 0, now.Location())
+		if !start.After(now) {
+			start = time.Date(now.Year(), now.Month(), now.Day()+1, w.start/60, w.start%60, 0, 0, now.Location())
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+}
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google-photos-backup/internal/i18n"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/processor"
+	"google-photos-backup/internal/registry"
+
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:           "diff [snapA] [snapB]",
+	Short:         "Show what changed between two snapshots",
+	Long:          `Compares the index.json of two snapshots and classifies every entry as added, removed, modified (same path, different hash), moved (same hash, different path) or metadata-only (same hash, different mtime). Without arguments it compares the two latest Takeout snapshots, and with one it compares that snapshot to the latest of them; 'ingest' snapshots are only compared when named.`,
+	Args:          cobra.MaximumNArgs(2),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupPath := getBackupPath()
+		if backupPath == "" {
+			logger.Error(i18n.T("update_backup_no_config"))
+			return failed(exitConfig, fmt.Errorf("backup_path is not set"))
+		}
+
+		format, _ := cmd.Flags().GetString("format")
+		if format != "text" && format != "json" && format != "csv" {
+			return logFailed(exitConfig, fmt.Errorf("unknown format '%s' (expected text, json or csv)", format))
+		}
+
+		snapshots, err := listSnapshots(backupPath)
+		if err != nil {
+			return logFailed(exitFailure, fmt.Errorf("error reading backup dir: %w", err))
+		}
+		// An ingest holds a different folder, not a later state of the library
+		snapshots = slices.DeleteFunc(snapshots, isIngestSnapshot)
+
+		// Resolve which snapshots to compare
+		var older, newer string
+		switch len(args) {
+		case 0:
+			if len(snapshots) < 2 {
+				logger.Info("⚠️  Need at least two snapshots to compare.")
+				return nil
+			}
+			older, newer = snapshots[len(snapshots)-2], snapshots[len(snapshots)-1]
+		case 1:
+			if len(snapshots) == 0 {
+				logger.Info("⚠️  No snapshots found.")
+				return nil
+			}
+			older, newer = args[0], snapshots[len(snapshots)-1]
+		default:
+			older, newer = args[0], args[1]
+		}
+
+		olderPath := resolveSnapshotPath(backupPath, older)
+		newerPath := resolveSnapshotPath(backupPath, newer)
+
+		olderIdx, err := loadSnapshotIndex(olderPath)
+		if err != nil {
+			return logFailed(exitFailure, fmt.Errorf("failed to load index for %s: %w", older, err))
+		}
+		defer olderIdx.Close()
+		newerIdx, err := loadSnapshotIndex(newerPath)
+		if err != nil {
+			return logFailed(exitFailure, fmt.Errorf("failed to load index for %s: %w", newer, err))
+		}
+		defer newerIdx.Close()
+
+		diff, err := registry.DiffIndexes(olderIdx, newerIdx)
+		if err != nil {
+			return logFailed(exitFailure, fmt.Errorf("failed to compare %s and %s: %w", older, newer, err))
+		}
+
+		switch format {
+		case "json":
+			out := struct {
+				From string `json:"from"`
+				To   string `json:"to"`
+				*registry.IndexDiff
+			}{filepath.Base(olderPath), filepath.Base(newerPath), diff}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(out); err != nil {
+				return logFailed(exitFailure, fmt.Errorf("failed to write JSON: %w", err))
+			}
+		case "csv":
+			w := csv.NewWriter(os.Stdout)
+			w.Write([]string{"type", "path", "old_path", "hash", "old_hash", "size", "mod_time", "old_mod_time"})
+			for _, c := range diff.Changes {
+				oldTime := ""
+				if c.OldTime != nil {
+					oldTime = c.OldTime.Format(time.RFC3339)
+				}
+				w.Write([]string{
+					string(c.Type), c.Path, c.OldPath, c.Hash, c.OldHash,
+					strconv.FormatInt(c.Size, 10), c.ModTime.Format(time.RFC3339), oldTime,
+				})
+			}
+			w.Flush()
+			if err := w.Error(); err != nil {
+				return logFailed(exitFailure, fmt.Errorf("failed to write CSV: %w", err))
+			}
+		default:
+			printDiffText(filepath.Base(olderPath), filepath.Base(newerPath), diff)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().String("format", "text", "Output format: text, json or csv")
+}
+
+// resolveSnapshotPath accepts a snapshot name (relative to backupPath) or a path
+func resolveSnapshotPath(backupPath, snap string) string {
+	if strings.ContainsRune(snap, os.PathSeparator) {
+		return expandPath(snap)
+	}
+	return filepath.Join(backupPath, snap)
+}
+
+// loadSnapshotIndex loads the index.json of a snapshot, failing if it was never generated
+func loadSnapshotIndex(snapPath string) (*registry.Index, error) {
+	indexPath := filepath.Join(snapPath, "index.json")
+	if _, err := os.Stat(indexPath); err != nil {
+		return nil, fmt.Errorf("no index.json in %s (run 'rebuild-index' first): %w", snapPath, err)
+	}
+	return registry.LoadIndex(indexPath)
+}
+
+// snapshotIndex returns the index of an existing snapshot without rewriting it: a sealed
+// snapshot keeps the index.json (and manifests) it was sealed with. Only a legacy snapshot
+// without index.json is indexed.
+func snapshotIndex(snapPath string) (*registry.Index, error) {
+	if _, err := os.Stat(filepath.Join(snapPath, "index.json")); os.IsNotExist(err) {
+		return processor.EnsureSnapshotIndex(snapPath)
+	}
+	return loadSnapshotIndex(snapPath)
+}
+
+func printDiffText(from, to string, diff *registry.IndexDiff) {
+	fmt.Printf("📊 Diff %s -> %s\n", from, to)
+	for _, c := range diff.Changes {
+		switch c.Type {
+		case registry.ChangeAdded:
+			fmt.Printf("  + %s\n", c.Path)
+		case registry.ChangeRemoved:
+			fmt.Printf("  - %s\n", c.Path)
+		case registry.ChangeModified:
+			fmt.Printf("  ~ %s\n", c.Path)
+		case registry.ChangeMoved:
+			fmt.Printf("  > %s -> %s\n", c.OldPath, c.Path)
+		case registry.ChangeMetadataOnly:
+			fmt.Printf("  @ %s (%s -> %s)\n", c.Path, c.OldTime.Format("2006-01-02 15:04:05"), c.ModTime.Format("2006-01-02 15:04:05"))
+		}
+	}
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Printf("Added: %d | Removed: %d | Modified: %d | Moved: %d | Metadata-only: %d | Unchanged: %d\n",
+		diff.Count(registry.ChangeAdded),
+		diff.Count(registry.ChangeRemoved),
+		diff.Count(registry.ChangeModified),
+		diff.Count(registry.ChangeMoved),
+		diff.Count(registry.ChangeMetadataOnly),
+		diff.Unchanged)
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"errors"
+
+	"google-photos-backup/internal/diskspace"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/persist"
+)
+
+// Exit codes, one per failure class, so cron and the run command can tell what went wrong
+const (
+	exitOK            = 0
+	exitFailure       = 1  // Unclassified error
+	exitConfig        = 2  // Missing or invalid configuration
+	exitSync          = 3  // Takeout request, status check or download failed
+	exitProcess       = 4  // Extraction / processing failed
+	exitBackup        = 5  // Snapshot could not be created or sealed
+	exitDiskSpace     = 6  // Not enough free space (see min_free_space)
+	exitDeletionAlert = 7  // Backup done, but too many files disappeared upstream
+	exitLocked        = 8  // Another run holds the lock on working_path or backup_path
+	exitCorrupt       = 9  // A state file is corrupt (see --recover)
+	exitIntegrity     = 10 // Backed-up data failed verification (scrub, verify-*, mirror)
+)
+
+// stageError is returned by the pipeline stages. The cause has already been logged
+// where it happened; the error only carries it and the exit code.
+type stageError struct {
+	code int
+	err  error
+}
+
+func (e *stageError) Error() string { return e.err.Error() }
+func (e *stageError) Unwrap() error { return e.err }
+
+// failed wraps err with the exit code of its class. Free-space errors and corrupt state
+// files always map to exitDiskSpace and exitCorrupt, whatever stage they come from.
+func failed(code int, err error) error {
+	var insufficient *diskspace.InsufficientError
+	var corrupt *persist.CorruptError
+	switch {
+	case errors.As(err, &insufficient):
+		code = exitDiskSpace
+	case errors.As(err, &corrupt):
+		code = exitCorrupt
+	}
+	return &stageError{code: code, err: err}
+}
+
+// logFailed logs err and wraps it like failed, for errors not logged where they happened
+func logFailed(code int, err error) error {
+	logger.Error("%v", err)
+	return failed(code, err)
+}
+
+// exitCode returns the process exit code for an error returned by a command
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var se *stageError
+	if errors.As(err, &se) {
+		return se.code
+	}
+	var corrupt *persist.CorruptError
+	if errors.As(err, &corrupt) {
+		return exitCorrupt
+	}
+	return exitFailure
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google-photos-backup/internal/catalog"
+	"google-photos-backup/internal/integrity"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/processor"
+	"google-photos-backup/internal/registry"
+
+	"github.com/spf13/cobra"
+)
+
+var exportCatalogCmd = &cobra.Command{
+	Use:   "export-catalog",
+	Short: "Write a SQLite catalog of exports, snapshots and files",
+	Long: `Writes history.json, the download states, every snapshot index and the Google sidecars
+to a SQLite database that can be queried with any SQLite client. The schema is documented
+in internal/catalog/schema.go (tables exports, archives, snapshots, snapshot_entries,
+albums, files and metadata).
+
+Refreshes are incremental: exports are always re-synced, snapshots only when their
+index.json changed, so it is cheap to run after each 'update-backup'. --full rebuilds
+the catalog from scratch.
+
+Example queries:
+  -- Videos taken in 2015 larger than 1 GB
+  SELECT f.example_path, f.size FROM files f JOIN metadata m USING (hash)
+  WHERE f.media_type = 'video' AND m.taken_at LIKE '2015-%' AND f.size > 1e9;
+
+  -- Albums containing a given content
+  SELECT DISTINCT album FROM snapshot_entries WHERE hash = '<sha256>' AND album != '';
+
+  -- Photos and videos without a sidecar
+  SELECT f.example_path FROM files f LEFT JOIN metadata m USING (hash)
+  WHERE f.media_type IN ('photo', 'video') AND m.sidecar IS NULL;`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dbPath, _ := cmd.Flags().GetString("sqlite")
+		full, _ := cmd.Flags().GetBool("full")
+		backupPath := getBackupPath()
+		workingPath := getWorkingPath()
+		if backupPath == "" && workingPath == "" {
+			return logFailed(exitConfig, fmt.Errorf("neither backup_path nor working_path is configured"))
+		}
+
+		cat, err := catalog.Open(expandPath(dbPath), full)
+		if err != nil {
+			return logFailed(exitFailure, fmt.Errorf("cannot open catalog: %w", err))
+		}
+		defer cat.Close()
+
+		if workingPath != "" {
+			exports, err := catalogExports(workingPath)
+			if err != nil {
+				return logFailed(exitFailure, err)
+			}
+			if err := cat.SyncExports(exports); err != nil {
+				return logFailed(exitFailure, fmt.Errorf("cannot write exports: %w", err))
+			}
+			logger.Info("📋 %d exports", len(exports))
+		}
+
+		if backupPath != "" {
+			if err := catalogSnapshots(cat, backupPath); err != nil {
+				return logFailed(exitFailure, err)
+			}
+		}
+
+		if err := cat.Finish(); err != nil {
+			return logFailed(exitFailure, fmt.Errorf("cannot update files: %w", err))
+		}
+		for key, value := range map[string]string{
+			"backup_path":  backupPath,
+			"working_path": workingPath,
+			"refreshed_at": time.Now().UTC().Format(time.RFC3339),
+		} {
+			if err := cat.SetInfo(key, value); err != nil {
+				return logFailed(exitFailure, err)
+			}
+		}
+
+		snapshots, files, err := cat.Stats()
+		if err != nil {
+			return logFailed(exitFailure, err)
+		}
+		logger.Info("🎉 Catalog %s: %d snapshots, %d unique files.", dbPath, snapshots, files)
+		return nil
+	},
+}
+
+// catalogExports collects the history entries with their archives and processing status
+func catalogExports(workingPath string) ([]catalog.Export, error) {
+	reg, err := registry.New(filepath.Join(workingPath, "history.json"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load history: %w", err)
+	}
+
+	outputDir := getOutputDir(workingPath)
+	processed := make(map[string]bool)
+	if state, err := processor.ReadState(outputDir); err == nil {
+		processed = state.ProcessedExports
+	}
+
+	var exports []catalog.Export
+	for _, entry := range reg.Exports {
+		if entry.ID == "" {
+			continue // Requested, not created by Google yet
+		}
+		e := catalog.Export{
+			ExportEntry: entry,
+			Extracted:   make(map[string]bool),
+			Processed:   processed[entry.ID] || entry.Extracted(),
+		}
+		exportDir := filepath.Join(workingPath, "downloads", entry.ID)
+		if state, err := registry.LoadDownloadState(filepath.Join(exportDir, "state.json")); err == nil {
+			e.Archives = state.Files
+			e.Incremental = state.Incremental
+		}
+		if state, err := processor.ReadState(exportDir); err == nil {
+			for _, f := range e.Archives {
+				e.Extracted[f.Filename] = state.ProcessedArchives[entry.ID+"/"+f.Filename]
+			}
+		}
+		exports = append(exports, e)
+	}
+	return exports, nil
+}
+
+// catalogSnapshots loads the snapshots whose index changed and drops the deleted ones
+func catalogSnapshots(cat *catalog.Catalog, backupPath string) error {
+	names, err := listSnapshots(backupPath)
+	if err != nil {
+		return fmt.Errorf("cannot read backup dir: %w", err)
+	}
+	logEntries := make(map[string]BackupLogEntry)
+	if entries, err := readBackupLog(backupPath); err == nil {
+		for _, entry := range entries {
+			logEntries[filepath.Base(entry.Snapshot)] = entry
+		}
+	}
+
+	loaded, unchanged, missing := 0, 0, 0
+	for _, name := range names {
+		snapPath := filepath.Join(backupPath, name)
+		info, err := os.Stat(filepath.Join(snapPath, "index.json"))
+		if err != nil {
+			logger.Info("⚠️  Snapshot %s has no index.json (run 'rebuild-index'). Skipping.", name)
+			missing++
+			continue
+		}
+
+		snap := catalog.Snapshot{
+			Name:      name,
+			Path:      snapPath,
+			Kind:      "takeout",
+			Signature: fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano()),
+		}
+		if isIngestSnapshot(name) {
+			snap.Kind = "ingest"
+		}
+		if t, err := time.ParseInLocation("2006-01-02-150405", name[:len("2006-01-02-150405")], time.Local); err == nil {
+			snap.TakenAt = t
+		}
+		if _, err := os.Stat(filepath.Join(snapPath, integrity.ChainFileName)); err == nil {
+			snap.Sealed = true
+		}
+		if entry, ok := logEntries[name]; ok {
+			snap.Source = entry.Source
+			snap.AddedCount = entry.Added
+			snap.AddedBytes = entry.Size
+			snap.DeletionAlert = entry.Alert
+		}
+
+		signature, err := cat.SnapshotSignature(name)
+		if err != nil {
+			return err
+		}
+		if signature == snap.Signature {
+			if err := cat.UpdateSnapshot(snap); err != nil {
+				return err
+			}
+			unchanged++
+			continue
+		}
+
+		idx, err := loadSnapshotIndex(snapPath)
+		if err != nil {
+			logger.Error("Cannot load index of %s: %v", name, err)
+			missing++
+			continue
+		}
+		logger.Info("📸 Cataloguing %s (%d files)", name, idx.Len())
+		err = cat.PutSnapshot(snap, idx)
+		idx.Close()
+		if err != nil {
+			return fmt.Errorf("cannot write snapshot %s: %w", name, err)
+		}
+		loaded++
+	}
+
+	removed, err := cat.RemoveSnapshotsExcept(names)
+	if err != nil {
+		return err
+	}
+	logger.Info("📸 Snapshots: %d loaded, %d unchanged, %d removed, %d without index", loaded, unchanged, removed, missing)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(exportCatalogCmd)
+	exportCatalogCmd.Flags().String("sqlite", "", "Path of the SQLite database to write")
+	exportCatalogCmd.Flags().Bool("full", false, "Rebuild the catalog from scratch instead of refreshing it")
+	exportCatalogCmd.MarkFlagRequired("sqlite")
+}
@@ -5,11 +5,12 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
-	"syscall"
 	"time"
 
 	"google-photos-backup/internal/config"
+	"google-photos-backup/internal/fsutil"
 	"google-photos-backup/internal/i18n"
+	"google-photos-backup/internal/kvindex"
 	"google-photos-backup/internal/logger"
 	"google-photos-backup/internal/registry"
 
@@ -18,9 +19,10 @@ import (
 )
 
 var fixHardlinksCmd = &cobra.Command{
-	Use:   "fix-hardlinks",
-	Short: "Deduplicate final backup using index.json",
-	Long:  `Scans the final backup directory (containing timestamped snapshots) and utilizes the existing index.json files to identify and hardlink duplicate files across snapshots instantly.`,
+	Use:         "fix-hardlinks",
+	Short:       "Deduplicate final backup using index.json",
+	Long:        `Scans the final backup directory (containing timestamped snapshots) and utilizes the existing index.json files to identify and hardlink duplicate files across snapshots instantly.`,
+	Annotations: locks(lockBackup),
 	Run: func(cmd *cobra.Command, args []string) {
 		logger.Info("Starting Fix Hardlinks (Index-Based)...")
 
@@ -61,15 +63,27 @@ var fixHardlinksCmd = &cobra.Command{
 			return
 		}
 
-		// 2. Global Index: Map[Hash] -> OriginalPath
-		// We track the FIRST occurrence of a file content.
-		globalIndex := make(map[string]string)
+		// 2. Global Index: Hash+Generation -> OriginalPath
+		// We track the FIRST occurrence of each inode generation of a content. A new generation
+		// starts when the filesystem refuses more hardlinks to one inode (EMLINK).
+		// Kept on disk next to the snapshots: a backup can hold millions of files.
+		scratch, err := kvindex.OpenTemp[registry.FileIndexEntry](backupPath)
+		if err != nil {
+			logger.Error("Cannot create scratch index: %v", err)
+			return
+		}
+		defer scratch.Close()
+		globalIndex := contentIndex{scratch}
+		newest := make(map[string]int) // Hash -> newest generation (only split contents)
+		linker := fsutil.Default()
+		splitter := linker.Splitting()
 
 		// Stats
 		totalFiles := 0
 		dedupedCount := 0
 		savedBytes := int64(0)
 		snapshotsProcessed := 0
+		generationsStarted := 0
 
 		for _, snapName := range snapshots {
 			snapPath := filepath.Join(backupPath, snapName)
@@ -92,68 +106,108 @@ var fixHardlinksCmd = &cobra.Command{
 
 			snapTotal := 0
 			snapDedup := 0
+			indexChanged := false
 
 			// Iterate files in index
-			for relPath, fileData := range idx.Files {
+			err = idx.ForEach(func(relPath string, fileData registry.FileIndexEntry) error {
 				fullPath := filepath.Join(snapPath, relPath)
 				snapTotal++
 				totalFiles++
 
-				// Check if Hash is already known
-				if originalPath, found := globalIndex[fileData.Hash]; found {
-					// Duplicate content candidate!
-
-					// 1. Check if it's the SAME file (e.g. self-reference or same path)
-					if originalPath == fullPath {
-						continue
+				key := hashGeneration{Hash: fileData.Hash, Generation: fileData.Generation}
+				originalPath, found := globalIndex.original(key)
+				if !found {
+					// New unique content (or generation). Register it as the "Source of Truth".
+					// We verify it exists before registering, just in case index is stale.
+					if _, err := os.Stat(fullPath); err == nil {
+						globalIndex.register(key, fullPath, fileData)
+						if key.Generation > newest[key.Hash] {
+							newest[key.Hash] = key.Generation
+						}
 					}
+					return nil
+				}
 
-					// 2. Check if already hardlinked (Inode check)
-					// We need to stat the current file to check Inode
-					if areHardlinked(originalPath, fullPath) {
-						// Already optimized.
-						continue
-					}
+				// Duplicate content candidate!
+
+				// 1. Check if it's the SAME file (e.g. self-reference or same path)
+				if originalPath == fullPath {
+					return nil
+				}
 
-					// 3. Not hardlinked. Fix it.
+				// 2. Check if already linked (Inode check, or symlink with that strategy)
+				if linker.IsLinked(originalPath, fullPath) {
+					// Already optimized.
+					return nil
+				}
+
+				// Already sharing the newest generation (e.g. linked by update-backup after a split)
+				newestKey := hashGeneration{Hash: key.Hash, Generation: newest[key.Hash]}
+				newestPath, hasNewest := globalIndex.original(newestKey)
+				if hasNewest && newestKey != key && linker.IsLinked(newestPath, fullPath) {
 					if !dryRun {
-						// Remove duplicate
-						if err := os.Remove(fullPath); err != nil {
-							// If file doesn't exist (index desync?), skip
-							if !os.IsNotExist(err) {
-								logger.Error("Failed to remove duplicate %s: %v", fullPath, err)
-							}
-							continue
-						}
-						// Link to original
-						if err := os.Link(originalPath, fullPath); err != nil {
-							logger.Error("Failed to link %s -> %s: %v", fullPath, originalPath, err)
-						} else {
-							snapDedup++
-							dedupedCount++
-							savedBytes += fileData.Size
-
-							// Update the index? Content is same, Inode changed.
-							// Ideally we should update the index with the new Inode to avoid re-hashing later.
-							// But that requires saving the index. For now, let's just do the filesystem op.
-							// Next 'rebuild-index' will pick up the new Inode.
-						}
-					} else {
-						snapDedup++
-						dedupedCount++
-						savedBytes += fileData.Size
-						// logger.Info("Would link: %s -> %s", fullPath, originalPath)
+						setGeneration(idx, relPath, fullPath, newestKey.Generation)
+						indexChanged = true
 					}
+					return nil
+				}
 
-				} else {
-					// New unique content. Register it as the "Source of Truth".
-					// We verify it exists before registering, just in case index is stale.
-					if _, err := os.Stat(fullPath); err == nil {
-						globalIndex[fileData.Hash] = fullPath
-					}
+				// 3. Not linked. Fix it.
+				if dryRun {
+					snapDedup++
+					dedupedCount++
+					savedBytes += fileData.Size
+					return nil
+				}
+
+				// If file doesn't exist (index desync?), skip
+				if _, err := os.Lstat(fullPath); os.IsNotExist(err) {
+					return nil
+				}
+
+				// Replace duplicate with a link to original (atomic, never leaves a gap)
+				generation := key.Generation
+				_, err := splitter.Replace(originalPath, fullPath)
+				if fsutil.IsLinkLimit(err) && hasNewest && newestKey != key {
+					generation = newestKey.Generation
+					_, err = splitter.Replace(newestPath, fullPath)
+				}
+				if fsutil.IsLinkLimit(err) {
+					// Every generation is full: this file (already an independent copy) starts a new one
+					generation = newest[key.Hash] + 1
+					newest[key.Hash] = generation
+					globalIndex.register(hashGeneration{Hash: key.Hash, Generation: generation}, fullPath, fileData)
+					setGeneration(idx, relPath, fullPath, generation)
+					indexChanged = true
+					generationsStarted++
+					logger.Info("🔀 Link limit reached for %s: %s starts generation %d", key.Hash[:12], relPath, generation)
+					return nil
 				}
+				if err != nil {
+					logger.Error("Failed to link %s -> %s: %v", fullPath, originalPath, err)
+					return nil
+				}
+
+				snapDedup++
+				dedupedCount++
+				savedBytes += fileData.Size
+
+				// Content is same, Inode changed: record it to avoid re-hashing later
+				setGeneration(idx, relPath, fullPath, generation)
+				indexChanged = true
+				return nil
+			})
+			if err != nil {
+				logger.Error("Failed to read index for %s: %v", snapName, err)
 			}
 
+			if indexChanged {
+				if err := idx.Save(indexPath); err != nil {
+					logger.Error("Failed to save index for %s: %v", snapName, err)
+				}
+			}
+			idx.Close()
+
 			// logger.Info("   Snapshot stats: %d files, %d deduplicated", snapTotal, snapDedup)
 			snapshotsProcessed++
 		}
@@ -163,9 +217,51 @@ var fixHardlinksCmd = &cobra.Command{
 		logger.Info(i18n.T("fix_hardlinks_processed"), totalFiles)
 		logger.Info(i18n.T("fix_hardlinks_linked"), dedupedCount)
 		logger.Info(i18n.T("fix_hardlinks_saved"), formatSizeForBackup(savedBytes))
+		if generationsStarted > 0 {
+			logger.Info("   New link generations (EMLINK): %d", generationsStarted)
+		}
 	},
 }
 
+// hashGeneration identifies one inode generation of a content hash
+type hashGeneration struct {
+	Hash       string
+	Generation int
+}
+
+func (k hashGeneration) String() string {
+	return fmt.Sprintf("%s/%d", k.Hash, k.Generation)
+}
+
+// contentIndex maps each hash generation to the first file found with it
+type contentIndex struct {
+	*kvindex.Index[registry.FileIndexEntry]
+}
+
+func (c contentIndex) original(key hashGeneration) (string, bool) {
+	paths, err := c.PathsByHash(key.String())
+	if err != nil || len(paths) == 0 {
+		return "", false
+	}
+	return paths[0], true
+}
+
+func (c contentIndex) register(key hashGeneration, path string, entry registry.FileIndexEntry) {
+	if err := c.Put(path, key.String(), entry); err != nil {
+		logger.Error("Scratch index: %v", err)
+	}
+}
+
+// setGeneration records the generation and current inode of a file in its index entry
+func setGeneration(idx *registry.Index, relPath, fullPath string, generation int) {
+	entry, _ := idx.Get(relPath)
+	entry.Generation = generation
+	if key, err := statInode(fullPath); err == nil {
+		entry.Inode = key.Ino
+	}
+	idx.AddOrUpdate(entry)
+}
+
 func isTimestamp(name string) bool {
 	const format = "2006-01-02-150405"
 	if len(name) < len(format) {
@@ -193,23 +289,3 @@ func init() {
 	rootCmd.AddCommand(fixHardlinksCmd)
 	fixHardlinksCmd.Flags().Bool("dry-run", false, "Simulate deduplication")
 }
-
-// areHardlinked checks if two files share the same inode and device
-func areHardlinked(p1, p2 string) bool {
-	fi1, err := os.Stat(p1)
-	if err != nil {
-		return false
-	}
-	fi2, err := os.Stat(p2)
-	if err != nil {
-		return false
-	}
-
-	stat1, ok1 := fi1.Sys().(*syscall.Stat_t)
-	stat2, ok2 := fi2.Sys().(*syscall.Stat_t)
-	if !ok1 || !ok2 {
-		return false
-	}
-
-	return stat1.Ino == stat2.Ino && stat1.Dev == stat2.Dev
-}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"google-photos-backup/internal/i18n"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/registry"
+	"google-photos-backup/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+var gcCmd = &cobra.Command{
+	Use:           "gc",
+	Short:         "Remove objects no longer referenced by any snapshot",
+	Long:          `Garbage-collects the object store (storage_backend: objects). An object is kept while any snapshot index (of any account), the Immich Master index or a symlinked view references its hash, or while a hardlinked view of it still exists. Also reports how much data each snapshot holds exclusively, i.e. what deleting it would reclaim.`,
+	Annotations:   locks(lockBackup),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupPath := getSharedBackupPath()
+		if backupPath == "" {
+			logger.Error(i18n.T("update_backup_no_config"))
+			return failed(exitConfig, fmt.Errorf("backup_path is not set"))
+		}
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		st := store.Open(backupPath)
+		if _, err := os.Stat(st.Root); os.IsNotExist(err) {
+			logger.Info("No object store found at %s. Nothing to do.", st.Root)
+			return nil
+		}
+
+		// The store is shared by every account: collect references from all of them
+		roots := map[string]string{"": backupPath}
+		for name, accountPath := range accountBackupPaths() {
+			roots[name] = accountPath
+		}
+
+		// 1. References: Hash -> snapshots using it
+		owners := make(map[string][]string)
+		extra := make(map[string]bool)
+		var snapshots []string
+		for _, name := range sortedKeys(roots) {
+			root := roots[name]
+			snaps, err := listSnapshots(root)
+			if err != nil && name == "" {
+				return logFailed(exitFailure, fmt.Errorf("error reading backup dir: %w", err))
+			}
+			for _, snapName := range snaps {
+				idx, err := loadSnapshotIndex(filepath.Join(root, snapName))
+				if err != nil {
+					// Without its manifest we cannot tell what the snapshot needs
+					return logFailed(exitFailure, fmt.Errorf("snapshot %s has no index.json. Run 'rebuild-index' before 'gc'", filepath.Join(root, snapName)))
+				}
+				if name != "" {
+					snapName = name + "/" + snapName
+				}
+				snapshots = append(snapshots, snapName)
+				hashes, err := uniqueHashes(idx)
+				idx.Close()
+				if err != nil {
+					return logFailed(exitFailure, fmt.Errorf("cannot read index of %s: %w", snapName, err))
+				}
+				for _, hash := range hashes {
+					owners[hash] = append(owners[hash], snapName)
+				}
+			}
+
+			if idx, err := registry.LoadIndex(filepath.Join(root, getImmichPath(), "index.json")); err == nil {
+				hashes, err := uniqueHashes(idx)
+				idx.Close()
+				if err != nil {
+					return logFailed(exitFailure, fmt.Errorf("cannot read the Immich Master index: %w", err))
+				}
+				for _, hash := range hashes {
+					extra[hash] = true
+				}
+			}
+
+			// Symlinked views anywhere else (e.g. Deleted upstream)
+			filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+				if info.IsDir() && path == st.Root {
+					return filepath.SkipDir
+				}
+				if info.Mode()&os.ModeSymlink != 0 {
+					if hash := st.HashOfLink(path); hash != "" {
+						extra[hash] = true
+					}
+				}
+				return nil
+			})
+		}
+
+		// 2. Sweep
+		total, removed, failures := 0, 0, 0
+		var totalBytes, removedBytes int64
+		exclusive := make(map[string]int64)
+		err := st.Walk(func(obj store.ObjectInfo) error {
+			total++
+			totalBytes += obj.Size
+			if snaps := owners[obj.Hash]; len(snaps) > 0 {
+				if len(snaps) == 1 && !extra[obj.Hash] {
+					exclusive[snaps[0]] += obj.Size
+				}
+				return nil
+			}
+			if extra[obj.Hash] || obj.Links > 1 {
+				return nil
+			}
+
+			removed++
+			removedBytes += obj.Size
+			if dryRun {
+				logger.Info("[Dry Run] Would remove %s", obj.Hash)
+				return nil
+			}
+			if err := os.Remove(obj.Path); err != nil {
+				logger.Error("Failed to remove %s: %v", obj.Path, err)
+				failures++
+				return nil
+			}
+			os.Remove(filepath.Dir(obj.Path)) // Only succeeds once the prefix directory is empty
+			return nil
+		})
+		if err != nil {
+			return logFailed(exitFailure, fmt.Errorf("failed to walk object store: %w", err))
+		}
+
+		if len(exclusive) > 0 {
+			logger.Info("Data held exclusively by each snapshot:")
+			for _, snapName := range snapshots {
+				if bytes := exclusive[snapName]; bytes > 0 {
+					logger.Info("   %s: %s", snapName, formatSizeForBackup(bytes))
+				}
+			}
+		}
+
+		verb := "Removed"
+		if dryRun {
+			verb = "Would remove"
+		}
+		logger.Info("🧹 %s %d of %d objects (%s of %s).", verb, removed, total, formatSizeForBackup(removedBytes), formatSizeForBackup(totalBytes))
+		if failures > 0 {
+			return failed(exitFailure, fmt.Errorf("%d objects could not be removed", failures))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+	gcCmd.Flags().Bool("dry-run", false, "Only report what would be removed")
+}
+
+// uniqueHashes returns each hash of the index once
+func uniqueHashes(idx *registry.Index) ([]string, error) {
+	var hashes []string
+	err := idx.ForEachHash(func(hash string, _ []string) error {
+		hashes = append(hashes, hash)
+		return nil
+	})
+	return hashes, err
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,447 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/processor"
+	"google-photos-backup/internal/registry"
+
+	"github.com/spf13/cobra"
+)
+
+// history.go edits history.json through the registry instead of by hand. Exports are
+// selected by ID, by a unique ID prefix or by their position in 'history list' (#N, for
+// entries Google never gave an ID). Every change is appended to history_audit.jsonl.
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List and repair the exports recorded in history.json",
+}
+
+var historyListCmd = &cobra.Command{
+	Use:           "list",
+	Short:         "List the exports in history.json",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		status, _ := cmd.Flags().GetString("status")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		if status != "" && !registry.ValidStatus(registry.ExportStatus(status)) {
+			return logFailed(exitConfig, fmt.Errorf("unknown status %q (expected one of %v)", status, registry.Statuses))
+		}
+
+		reg, err := openHistory()
+		if err != nil {
+			return err
+		}
+		type listed struct {
+			N int `json:"n"`
+			registry.ExportEntry
+		}
+		var entries []listed
+		for i, e := range reg.Exports {
+			if status == "" || string(e.Status) == status {
+				e.SchemaVersion = 0
+				entries = append(entries, listed{N: i + 1, ExportEntry: e})
+			}
+		}
+
+		if asJSON {
+			if entries == nil {
+				entries = []listed{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(entries)
+		}
+		if len(entries) == 0 {
+			fmt.Println("No exports.")
+			return nil
+		}
+		fmt.Printf("%-4s %-38s %-12s %-16s %-16s %s\n", "#", "ID", "STATUS", "REQUESTED", "COMPLETED", "PARTS")
+		for _, e := range entries {
+			id := e.ID
+			if id == "" {
+				id = "(no ID)"
+			}
+			fmt.Printf("%-4s %-38s %-12s %-16s %-16s %s\n", "#"+strconv.Itoa(e.N), id, e.Status,
+				formatHistoryTime(e.RequestedAt), formatHistoryTime(e.CompletedAt), historyParts(e.ExportEntry))
+		}
+		return nil
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:           "show <ID|#N>",
+	Short:         "Show an export with its download state and audit trail",
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reg, err := openHistory()
+		if err != nil {
+			return err
+		}
+		i, err := findExport(reg, args[0])
+		if err != nil {
+			return logFailed(exitConfig, err)
+		}
+		e := reg.Exports[i]
+		workingPath := getWorkingPath()
+
+		fmt.Printf("Export #%d %s\n", i+1, e.ID)
+		fmt.Printf("  Status:       %s\n", e.Status)
+		fmt.Printf("  Requested:    %s\n", formatHistoryTime(e.RequestedAt))
+		fmt.Printf("  Completed:    %s\n", formatHistoryTime(e.CompletedAt))
+		if e.DownloadMode != "" {
+			fmt.Printf("  Mode:         %s\n", e.DownloadMode)
+		}
+		if e.FileCount > 0 || e.TotalSize != "" {
+			fmt.Printf("  Size:         %d files, %s\n", e.FileCount, e.TotalSize)
+		}
+		if e.NewPhotosCount > 0 {
+			fmt.Printf("  New photos:   %d\n", e.NewPhotosCount)
+		}
+		if e.Error != "" {
+			fmt.Printf("  Error:        %s\n", e.Error)
+		}
+
+		if e.ID != "" {
+			exportDir := filepath.Join(workingPath, "downloads", e.ID)
+			if _, err := os.Stat(exportDir); err == nil {
+				fmt.Printf("  Downloads:    %s\n", exportDir)
+			} else {
+				fmt.Printf("  Downloads:    (none)\n")
+			}
+			processed := false
+			if state, err := processor.ReadState(getOutputDir(workingPath)); err == nil {
+				processed = state.ProcessedExports[e.ID]
+			}
+			fmt.Printf("  Processed:    %v\n", processed)
+
+			if state, err := registry.LoadDownloadState(filepath.Join(exportDir, "state.json")); err == nil {
+				fmt.Println("  Parts:")
+				for _, f := range state.Files {
+					fmt.Printf("    %3d %-40s %-11s %s\n", f.PartNumber, f.Filename, f.Status, f.Size)
+				}
+			}
+		}
+
+		audit, err := reg.ReadAudit(e.ID)
+		if err != nil {
+			logger.Error("Cannot read %s: %v", reg.AuditPath(), err)
+		}
+		if len(audit) > 0 && e.ID != "" {
+			fmt.Println("  Audit:")
+			for _, a := range audit {
+				line := fmt.Sprintf("    %s %s", a.Timestamp.Local().Format("2006-01-02 15:04"), a.Action)
+				if a.From != "" || a.To != "" {
+					line += fmt.Sprintf(" %s -> %s", a.From, a.To)
+				}
+				if a.Forced {
+					line += " (forced)"
+				}
+				if a.Reason != "" {
+					line += ": " + a.Reason
+				}
+				fmt.Println(line)
+			}
+		}
+		return nil
+	},
+}
+
+var historySetStatusCmd = &cobra.Command{
+	Use:   "set-status <ID|#N> <status>",
+	Short: "Change the status of an export",
+	Long: `Moves an export to another status. Only lifecycle transitions are accepted (for example
+expired -> ready for an export that is still downloadable); --force allows any change.
+--clean also removes downloads/<ID> and the export's processing state.`,
+	Args:          cobra.ExactArgs(2),
+	Annotations:   locks(lockWorking),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+		clean, _ := cmd.Flags().GetBool("clean")
+		reason, _ := cmd.Flags().GetString("reason")
+
+		reg, err := openHistory()
+		if err != nil {
+			return err
+		}
+		i, err := findExport(reg, args[0])
+		if err != nil {
+			return logFailed(exitConfig, err)
+		}
+		e := reg.Exports[i]
+		to := registry.ExportStatus(args[1])
+		if err := reg.Transition(i, to, force); err != nil {
+			return logFailed(exitConfig, err)
+		}
+
+		var cleaned []string
+		if clean {
+			if cleaned, err = cleanExport(e, force); err != nil {
+				return logFailed(exitFailure, err)
+			}
+		}
+		if err := saveHistory(reg, registry.AuditEntry{Action: "set-status", ID: e.ID, From: e.Status, To: to, Forced: force && !registry.CanTransition(e.Status, to), Reason: reason, Cleaned: cleaned}); err != nil {
+			return err
+		}
+		logger.Info("✅ %s: %s -> %s", exportLabel(e, i), e.Status, to)
+		return nil
+	},
+}
+
+var historyForgetCmd = &cobra.Command{
+	Use:   "forget <ID|#N>",
+	Short: "Remove an export from history.json",
+	Long: `Removes an export from history.json, e.g. a ghost 'requested' entry. --clean also removes
+downloads/<ID> and the export's processing state.`,
+	Args:          cobra.ExactArgs(1),
+	Annotations:   locks(lockWorking),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+		clean, _ := cmd.Flags().GetBool("clean")
+		reason, _ := cmd.Flags().GetString("reason")
+
+		reg, err := openHistory()
+		if err != nil {
+			return err
+		}
+		i, err := findExport(reg, args[0])
+		if err != nil {
+			return logFailed(exitConfig, err)
+		}
+		e, err := reg.RemoveAt(i)
+		if err != nil {
+			return logFailed(exitConfig, err)
+		}
+
+		var cleaned []string
+		if clean {
+			if cleaned, err = cleanExport(e, force); err != nil {
+				return logFailed(exitFailure, err)
+			}
+		} else if e.ID != "" {
+			if _, err := os.Stat(filepath.Join(getWorkingPath(), "downloads", e.ID)); err == nil {
+				logger.Info("⚠️  downloads/%s is kept (use --clean to remove it).", e.ID)
+			}
+		}
+		if err := saveHistory(reg, registry.AuditEntry{Action: "forget", ID: e.ID, From: e.Status, Reason: reason, Cleaned: cleaned}); err != nil {
+			return err
+		}
+		logger.Info("🗑️  Forgot %s (%s)", exportLabel(e, i), e.Status)
+		return nil
+	},
+}
+
+var historyRetryCmd = &cobra.Command{
+	Use:   "retry <ID|#N>",
+	Short: "Download and process an export again",
+	Long: `Moves an export back to 'ready' so the next 'sync' downloads it again (as long as Google
+still offers it) and 'process' handles it again. Parts already complete on disk are kept
+and only checked; --clean removes downloads/<ID> first to start from scratch.`,
+	Args:          cobra.ExactArgs(1),
+	Annotations:   locks(lockWorking),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+		clean, _ := cmd.Flags().GetBool("clean")
+		reason, _ := cmd.Flags().GetString("reason")
+
+		reg, err := openHistory()
+		if err != nil {
+			return err
+		}
+		i, err := findExport(reg, args[0])
+		if err != nil {
+			return logFailed(exitConfig, err)
+		}
+		e := reg.Exports[i]
+		if e.ID == "" {
+			return logFailed(exitConfig, fmt.Errorf("export #%d has no ID yet: there is nothing to download", i+1))
+		}
+		if err := reg.Transition(i, registry.StatusReady, force); err != nil {
+			return logFailed(exitConfig, err)
+		}
+
+		var cleaned []string
+		if clean {
+			if cleaned, err = cleanExport(e, force); err != nil {
+				return logFailed(exitFailure, err)
+			}
+		} else if err := resetExport(e.ID); err != nil {
+			return logFailed(exitFailure, err)
+		}
+		if err := saveHistory(reg, registry.AuditEntry{Action: "retry", ID: e.ID, From: e.Status, To: registry.StatusReady, Forced: force && !registry.CanTransition(e.Status, registry.StatusReady), Reason: reason, Cleaned: cleaned}); err != nil {
+			return err
+		}
+		logger.Info("🔁 %s will be downloaded again by the next 'sync'.", exportLabel(e, i))
+		return nil
+	},
+}
+
+// openHistory loads working_path/history.json
+func openHistory() (*registry.Registry, error) {
+	workingPath := getWorkingPath()
+	if workingPath == "" {
+		return nil, logFailed(exitConfig, fmt.Errorf("working_path is not set"))
+	}
+	reg, err := registry.New(filepath.Join(workingPath, "history.json"))
+	if err != nil {
+		return nil, logFailed(exitCorrupt, fmt.Errorf("cannot load history: %w", err))
+	}
+	return reg, nil
+}
+
+// saveHistory writes history.json and records the change in the audit trail
+func saveHistory(reg *registry.Registry, audit registry.AuditEntry) error {
+	if err := reg.Save(); err != nil {
+		return logFailed(exitFailure, fmt.Errorf("cannot save history: %w", err))
+	}
+	if err := reg.AppendAudit(audit); err != nil {
+		logger.Error("Cannot write %s: %v", reg.AuditPath(), err)
+	}
+	return nil
+}
+
+// findExport resolves #N (position in 'history list'), an ID or a unique ID prefix
+func findExport(reg *registry.Registry, ref string) (int, error) {
+	if strings.HasPrefix(ref, "#") {
+		n, err := strconv.Atoi(ref[1:])
+		if err != nil || n < 1 || n > len(reg.Exports) {
+			return 0, fmt.Errorf("no export %s (history has %d)", ref, len(reg.Exports))
+		}
+		return n - 1, nil
+	}
+	match := -1
+	for i, e := range reg.Exports {
+		if e.ID == ref {
+			return i, nil
+		}
+		if ref != "" && strings.HasPrefix(e.ID, ref) {
+			if match >= 0 {
+				return 0, fmt.Errorf("%q matches several exports", ref)
+			}
+			match = i
+		}
+	}
+	if match < 0 {
+		return 0, fmt.Errorf("no export %q in history", ref)
+	}
+	return match, nil
+}
+
+// cleanExport removes downloads/<ID> and the processing state of the export. An export
+// processed but not backed up yet is only removed with force.
+func cleanExport(e registry.ExportEntry, force bool) ([]string, error) {
+	if e.ID == "" {
+		return nil, nil
+	}
+	workingPath := getWorkingPath()
+	exportDir := filepath.Join(workingPath, "downloads", e.ID)
+	outputDir := getOutputDir(workingPath)
+
+	if !force {
+		if state, err := processor.ReadState(outputDir); err == nil && state.ProcessedExports[e.ID] {
+			if _, err := os.Stat(filepath.Join(exportDir, "raw")); err == nil {
+				return nil, fmt.Errorf("%s is processed but not backed up yet (run 'update-backup' first, or use --force)", exportDir)
+			}
+		}
+	}
+
+	var cleaned []string
+	if _, err := os.Stat(exportDir); err == nil {
+		if err := os.RemoveAll(exportDir); err != nil {
+			return cleaned, fmt.Errorf("cannot remove %s: %w", exportDir, err)
+		}
+		cleaned = append(cleaned, exportDir)
+	}
+	if err := processor.ForgetExport(outputDir, e.ID); err != nil {
+		return cleaned, fmt.Errorf("cannot update the processing index in %s: %w", outputDir, err)
+	}
+	return cleaned, nil
+}
+
+// resetExport marks the parts of an export pending again and forgets its processing,
+// keeping what is on disk
+func resetExport(id string) error {
+	workingPath := getWorkingPath()
+	exportDir := filepath.Join(workingPath, "downloads", id)
+	statePath := filepath.Join(exportDir, "state.json")
+	state, err := registry.LoadDownloadState(statePath)
+	if err == nil {
+		for i := range state.Files {
+			state.Files[i].Status = "pending"
+			state.Files[i].DownloadedBytes = 0
+		}
+		state.LastUpdated = time.Now()
+		if err := state.Save(statePath); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := processor.ForgetExport(exportDir, id); err != nil {
+		return err
+	}
+	return processor.ForgetExport(getOutputDir(workingPath), id)
+}
+
+func exportLabel(e registry.ExportEntry, i int) string {
+	if e.ID == "" {
+		return fmt.Sprintf("export #%d", i+1)
+	}
+	return e.ID
+}
+
+func formatHistoryTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// historyParts summarises the download state of an export ("3/5 parts")
+func historyParts(e registry.ExportEntry) string {
+	if e.ID == "" {
+		return "-"
+	}
+	state, err := registry.LoadDownloadState(filepath.Join(getWorkingPath(), "downloads", e.ID, "state.json"))
+	if err != nil {
+		return "-"
+	}
+	done := 0
+	for _, f := range state.Files {
+		if f.Status == "completed" {
+			done++
+		}
+	}
+	return fmt.Sprintf("%d/%d", done, len(state.Files))
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyListCmd, historyShowCmd, historySetStatusCmd, historyForgetCmd, historyRetryCmd)
+
+	historyListCmd.Flags().String("status", "", "Only list exports with this status")
+	historyListCmd.Flags().Bool("json", false, "Print the exports as JSON")
+
+	for _, c := range []*cobra.Command{historySetStatusCmd, historyForgetCmd, historyRetryCmd} {
+		c.Flags().Bool("clean", false, "Also remove downloads/<ID> and the export's processing state")
+		c.Flags().Bool("force", false, "Allow any status change and cleaning exports not backed up yet")
+		c.Flags().String("reason", "", "Reason recorded in the audit trail")
+	}
+}
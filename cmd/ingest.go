@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"google-photos-backup/internal/config"
+	"google-photos-backup/internal/i18n"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/processor"
+	"google-photos-backup/internal/registry"
+	"google-photos-backup/internal/store"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// ingestedDir is the folder inside an ingest snapshot that holds the imported tree
+const ingestedDir = "Ingested"
+
+var reUnsafeLabel = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+var ingestCmd = &cobra.Command{
+	Use:           "ingest <dir>",
+	Short:         "Import a non-Takeout photo folder as a labelled snapshot",
+	Long:          `Treats an arbitrary directory tree (phone dump, old camera folder...) as an export: every file is hashed, linked to identical content already in the backup or copied otherwise, dated from EXIF or its filename, and stored in a snapshot named <timestamp>-ingest-<label>. The snapshot is indexed, sealed and added to the Immich Master like any other. The source directory is never modified.`,
+	Annotations:   locks(lockBackup),
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupPath := getBackupPath()
+		if backupPath == "" {
+			logger.Error(i18n.T("update_backup_no_config"))
+			return failed(exitConfig, fmt.Errorf("backup_path is not set"))
+		}
+		source, _ := filepath.Abs(expandPath(args[0]))
+		if info, err := os.Stat(source); err != nil || !info.IsDir() {
+			return logFailed(exitConfig, fmt.Errorf("source %s is not a directory", source))
+		}
+		label, _ := cmd.Flags().GetString("label")
+		if label == "" {
+			label = filepath.Base(source)
+		}
+		label = strings.Trim(reUnsafeLabel.ReplaceAllString(label, "-"), "-")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		timestamp := time.Now().Format("2006-01-02-150405")
+		snapName := timestamp + "-ingest-" + label
+		snapshotDir := filepath.Join(backupPath, snapName)
+		logger.Info("📥 Ingesting %s -> %s", source, snapshotDir)
+
+		// 1. Content already in the backup: Hash -> Path (newest snapshot first)
+		known := make(map[string]string)
+		snapshots, _ := listSnapshots(backupPath)
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			idx, err := registry.LoadIndex(filepath.Join(backupPath, snapshots[i], "index.json"))
+			if err != nil {
+				continue
+			}
+			idx.ForEachHash(func(hash string, relPaths []string) error {
+				if _, ok := known[hash]; !ok {
+					known[hash] = filepath.Join(backupPath, snapshots[i], relPaths[0])
+				}
+				return nil
+			})
+			idx.Close()
+		}
+		for hash, path := range otherAccountHashes() {
+			if _, ok := known[hash]; !ok {
+				known[hash] = path
+			}
+		}
+		logger.Info("Backup already holds %d unique files.", len(known))
+
+		// 2. Import through the update-backup walkers: the folder is a kept source whose
+		// new files are dated from EXIF or their filename (used by Immich Master)
+		var exifDates, nameDates, noDate int
+		src := backupSource{
+			ContentRoot: source,
+			DestDir:     ingestedDir,
+			Keep:        true,
+			Date: func(path string) (time.Time, bool) {
+				date, how, ok := time.Time{}, "", false
+				if !processor.IsIgnoredFile(path) {
+					date, how, ok = processor.ResolveDate(path)
+				}
+				switch how {
+				case "exif":
+					exifDates++
+				case "filename":
+					nameDates++
+				default:
+					noDate++
+				}
+				return date, ok
+			},
+		}
+		if dryRun {
+			stats := ingestPreview(src, known)
+			logger.Info("Dates: %d from EXIF, %d from filename, %d kept file mtime.", exifDates, nameDates, noDate)
+			logger.Info("[Dry Run] %d files would be added (%s), %d linked to existing content.", stats.Added, formatSizeForBackup(stats.Bytes), stats.Linked)
+			return nil
+		}
+
+		stats := &backupStats{}
+		var err error
+		if backend, viewMode := getStorageBackend(); backend == store.BackendObjects {
+			err = backupExportToStore(src, snapshotDir, store.Open(getSharedBackupPath()), viewMode, stats, false)
+		} else {
+			err = backupExport(src, snapshotDir, "", make(map[uint64]string), known, stats, false)
+		}
+		if err != nil {
+			return logFailed(exitBackup, fmt.Errorf("ingest failed: %w", err))
+		}
+		logger.Info("Dates: %d from EXIF, %d from filename, %d kept file mtime.", exifDates, nameDates, noDate)
+		if stats.Added+stats.Linked+stats.Copied == 0 {
+			logger.Info("Nothing to ingest.")
+			os.RemoveAll(snapshotDir)
+			return nil
+		}
+
+		// 3. Index, seal, Immich Master and log, as update-backup does
+		snapIdx, err := processor.EnsureSnapshotIndex(snapshotDir)
+		if err != nil {
+			return logFailed(exitBackup, fmt.Errorf("failed to generate index for new snapshot: %w", err))
+		}
+		defer snapIdx.Close()
+		var sealErr error
+		if err := sealSnapshot(backupPath, snapName); err != nil {
+			logger.Error("Failed to seal snapshot into chain: %v", err)
+			sealErr = fmt.Errorf("failed to seal snapshot into chain: %w", err)
+		}
+
+		immichEnabled := config.AppConfig.ImmichMasterEnabled
+		if !immichEnabled {
+			immichEnabled = viper.GetBool("immich_master_enabled")
+		}
+		if immichEnabled {
+			updateImmichMaster(backupPath, snapshotDir, snapIdx)
+		}
+
+		appendBackupLog(backupPath, BackupLogEntry{
+			Timestamp: timestamp,
+			Source:    source,
+			Snapshot:  snapName,
+			Added:     stats.Added,
+			Linked:    stats.Linked,
+			Copied:    stats.Copied,
+			Size:      stats.Bytes,
+			Files:     stats.Files,
+		})
+
+		logger.Info("✅ Ingested %d files: %d new, %d linked to existing content, %d copied (%s written).", stats.Added+stats.Linked+stats.Copied, stats.Added, stats.Linked, stats.Copied, formatSizeForBackup(stats.Bytes))
+		if sealErr != nil {
+			return failed(exitBackup, sealErr)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ingestCmd)
+	ingestCmd.Flags().String("label", "", "Snapshot label (defaults to the directory name)")
+	ingestCmd.Flags().Bool("dry-run", false, "Only report what would be imported")
+}
+
+// isIngestSnapshot reports whether a snapshot was created by 'ingest'
+func isIngestSnapshot(name string) bool {
+	return strings.Contains(name, "-ingest-")
+}
+
+// ingestPreview counts what an ingest of src would add or link, without writing anything
+func ingestPreview(src backupSource, known map[string]string) backupStats {
+	var stats backupStats
+	seen := make(map[string]bool)
+	filepath.Walk(src.ContentRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || src.skip(path) {
+			return nil
+		}
+		if info, err = os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		hash := src.hash(path, info.Size())
+		if hash == "" {
+			return nil
+		}
+		if _, ok := known[hash]; ok || seen[hash] {
+			stats.Linked++
+			return nil
+		}
+		seen[hash] = true
+		src.Date(path)
+		stats.Added++
+		stats.Bytes += info.Size()
+		return nil
+	})
+	return stats
+}
@@ -0,0 +1,366 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google-photos-backup/internal/fsutil"
+	"google-photos-backup/internal/integrity"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/processor"
+	"google-photos-backup/internal/registry"
+	"google-photos-backup/internal/schema"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade state files and backups written by older versions",
+	Long: `Brings everything on disk to the format of this version:
+  - history.json, state.json, processing_index.json, index.json and backup_log.jsonl are
+    rewritten with the current schema_version (every command also migrates them in
+    memory when it loads them; migrate makes it permanent).
+  - Snapshots in the legacy layout (<snapshot>/<export ID>/raw/[Takeout/]Google Photos)
+    are flattened to <snapshot>/Google Photos, keeping hardlinks.
+  - With --convert-symlinks DIR, symlinks below DIR are replaced by hardlinks to their
+    targets (reflink or copy across filesystems).
+
+Sealed snapshots (chain.json) are never rewritten: their index.json is migrated when read.`,
+	Annotations:   locks(lockWorking, lockBackup),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		symlinkDirs, _ := cmd.Flags().GetStringArray("convert-symlinks")
+
+		m := &migrator{dryRun: dryRun}
+		if dryRun {
+			logger.Info("Dry run: nothing will be written.")
+		}
+
+		if workingPath := getWorkingPath(); workingPath != "" {
+			logger.Info("🔍 Working path: %s", workingPath)
+			m.migrateWorking(workingPath)
+		}
+		if backupPath := getBackupPath(); backupPath != "" {
+			logger.Info("🔍 Backup path: %s", backupPath)
+			m.flattenLegacySnapshots(backupPath)
+			m.migrateBackup(backupPath)
+		}
+		for _, dir := range symlinkDirs {
+			m.convertSymlinks(expandPath(dir))
+		}
+
+		if dryRun {
+			logger.Info("Would migrate %d files, flatten %d snapshots and convert %d symlinks.", m.files, m.flattened, m.symlinks)
+		} else {
+			logger.Info("🎉 Migrated %d files, flattened %d snapshots, converted %d symlinks.", m.files, m.flattened, m.symlinks)
+			if m.flattened > 0 {
+				logger.Info("Run 'rebuild-index' to index the flattened snapshots.")
+			}
+		}
+		if m.err != nil {
+			return failed(exitFailure, m.err)
+		}
+		return nil
+	},
+}
+
+// migrator carries the options and counters of one migrate run
+type migrator struct {
+	dryRun    bool
+	files     int
+	flattened int
+	symlinks  int
+	err       error // First error, reported through the exit code
+}
+
+func (m *migrator) fail(format string, args ...interface{}) {
+	err := fmt.Errorf(format, args...)
+	logger.Error("%v", err)
+	if m.err == nil {
+		m.err = err
+	}
+}
+
+// migrateFile checks path against the current version of format and, unless this is a
+// dry run, rewrites it through its own loader so that the usual formatting is kept
+func (m *migrator) migrateFile(path, format string, rewrite func() error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		m.fail("Cannot read %s: %v", path, err)
+		return
+	}
+	pending, err := schema.Pending(path, format, data)
+	if err != nil {
+		m.fail("%s: %v", path, err)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	logger.Info("   %s: %s", path, strings.Join(pending, "; "))
+	m.files++
+	if m.dryRun {
+		return
+	}
+	if err := rewrite(); err != nil {
+		m.fail("Failed to migrate %s: %v", path, err)
+	}
+}
+
+// migrateWorking upgrades history.json, the download states and the processing indexes
+func (m *migrator) migrateWorking(workingPath string) {
+	historyPath := filepath.Join(workingPath, "history.json")
+	m.migrateFile(historyPath, schema.History, func() error {
+		reg, err := registry.New(historyPath)
+		if err != nil {
+			return err
+		}
+		return reg.Save()
+	})
+
+	downloads := filepath.Join(workingPath, "downloads")
+	dirs := []string{downloads, filepath.Join(workingPath, "output")}
+	if outDir := expandPath(viper.GetString("output_dir")); outDir != "" {
+		dirs = append(dirs, outDir)
+	}
+	if entries, err := os.ReadDir(downloads); err == nil {
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			exportDir := filepath.Join(downloads, e.Name())
+			dirs = append(dirs, exportDir)
+
+			statePath := filepath.Join(exportDir, "state.json")
+			m.migrateFile(statePath, schema.DownloadState, func() error {
+				state, err := registry.LoadDownloadState(statePath)
+				if err != nil {
+					return err
+				}
+				return state.Save(statePath)
+			})
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		m.migrateFile(filepath.Join(dir, processor.IndexFileName), schema.ProcessingIndex, func() error {
+			return processor.UpgradeState(dir)
+		})
+	}
+}
+
+// migrateBackup upgrades backup_log.jsonl and the index.json of unsealed snapshots and
+// of the Immich master
+func (m *migrator) migrateBackup(backupPath string) {
+	m.migrateFile(filepath.Join(backupPath, "backup_log.jsonl"), schema.BackupLog, func() error {
+		return rewriteBackupLog(backupPath, getWorkingPath(), func(path string) string { return path })
+	})
+
+	var indexDirs []string
+	snapshots, _ := listSnapshots(backupPath)
+	for _, snapName := range snapshots {
+		indexDirs = append(indexDirs, filepath.Join(backupPath, snapName))
+	}
+	indexDirs = append(indexDirs, getImmichRoot(backupPath))
+
+	sealed := 0
+	for _, dir := range indexDirs {
+		indexPath := filepath.Join(dir, "index.json")
+		if _, err := os.Stat(filepath.Join(dir, integrity.ChainFileName)); err == nil {
+			if data, err := os.ReadFile(indexPath); err == nil {
+				if pending, _ := schema.Pending(indexPath, schema.SnapshotIndex, data); len(pending) > 0 {
+					sealed++
+				}
+			}
+			continue
+		}
+		m.migrateFile(indexPath, schema.SnapshotIndex, func() error {
+			idx, err := registry.LoadIndex(indexPath)
+			if err != nil {
+				return err
+			}
+			defer idx.Close()
+			return idx.Save(indexPath)
+		})
+	}
+	if sealed > 0 {
+		logger.Info("   %d sealed snapshots keep their index.json as sealed (migrated when read).", sealed)
+	}
+}
+
+// flattenLegacySnapshots moves <snapshot>/<export ID>/raw/[Takeout/]Google Photos into
+// <snapshot>/Google Photos and the export's JSON files to <snapshot>/<name>_<export ID>.json
+func (m *migrator) flattenLegacySnapshots(backupPath string) {
+	snapshots, err := listSnapshots(backupPath)
+	if err != nil {
+		return
+	}
+	for _, snapName := range snapshots {
+		snapPath := filepath.Join(backupPath, snapName)
+		entries, err := os.ReadDir(snapPath)
+		if err != nil {
+			continue
+		}
+
+		var legacy []string
+		for _, e := range entries {
+			if !e.IsDir() || e.Name() == "Google Photos" {
+				continue
+			}
+			if legacyContent(filepath.Join(snapPath, e.Name())) != "" {
+				legacy = append(legacy, e.Name())
+			}
+		}
+		if len(legacy) == 0 {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(snapPath, integrity.ChainFileName)); err == nil {
+			logger.Info("⚠️  Snapshot %s is in the legacy layout but sealed. Skipping.", snapName)
+			continue
+		}
+
+		logger.Info("📂 Flattening snapshot %s (%s)", snapName, strings.Join(legacy, ", "))
+		m.flattened++
+		if m.dryRun {
+			continue
+		}
+		dest := filepath.Join(snapPath, "Google Photos")
+		for _, exportID := range legacy {
+			exportDir := filepath.Join(snapPath, exportID)
+			content := legacyContent(exportDir)
+			if err := moveTree(content, dest); err != nil {
+				m.fail("Failed to flatten %s: %v", exportDir, err)
+				continue
+			}
+			removeEmptyDirs(filepath.Join(exportDir, "raw"))
+
+			jsonFiles, _ := filepath.Glob(filepath.Join(exportDir, "*.json"))
+			for _, jsonFile := range jsonFiles {
+				base := strings.TrimSuffix(filepath.Base(jsonFile), ".json")
+				if err := os.Rename(jsonFile, filepath.Join(snapPath, base+"_"+exportID+".json")); err != nil {
+					m.fail("Failed to move %s: %v", jsonFile, err)
+				}
+			}
+			os.Remove(exportDir)
+		}
+	}
+}
+
+// legacyContent returns the Google Photos directory of a legacy export copy, or ""
+func legacyContent(exportDir string) string {
+	for _, candidate := range []string{
+		filepath.Join(exportDir, "raw", "Takeout", "Google Photos"),
+		filepath.Join(exportDir, "raw", "Google Photos"),
+	} {
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// moveTree renames every file of src into the same place below dest. Renames keep the
+// inode, so hardlinks to other snapshots survive. Files already present in dest stay in src.
+func moveTree(src, dest string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if _, err := os.Lstat(target); err == nil {
+			logger.Info("⚠️  %s already exists, keeping %s", target, path)
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.Rename(path, target)
+	})
+}
+
+// removeEmptyDirs removes root and every directory below it that is empty, deepest first
+func removeEmptyDirs(root string) {
+	var dirs []string
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		os.Remove(dir) // Fails (and is kept) when not empty
+	}
+}
+
+// convertSymlinks replaces every symlink below dir that points to a regular file with a
+// hardlink to it. Across filesystems the linker falls back to a reflink or a copy.
+func (m *migrator) convertSymlinks(dir string) {
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		m.fail("Directory %s not found", dir)
+		return
+	}
+	logger.Info("🔍 Scanning for symlinks in: %s", dir)
+
+	linker := fsutil.New(fsutil.StrategyHardlink)
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&os.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			logger.Info("⚠️  Skipping broken link: %s", path)
+			return nil
+		}
+		if info, err := os.Stat(target); err != nil || !info.Mode().IsRegular() {
+			logger.Info("⚠️  Skipping link to a non-file: %s", path)
+			return nil
+		}
+
+		if m.dryRun {
+			logger.Debug("Would convert %s -> %s", path, target)
+			m.symlinks++
+			return nil
+		}
+		used, err := linker.Replace(target, path)
+		if err != nil {
+			m.fail("Failed to convert %s -> %s: %v", path, target, err)
+			return nil
+		}
+		if used != fsutil.StrategyHardlink {
+			logger.Info("⚠️  %s is on another filesystem: used %s", path, used)
+		}
+		m.symlinks++
+		return nil
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().Bool("dry-run", false, "Report what would be migrated without writing anything")
+	migrateCmd.Flags().StringArray("convert-symlinks", nil, "Also replace the symlinks below this directory with hardlinks (repeatable)")
+}
@@ -0,0 +1,334 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google-photos-backup/internal/fsutil"
+	"google-photos-backup/internal/i18n"
+	"google-photos-backup/internal/lock"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/registry"
+
+	"github.com/spf13/cobra"
+)
+
+// mirrorStats collects counters for the mirror summary
+type mirrorStats struct {
+	Copied int
+	Linked int
+	Bytes  int64
+	Failed int
+}
+
+// linked counts a file linkOver recreated: only hardlinks and reflinks share storage
+func (s *mirrorStats) linked(used fsutil.Strategy, size int64) {
+	if used == fsutil.StrategyCopy {
+		s.Copied++
+		s.Bytes += size
+		return
+	}
+	s.Linked++
+}
+
+var mirrorCmd = &cobra.Command{
+	Use:           "mirror <dest>",
+	Short:         "Replicate the backup tree to a second disk preserving hardlinks",
+	Long:          `Incrementally replicates backup_path (snapshots, Immich master, deleted-upstream tree and logs) to another location. The snapshot index.json files are used to rebuild the hardlink structure on the destination: each unique content is copied once and every other occurrence becomes a hardlink. Snapshots already present on the destination are skipped, and newly transferred ones are verified afterwards.`,
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupPath := getBackupPath()
+		if backupPath == "" {
+			logger.Error(i18n.T("update_backup_no_config"))
+			return failed(exitConfig, fmt.Errorf("backup_path is not set"))
+		}
+		dest := expandPath(args[0])
+		noVerify, _ := cmd.Flags().GetBool("no-verify")
+
+		// The mirror must not end up inside the tree it copies (Abs also cleans both paths)
+		absBackup, err1 := filepath.Abs(backupPath)
+		absDest, err2 := filepath.Abs(dest)
+		if err := errors.Join(err1, err2); err != nil {
+			return logFailed(exitConfig, err)
+		}
+		if rel, err := filepath.Rel(absBackup, absDest); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return logFailed(exitConfig, fmt.Errorf("destination %s is the backup path or inside it", absDest))
+		}
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return logFailed(exitFailure, fmt.Errorf("failed to create destination: %w", err))
+		}
+
+		logger.Info("🪞 Mirroring %s -> %s", backupPath, dest)
+
+		snapshots, err := listSnapshots(backupPath)
+		if err != nil {
+			return logFailed(exitFailure, fmt.Errorf("error reading backup dir: %w", err))
+		}
+
+		// 1. Known content on the destination: Hash -> Path
+		destHashes := make(map[string]string)
+		destSnapshots, _ := listSnapshots(dest)
+		for _, snapName := range destSnapshots {
+			idx, err := registry.LoadIndex(filepath.Join(dest, snapName, "index.json"))
+			if err != nil {
+				continue
+			}
+			idx.ForEachHash(func(hash string, relPaths []string) error {
+				if _, ok := destHashes[hash]; !ok {
+					destHashes[hash] = filepath.Join(dest, snapName, relPaths[0])
+				}
+				return nil
+			})
+			idx.Close()
+		}
+		logger.Info("Destination already holds %d snapshots (%d unique files).", len(destSnapshots), len(destHashes))
+
+		stats := &mirrorStats{}
+		var transferred []string
+
+		// 2. Snapshots, oldest first. index.json is written last and marks a snapshot complete.
+		for _, snapName := range snapshots {
+			destSnap := filepath.Join(dest, snapName)
+			if _, err := os.Stat(filepath.Join(destSnap, "index.json")); err == nil {
+				continue
+			}
+
+			srcSnap := filepath.Join(backupPath, snapName)
+			idx, err := loadSnapshotIndex(srcSnap)
+			if err != nil {
+				logger.Info("⚠️  Snapshot %s has no index.json. Skipping. Run 'rebuild-index' first.", snapName)
+				continue
+			}
+
+			logger.Info("📦 Transferring snapshot %s (%d files)", snapName, idx.Len())
+			mirrorIndexedTree(srcSnap, destSnap, idx, destHashes, stats)
+			mirrorLooseFiles(srcSnap, destSnap, idx, destHashes, stats)
+			idx.Close()
+
+			if err := copyFile(filepath.Join(srcSnap, "index.json"), filepath.Join(destSnap, "index.json")); err != nil {
+				logger.Error("Failed to copy index of %s: %v", snapName, err)
+				stats.Failed++
+				continue
+			}
+			transferred = append(transferred, snapName)
+		}
+
+		// 3. Immich Master (incremental by path)
+		masterRoot := getImmichRoot(backupPath)
+		if idx, err := registry.LoadIndex(filepath.Join(masterRoot, "index.json")); err == nil && idx.Len() > 0 {
+			logger.Info("📸 Syncing Immich Master (%d files)", idx.Len())
+			destMaster := filepath.Join(dest, getImmichPath())
+			mirrorIndexedTree(masterRoot, destMaster, idx, destHashes, stats)
+			idx.Close()
+			if err := copyFile(filepath.Join(masterRoot, "index.json"), filepath.Join(destMaster, "index.json")); err != nil {
+				logger.Error("Failed to copy Immich Master index: %v", err)
+				stats.Failed++
+			}
+		}
+
+		// 4. Everything else at the top level (deleted-upstream tree, logs, ...)
+		entries, _ := os.ReadDir(backupPath)
+		for _, e := range entries {
+			name := e.Name()
+			// Account backups under accounts/ are mirrored with --account
+			if (e.IsDir() && isTimestamp(name)) || name == getImmichPath() || name == "accounts" || name == lock.FileName {
+				continue
+			}
+			mirrorLooseFiles(filepath.Join(backupPath, name), filepath.Join(dest, name), nil, destHashes, stats)
+		}
+
+		logger.Info("✅ Transfer complete: %d copied (%s), %d linked, %d failed.", stats.Copied, formatSizeForBackup(stats.Bytes), stats.Linked, stats.Failed)
+
+		var copyErr error
+		if stats.Failed > 0 {
+			copyErr = fmt.Errorf("%d files could not be mirrored", stats.Failed)
+		}
+
+		// 5. Verify
+		if noVerify || len(transferred) == 0 {
+			if copyErr != nil {
+				return logFailed(exitFailure, copyErr)
+			}
+			return nil
+		}
+		logger.Info("🔍 Verifying %d transferred snapshots...", len(transferred))
+		bad := 0
+		for _, snapName := range transferred {
+			bad += verifyMirroredSnapshot(filepath.Join(dest, snapName))
+		}
+		if bad > 0 {
+			return logFailed(exitIntegrity, fmt.Errorf("verification failed for %d files on the destination", bad))
+		}
+		if copyErr != nil {
+			return logFailed(exitFailure, copyErr)
+		}
+		logger.Info("✅ Destination verified.")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mirrorCmd)
+	mirrorCmd.Flags().Bool("no-verify", false, "Skip re-hashing the transferred snapshots on the destination")
+}
+
+// mirrorIndexedTree recreates every indexed file of srcRoot below destRoot, hardlinking
+// to already transferred content with the same hash and copying (verified) otherwise.
+func mirrorIndexedTree(srcRoot, destRoot string, idx *registry.Index, destHashes map[string]string, stats *mirrorStats) {
+	err := idx.ForEach(func(relPath string, entry registry.FileIndexEntry) error {
+		destPath := filepath.Join(destRoot, relPath)
+
+		if info, err := os.Lstat(destPath); err == nil && info.Mode().IsRegular() &&
+			info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime) {
+			// Transferred by a previous run (copies only appear once verified and get the
+			// indexed mtime); anything else is replaced below
+			if _, ok := destHashes[entry.Hash]; !ok {
+				destHashes[entry.Hash] = destPath
+			}
+			return nil
+		}
+
+		if existing, ok := destHashes[entry.Hash]; ok {
+			if used, err := linkOver(existing, destPath); err == nil {
+				stats.linked(used, entry.Size)
+				return nil
+			}
+		}
+
+		if err := copyVerified(filepath.Join(srcRoot, relPath), destPath, entry.Hash); err != nil {
+			logger.Error("Failed to copy %s: %v", relPath, err)
+			stats.Failed++
+			return nil
+		}
+		os.Chtimes(destPath, entry.ModTime, entry.ModTime)
+		destHashes[entry.Hash] = destPath
+		stats.Copied++
+		stats.Bytes += entry.Size
+		return nil
+	})
+	if err != nil {
+		logger.Error("Cannot read index of %s: %v", srcRoot, err)
+		stats.Failed++
+	}
+}
+
+// mirrorLooseFiles copies files under src that are not part of idx (nil = none are),
+// deduplicating by hash against content already on the destination.
+func mirrorLooseFiles(src, dest string, idx *registry.Index, destHashes map[string]string, stats *mirrorStats) {
+	filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		relPath, _ := filepath.Rel(src, path)
+		if relPath == "." {
+			relPath = ""
+		}
+		if idx != nil {
+			if _, ok := idx.Get(relPath); ok || relPath == "index.json" {
+				return nil
+			}
+		}
+		destPath := filepath.Join(dest, relPath)
+
+		// Logs and manifests change in place; small enough to always refresh
+		if filepath.Ext(path) == ".jsonl" || filepath.Dir(relPath) == "." {
+			if err := copyFile(path, destPath); err != nil {
+				logger.Error("Failed to copy %s: %v", path, err)
+				stats.Failed++
+			}
+			return nil
+		}
+
+		if existing, err := os.Lstat(destPath); err == nil && existing.Mode().IsRegular() &&
+			existing.Size() == info.Size() && existing.ModTime().Equal(info.ModTime()) {
+			return nil
+		}
+
+		hash, err := calculateHash(path)
+		if err != nil {
+			logger.Error("Failed to hash %s: %v", path, err)
+			stats.Failed++
+			return nil
+		}
+		if existing, ok := destHashes[hash]; ok {
+			if used, err := linkOver(existing, destPath); err == nil {
+				stats.linked(used, info.Size())
+				return nil
+			}
+		}
+		if err := copyVerified(path, destPath, hash); err != nil {
+			logger.Error("Failed to copy %s: %v", path, err)
+			stats.Failed++
+			return nil
+		}
+		os.Chtimes(destPath, info.ModTime(), info.ModTime())
+		destHashes[hash] = destPath
+		stats.Copied++
+		stats.Bytes += info.Size()
+		return nil
+	})
+}
+
+// mirrorLinker rebuilds the hardlink structure whatever link_strategy the backup uses
+var mirrorLinker = fsutil.New(fsutil.StrategyHardlink)
+
+// linkOver hardlinks existing at path through a temporary name, so a file already at path
+// is replaced rather than written to (it may be an inode shared with older snapshots).
+// It returns the strategy used: past the link limit the content is reflinked or copied.
+func linkOver(existing, path string) (fsutil.Strategy, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	tmp := path + ".mirror-tmp"
+	os.Remove(tmp)
+	used, err := mirrorLinker.Link(existing, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return used, err
+	}
+	err = os.Rename(tmp, path)
+	// Renaming onto another link of the same inode is a no-op that leaves tmp behind
+	os.Remove(tmp)
+	return used, err
+}
+
+// verifyMirroredSnapshot re-hashes each unique inode of a mirrored snapshot against its index
+func verifyMirroredSnapshot(snapPath string) int {
+	idx, err := registry.LoadIndex(filepath.Join(snapPath, "index.json"))
+	if err != nil {
+		logger.Error("Cannot read mirrored index %s: %v", snapPath, err)
+		return 1
+	}
+	defer idx.Close()
+
+	checked := make(map[inodeKey]bool)
+	bad := 0
+	err = idx.ForEach(func(relPath string, entry registry.FileIndexEntry) error {
+		path := filepath.Join(snapPath, relPath)
+		key, err := statInode(path)
+		if err != nil {
+			logger.Error("MISSING on destination: %s", path)
+			bad++
+			return nil
+		}
+		if checked[key] {
+			return nil
+		}
+		checked[key] = true
+		if got, err := calculateHash(path); err != nil || got != entry.Hash {
+			logger.Error("CORRUPTED on destination: %s", path)
+			bad++
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("Cannot read mirrored index %s: %v", snapPath, err)
+		bad++
+	}
+	return bad
+}
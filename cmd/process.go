@@ -1,83 +1,229 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"google-photos-backup/internal/i18n"
 	"google-photos-backup/internal/logger"
 	"google-photos-backup/internal/processor"
+	"google-photos-backup/internal/registry"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var processCmd = &cobra.Command{
-	Use:   "process",
-	Short: "Extract, deduplicate, and organize downloaded archives",
-	Long:  `Extracts ZIP/TGZ files from the download directory, corrects metadata using JSON sidecars, deduplicates files, and organizes them into albums.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Use:           "process",
+	Short:         "Extract, deduplicate, and organize downloaded archives",
+	Long:          `Extracts ZIP/TGZ files from the download directory, corrects metadata using JSON sidecars, deduplicates files, and organizes them into albums.`,
+	Annotations:   locks(lockWorking),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		// Config is already loaded by rootCmd PersistentPreRun
+		opts := processOptions{}
+		opts.Input, _ = cmd.Flags().GetString("input")
+		opts.Output, _ = cmd.Flags().GetString("output")
+		opts.Albums, _ = cmd.Flags().GetString("albums")
+		opts.DeleteOrigin, _ = cmd.Flags().GetBool("delete-origin")
+		opts.ForceMetadata, _ = cmd.Flags().GetBool("force-metadata")
+		opts.ForceExtraction, _ = cmd.Flags().GetBool("force-extract")
+		opts.ForceDedup, _ = cmd.Flags().GetBool("force-dedup")
+		opts.Export, _ = cmd.Flags().GetString("export")
+		opts.FixAmbiguousMetadata, _ = cmd.Flags().GetString("fix-ambiguous-metadata")
 
-		inputDir := viper.GetString("download_dir")
-		outputDir := viper.GetString("output_dir")
-		albumsDir := viper.GetString("albums_dir")
+		_, err := runProcess(opts)
+		return err
+	},
+}
 
-		// Overrides from flags
-		if flagInput, _ := cmd.Flags().GetString("input"); flagInput != "" {
-			inputDir = flagInput
-		}
-		if flagOutput, _ := cmd.Flags().GetString("output"); flagOutput != "" {
-			outputDir = flagOutput
-		}
-		if flagAlbums, _ := cmd.Flags().GetString("albums"); flagAlbums != "" {
-			albumsDir = flagAlbums
-		}
+// processOptions are the process flags, also set by the run command
+type processOptions struct {
+	Input, Output, Albums string
+	DeleteOrigin          bool
+	ForceMetadata         bool
+	ForceExtraction       bool
+	ForceDedup            bool
+	Export                string          // Process only this export ID
+	FixAmbiguousMetadata  string          // Flag value; the config is used when empty
+	Context               context.Context // Set by the daemon: cancelling it ends a free-space pause
+}
+
+// processResult tells the next stages which exports are ready for update-backup
+type processResult struct {
+	Processed []string
+}
+
+// resolveDirs applies flags over config and the defaults below working_path
+func (o processOptions) resolveDirs() (inputDir, outputDir, albumsDir string) {
+	inputDir = viper.GetString("download_dir")
+	outputDir = viper.GetString("output_dir")
+	albumsDir = viper.GetString("albums_dir")
+
+	// Overrides from flags
+	if o.Input != "" {
+		inputDir = o.Input
+	}
+	if o.Output != "" {
+		outputDir = o.Output
+	}
+	if o.Albums != "" {
+		albumsDir = o.Albums
+	}
+
+	// Validate directories: infer them from working_path. The output directory must be
+	// the one update-backup reads the processing index from (see getOutputDir).
+	workingPath := getWorkingPath()
+	if inputDir == "" {
+		inputDir = filepath.Join(workingPath, "downloads")
+	}
+	if outputDir == "" {
+		outputDir = filepath.Join(workingPath, "output")
+	}
+	if albumsDir == "" {
+		albumsDir = filepath.Join(outputDir, "albums")
+	}
+	return inputDir, outputDir, albumsDir
+}
+
+// runProcess extracts and organizes every downloaded export not processed yet
+func runProcess(opts processOptions) (processResult, error) {
+	var result processResult
+	inputDir, outputDir, albumsDir := opts.resolveDirs()
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		logger.Error("%v", err)
+		return result, failed(exitProcess, err)
+	}
+	pending := pendingProcessExports(inputDir, outputDir)
 
-		// Validate directories
-		if inputDir == "" {
-			// Try to infer from working_path
-			workingPath := viper.GetString("working_path")
-			if workingPath != "" {
-				inputDir = filepath.Join(workingPath, "downloads")
-			} else {
-				inputDir = "downloads"
-			}
+	logger.Info(i18n.T("process_start"))
+	logger.Info(i18n.T("process_input"), inputDir)
+	logger.Info(i18n.T("process_output"), outputDir)
+	logger.Info(i18n.T("process_albums"), albumsDir)
+
+	pm := processor.NewManager(inputDir, outputDir, albumsDir)
+	pm.DeleteOrigin = opts.DeleteOrigin
+	pm.ForceMetadata = opts.ForceMetadata
+	pm.ForceExtraction = opts.ForceExtraction
+	pm.ForceDedup = opts.ForceDedup
+	pm.TargetExport = opts.Export
+	pm.Space = spaceGuard(inputDir, "extraction")
+	pm.Context = opts.Context
+
+	// Handle --fix-ambiguous-metadata
+	// Priority: Flag > Config > Default
+	if opts.FixAmbiguousMetadata != "" {
+		pm.FixAmbiguousMetadata = opts.FixAmbiguousMetadata
+	} else {
+		// Get from viper (config or default)
+		pm.FixAmbiguousMetadata = viper.GetString("fix_ambiguous_metadata")
+	}
+
+	if err := pm.Run(); err != nil {
+		logger.Error(i18n.T("process_fail"), err)
+		return result, failed(exitProcess, err)
+	}
+
+	for _, id := range pending {
+		if pm.ProcessedExports[id] {
+			result.Processed = append(result.Processed, id)
 		}
-		if outputDir == "" {
-			outputDir = "output"
+	}
+	// A shutdown during a free-space pause is not a failure: extraction resumes next time
+	interrupted := false
+	for id, err := range pm.Failed {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			delete(pm.Failed, id)
+			interrupted = true
 		}
-		if albumsDir == "" {
-			albumsDir = filepath.Join(outputDir, "albums")
+	}
+	if workingPath := getWorkingPath(); workingPath != "" {
+		recordProcessed(filepath.Join(workingPath, "history.json"), result.Processed, pm.Failed)
+	}
+
+	if len(pm.Failed) > 0 {
+		var ids []string
+		for id := range pm.Failed {
+			ids = append(ids, id)
 		}
+		sort.Strings(ids)
+		return result, failed(exitProcess, fmt.Errorf("%d export(s) failed (%s): %w", len(ids), strings.Join(ids, ", "), pm.Failed[ids[0]]))
+	}
+	if interrupted {
+		return result, failed(exitProcess, errInterrupted)
+	}
+	logger.Info(i18n.T("process_success"))
+	return result, nil
+}
 
-		logger.Info(i18n.T("process_start"))
-		logger.Info(i18n.T("process_input"), inputDir)
-		logger.Info(i18n.T("process_output"), outputDir)
-		logger.Info(i18n.T("process_albums"), albumsDir)
-
-		pm := processor.NewManager(inputDir, outputDir, albumsDir)
-		pm.DeleteOrigin, _ = cmd.Flags().GetBool("delete-origin")
-		pm.ForceMetadata, _ = cmd.Flags().GetBool("force-metadata")
-		pm.ForceExtraction, _ = cmd.Flags().GetBool("force-extract")
-		pm.ForceDedup, _ = cmd.Flags().GetBool("force-dedup")
-		pm.TargetExport, _ = cmd.Flags().GetString("export")
-
-		// Handle --fix-ambiguous-metadata
-		// Priority: Flag > Config > Default
-		flagAmbiguous, _ := cmd.Flags().GetString("fix-ambiguous-metadata")
-		if flagAmbiguous != "" {
-			pm.FixAmbiguousMetadata = flagAmbiguous
-		} else {
-			// Get from viper (config or default)
-			pm.FixAmbiguousMetadata = viper.GetString("fix_ambiguous_metadata")
+// recordProcessed moves the exports just processed to extracted in history.json, and
+// the ones that failed to failed
+func recordProcessed(historyPath string, processed []string, failures map[string]error) {
+	if len(processed) == 0 && len(failures) == 0 {
+		return
+	}
+	reg, err := registry.New(historyPath)
+	if err != nil {
+		logger.Error("Cannot load history: %v", err)
+		return
+	}
+	for _, id := range processed {
+		recordStatus(reg, id, registry.StatusExtracted, func(e *registry.ExportEntry) { e.Error = "" })
+	}
+	for id, err := range failures {
+		recordStatus(reg, id, registry.StatusFailed, func(e *registry.ExportEntry) { e.Error = err.Error() })
+	}
+	if err := reg.Save(); err != nil {
+		logger.Error("Cannot save history: %v", err)
+	}
+}
+
+// recordStatus moves export id to status to and applies edit (stats) to it. Exports
+// missing from history and transitions refused by the lifecycle are only logged.
+func recordStatus(reg *registry.Registry, id string, to registry.ExportStatus, edit func(e *registry.ExportEntry)) {
+	if reg.Get(id) == nil {
+		logger.Debug("Export %s is not in history.json", id)
+		return
+	}
+	if err := reg.Advance(id, to); err != nil {
+		logger.Info("⚠️  Not recorded in history.json: %v", err)
+		return
+	}
+	if edit != nil {
+		entry := reg.Get(id)
+		edit(entry)
+		if err := reg.Update(*entry); err != nil {
+			logger.Info("⚠️  Not recorded in history.json: %v", err)
 		}
+	}
+}
 
-		if err := pm.Run(); err != nil {
-			logger.Error(i18n.T("process_fail"), err)
-		} else {
-			logger.Info(i18n.T("process_success"))
+// pendingProcessExports returns the exports in history.json that have a download
+// directory but are not marked as processed yet
+func pendingProcessExports(inputDir, outputDir string) []string {
+	reg, err := registry.New(filepath.Join(filepath.Dir(inputDir), "history.json"))
+	if err != nil {
+		return nil
+	}
+	pm := processor.NewManager(inputDir, outputDir, "")
+	pm.LoadState(outputDir, true)
+
+	var pending []string
+	for _, entry := range reg.Exports {
+		if entry.ID == "" || pm.ProcessedExports[entry.ID] {
+			continue
 		}
-	},
+		if info, err := os.Stat(filepath.Join(inputDir, entry.ID)); err == nil && info.IsDir() {
+			pending = append(pending, entry.ID)
+		}
+	}
+	return pending
 }
 
 func init() {
@@ -16,9 +16,10 @@ import (
 )
 
 var rebuildImmichCmd = &cobra.Command{
-	Use:   "rebuild-immich-master",
-	Short: "Rebuild the Immich Master directory from existing snapshots",
-	Long:  `Scans all timestamped snapshots in the backup directory and links every file to the Immich Master directory (YYYY/MM structure). This is useful to populate the master directory for the first time or after changing configuration.`,
+	Use:         "rebuild-immich-master",
+	Short:       "Rebuild the Immich Master directory from existing snapshots",
+	Long:        `Scans all timestamped snapshots in the backup directory and links every file to the Immich Master directory (YYYY/MM structure). This is useful to populate the master directory for the first time or after changing configuration.`,
+	Annotations: locks(lockBackup),
 	Run: func(cmd *cobra.Command, args []string) {
 		logger.Info("🏗️  Starting Immich Master Rebuild...")
 
@@ -33,14 +34,7 @@ var rebuildImmichCmd = &cobra.Command{
 			return
 		}
 
-		immichPath := config.AppConfig.ImmichMasterPath
-		if immichPath == "" {
-			immichPath = viper.GetString("immich_master_path")
-		}
-		if immichPath == "" {
-			immichPath = "immich-master"
-		}
-		masterRoot := filepath.Join(backupPath, immichPath)
+		masterRoot := getImmichRoot(backupPath)
 
 		logger.Info("📂 Backup Path: %s", backupPath)
 		logger.Info("📸 Immich Master Path: %s", masterRoot)
@@ -72,8 +66,8 @@ var rebuildImmichCmd = &cobra.Command{
 			logger.Info("⚠️  Could not load master index (will start fresh): %v", err)
 			masterIndex = registry.NewIndex()
 		}
-		masterHashMap := processor.GetMasterHashMap(masterIndex)
-		logger.Info("Loaded Master Index: %d files known.", len(masterHashMap))
+		defer masterIndex.Close()
+		logger.Info("Loaded Master Index: %d files known.", masterIndex.Len())
 
 		// 3. Process Snapshots
 		totalFiles := 0
@@ -83,20 +77,20 @@ var rebuildImmichCmd = &cobra.Command{
 			snapPath := filepath.Join(backupPath, snapName)
 			logger.Info("Processing snapshot: %s", snapName)
 
-			// A. Ensure Index Exists
-			// We can call EnsureSnapshotIndex directly. It will reuse existing index if valid.
-			idx, err := processor.EnsureSnapshotIndex(snapPath)
+			// A. Load the index (only snapshots without one are indexed: sealed ones are never rewritten)
+			idx, err := snapshotIndex(snapPath)
 			if err != nil {
-				logger.Error("Failed to ensure index for %s: %v", snapName, err)
+				logger.Error("Failed to load index for %s: %v", snapName, err)
 				continue
 			}
 
 			// B. Link to Master
-			if err := processor.LinkSnapshotToMaster(snapPath, idx, masterRoot, masterIndex, masterHashMap); err != nil {
+			if err := processor.LinkSnapshotToMaster(snapPath, idx, masterRoot, masterIndex); err != nil {
 				logger.Error("Failed to link snapshot %s to master: %v", snapName, err)
 			}
 
-			totalFiles += len(idx.Files)
+			totalFiles += idx.Len()
+			idx.Close()
 			processedFiles++
 		}
 
@@ -104,7 +98,7 @@ var rebuildImmichCmd = &cobra.Command{
 		if err := masterIndex.Save(masterIndexPath); err != nil {
 			logger.Error("Failed to save Master Index: %v", err)
 		} else {
-			logger.Info("✅ Master Index Saved (%d entries).", len(masterIndex.Files))
+			logger.Info("✅ Master Index Saved (%d entries).", masterIndex.Len())
 		}
 
 		logger.Info("✅ Rebuild Complete.")
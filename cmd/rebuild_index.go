@@ -15,9 +15,10 @@ import (
 )
 
 var rebuildIndexCmd = &cobra.Command{
-	Use:   "rebuild-index",
-	Short: "Rebuild index.json for all snapshots",
-	Long:  `Scans all timestamped snapshots in the backup directory and generates/updates their index.json file. It uses Inode optimization to speed up re-indexing.`,
+	Use:         "rebuild-index",
+	Short:       "Rebuild index.json for all snapshots",
+	Long:        `Scans all timestamped snapshots in the backup directory and generates/updates their index.json file. It uses Inode optimization to speed up re-indexing.`,
+	Annotations: locks(lockBackup),
 	Run: func(cmd *cobra.Command, args []string) {
 		logger.Info("🏗️  Starting Index Rebuild...")
 
@@ -59,9 +60,10 @@ var rebuildIndexCmd = &cobra.Command{
 			snapPath := filepath.Join(backupPath, snapName)
 			// logger.Info("Indexing snapshot: %s", snapName)
 
-			if _, err := processor.EnsureSnapshotIndex(snapPath); err != nil {
+			if idx, err := processor.EnsureSnapshotIndex(snapPath); err != nil {
 				logger.Error("Failed to index %s: %v", snapName, err)
 			} else {
+				idx.Close()
 				successCount++
 			}
 		}
@@ -0,0 +1,382 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"google-photos-backup/internal/config"
+	"google-photos-backup/internal/diskspace"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/persist"
+	"google-photos-backup/internal/processor"
+	"google-photos-backup/internal/schema"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var relocateCmd = &cobra.Command{
+	Use:           "relocate",
+	Short:         "Move the working and/or backup directory to a new disk or path",
+	Long:          `Moves (or copies with --copy) working_path and/or backup_path to a new location, preserving hardlinks, then rewrites every stored path (processing_index.json, backup_log.jsonl) and the configuration. Stored paths are converted to paths relative to their root on the way, so later moves need no rewrite.`,
+	Annotations:   locks(lockWorking, lockBackup),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		newWorking, _ := cmd.Flags().GetString("working-path")
+		newBackup, _ := cmd.Flags().GetString("backup-path")
+		keep, _ := cmd.Flags().GetBool("copy")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		if newWorking == "" && newBackup == "" {
+			return logFailed(exitConfig, fmt.Errorf("nothing to do: pass --working-path and/or --backup-path"))
+		}
+
+		oldWorking, _ := filepath.Abs(getWorkingPath())
+		oldBackup, _ := filepath.Abs(getBackupPath())
+
+		type move struct {
+			key      string // Config key
+			from, to string
+		}
+		var moves []move
+		if newWorking != "" {
+			to, _ := filepath.Abs(expandPath(newWorking))
+			moves = append(moves, move{"working_path", oldWorking, to})
+		}
+		if newBackup != "" {
+			to, _ := filepath.Abs(expandPath(newBackup))
+			moves = append(moves, move{"backup_path", oldBackup, to})
+		}
+
+		// 1. Validate
+		var copied []string // Trees copied to another filesystem instead of renamed
+		for _, mv := range moves {
+			if mv.from == mv.to {
+				return logFailed(exitConfig, fmt.Errorf("%s is already %s", mv.key, mv.to))
+			}
+			if strings.HasPrefix(mv.to+string(os.PathSeparator), mv.from+string(os.PathSeparator)) {
+				return logFailed(exitConfig, fmt.Errorf("cannot move %s into itself (%s)", mv.from, mv.to))
+			}
+			if _, err := os.Stat(mv.from); err != nil {
+				return logFailed(exitConfig, fmt.Errorf("source %s not found: %w", mv.from, err))
+			}
+			if entries, err := os.ReadDir(mv.to); err == nil && len(entries) > 0 {
+				return logFailed(exitConfig, fmt.Errorf("destination %s exists and is not empty", mv.to))
+			}
+			if keep || !diskspace.SameDevice(mv.from, mv.to) {
+				copied = append(copied, mv.from)
+			}
+			logger.Info("📦 %s: %s -> %s", mv.key, mv.from, mv.to)
+		}
+
+		// Hardlinks to files outside the copied trees (typically between working_path
+		// and backup_path) cannot be kept: each such file would be stored twice
+		if files, size := externalLinks(copied); files > 0 {
+			if !keep {
+				return logFailed(exitConfig, fmt.Errorf("%d files (%s) are hardlinked outside of %s: moving them to another filesystem would store them twice. Relocate working_path and backup_path together, or pass --copy to accept the duplicates",
+					files, formatSizeForBackup(size), strings.Join(copied, ", ")))
+			}
+			logger.Info("⚠️  %d files (%s) are hardlinked outside of the copied trees and will be duplicated.", files, formatSizeForBackup(size))
+		}
+		if dryRun {
+			logger.Info("Dry run: nothing moved.")
+			return nil
+		}
+
+		// 2. Move data. The inode map is shared so links between both trees survive a copy.
+		copiedInodes := make(map[inodeKey]string)
+		for _, mv := range moves {
+			if err := relocateTree(mv.from, mv.to, keep, copiedInodes); err != nil {
+				return logFailed(exitFailure, fmt.Errorf("failed to relocate %s: %w", mv.from, err))
+			}
+		}
+
+		// 3. Rewrite stored paths
+		finalWorking, finalBackup := oldWorking, oldBackup
+		for _, mv := range moves {
+			if mv.key == "working_path" {
+				finalWorking = mv.to
+			} else {
+				finalBackup = mv.to
+			}
+		}
+		rewrite := func(p string) string {
+			for _, mv := range moves {
+				if p == mv.from || strings.HasPrefix(p, mv.from+string(os.PathSeparator)) {
+					return mv.to + strings.TrimPrefix(p, mv.from)
+				}
+			}
+			return p
+		}
+
+		// The data is moved already: the configuration is updated even if a rewrite fails
+		rewritten, rewriteFailures := rewriteProcessingIndexes(finalWorking, rewrite)
+		logger.Info("📝 Rewrote %d processing indexes.", rewritten)
+		var rewriteErr error
+		if rewriteFailures > 0 {
+			rewriteErr = fmt.Errorf("%d processing indexes could not be rewritten", rewriteFailures)
+		}
+		if err := rewriteBackupLog(finalBackup, finalWorking, rewrite); err != nil && !os.IsNotExist(err) {
+			logger.Error("Failed to rewrite backup log: %v", err)
+			rewriteErr = fmt.Errorf("failed to rewrite backup log: %w", err)
+		}
+
+		// 4. Update configuration
+		values := make(map[string]string)
+		for _, mv := range moves {
+			viper.Set(config.AccountKey(mv.key), mv.to)
+			values[config.AccountKey(mv.key)] = mv.to
+		}
+		if viper.ConfigFileUsed() != "" {
+			if err := updateConfigFile(viper.ConfigFileUsed(), values); err != nil {
+				return logFailed(exitConfig, fmt.Errorf("data relocated, but the configuration could not be updated: %w", err))
+			}
+			logger.Info("📝 Configuration updated: %s", viper.ConfigFileUsed())
+		} else {
+			logger.Info("⚠️  No configuration file in use. Remember to set the new paths.")
+		}
+
+		if rewriteErr != nil {
+			logger.Error("Data relocated, but stored paths were not all rewritten. Fix the errors above and check the files they name.")
+			return failed(exitFailure, rewriteErr)
+		}
+		logger.Info("✅ Relocation complete.")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(relocateCmd)
+	relocateCmd.Flags().String("working-path", "", "New location for working_path")
+	relocateCmd.Flags().String("backup-path", "", "New location for backup_path")
+	relocateCmd.Flags().Bool("copy", false, "Copy instead of move (keep the original)")
+	relocateCmd.Flags().Bool("dry-run", false, "Only validate and show what would be moved")
+}
+
+// updateConfigFile sets keys in the configuration file and nothing else: the global viper
+// also holds defaults and flags, which WriteConfig would add to the file
+func updateConfigFile(path string, values map[string]string) error {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	for key, value := range values {
+		v.Set(key, value)
+	}
+	return v.WriteConfig()
+}
+
+// relocateTree renames from to to when possible; across devices (or with keep) it copies
+// the tree preserving hardlinks and symlinks, verifies it and removes the original.
+func relocateTree(from, to string, keep bool, copiedInodes map[inodeKey]string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	if !keep {
+		os.Remove(to) // Empty destination directory, if any
+		err := os.Rename(from, to)
+		if err == nil {
+			logger.Info("   Renamed in place (same filesystem).")
+			return nil
+		}
+		if linkErr, ok := err.(*os.LinkError); !ok || linkErr.Err != syscall.EXDEV {
+			return err
+		}
+		logger.Info("   Different filesystem, copying (hardlinks preserved)...")
+	}
+
+	files, bytes, err := copyTreePreservingLinks(from, to, copiedInodes)
+	if err != nil {
+		return err
+	}
+
+	// Verify: same number of files and bytes on both sides
+	checkFiles, checkBytes, err := countTree(to)
+	if err != nil {
+		return err
+	}
+	if checkFiles != files || checkBytes != bytes {
+		return fmt.Errorf("verification failed: copied %d files (%d bytes), found %d files (%d bytes)", files, bytes, checkFiles, checkBytes)
+	}
+	logger.Info("   Copied %d files (%s).", files, formatSizeForBackup(bytes))
+
+	if keep {
+		return nil
+	}
+	return os.RemoveAll(from)
+}
+
+// copyTreePreservingLinks copies src to dst. Files sharing an inode in src share one in dst;
+// seen (source inode -> first copy) may be shared between trees copied to the same disk.
+func copyTreePreservingLinks(src, dst string, seen map[inodeKey]string) (int, int64, error) {
+	files := 0
+	var bytes int64
+	counted := make(map[inodeKey]bool) // Inodes whose size is in bytes, like countTree
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+
+		files++
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Nlink > 1 {
+			key := inodeKey{Dev: uint64(stat.Dev), Ino: stat.Ino}
+			if !counted[key] {
+				counted[key] = true
+				bytes += info.Size()
+			}
+			if first, ok := seen[key]; ok {
+				err := os.Link(first, target)
+				if linkErr, isLink := err.(*os.LinkError); !isLink || linkErr.Err != syscall.EXDEV {
+					return err
+				}
+				// First copied into the other tree, on another disk
+			}
+			seen[key] = target
+		} else {
+			bytes += info.Size()
+		}
+		if err := copyFile(path, target); err != nil {
+			return err
+		}
+		return os.Chmod(target, info.Mode().Perm())
+	})
+	return files, bytes, err
+}
+
+// externalLinks returns the regular files below roots that have hardlinks outside of
+// them, and their size
+func externalLinks(roots []string) (int, int64) {
+	type inode struct {
+		nlink, found uint64
+		size         int64
+	}
+	inodes := make(map[inodeKey]*inode)
+	for _, root := range roots {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+			stat, ok := info.Sys().(*syscall.Stat_t)
+			if !ok || stat.Nlink < 2 {
+				return nil
+			}
+			key := inodeKey{Dev: uint64(stat.Dev), Ino: stat.Ino}
+			if inodes[key] == nil {
+				inodes[key] = &inode{nlink: uint64(stat.Nlink), size: info.Size()}
+			}
+			inodes[key].found++
+			return nil
+		})
+	}
+
+	files := 0
+	var size int64
+	for _, in := range inodes {
+		if in.found < in.nlink {
+			files++
+			size += in.size
+		}
+	}
+	return files, size
+}
+
+// countTree returns the number of regular files and the bytes of unique inodes below root
+func countTree(root string) (int, int64, error) {
+	seen := make(map[inodeKey]bool)
+	files := 0
+	var bytes int64
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		files++
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			key := inodeKey{Dev: uint64(stat.Dev), Ino: stat.Ino}
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+		bytes += info.Size()
+		return nil
+	})
+	return files, bytes, err
+}
+
+// rewriteProcessingIndexes migrates every processing_index.json below the working path and
+// returns how many were rewritten and how many failed
+func rewriteProcessingIndexes(workingPath string, rewrite func(string) string) (int, int) {
+	downloads := filepath.Join(workingPath, "downloads")
+	dirs := []string{downloads, filepath.Join(workingPath, "output")}
+	if entries, err := os.ReadDir(downloads); err == nil {
+		for _, e := range entries {
+			if e.IsDir() {
+				dirs = append(dirs, filepath.Join(downloads, e.Name()))
+			}
+		}
+	}
+
+	count, failures := 0, 0
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, processor.IndexFileName)); err != nil {
+			continue
+		}
+		if err := processor.RewriteStatePaths(dir, rewrite); err != nil {
+			logger.Error("Failed to rewrite %s: %v", filepath.Join(dir, processor.IndexFileName), err)
+			failures++
+			continue
+		}
+		count++
+	}
+	return count, failures
+}
+
+// rewriteBackupLog rewrites backup_log.jsonl with paths relative to their roots
+func rewriteBackupLog(backupPath, workingPath string, rewrite func(string) string) error {
+	entries, err := readBackupLog(backupPath)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for _, entry := range entries {
+		entry.Snapshot = relativeTo(backupPath, rewrite(entry.Snapshot))
+		if filepath.IsAbs(entry.Source) {
+			entry.Source = relativeTo(workingPath, rewrite(entry.Source))
+		}
+		entry.SchemaVersion = schema.Current(schema.BackupLog)
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	return persist.WriteFile(filepath.Join(backupPath, "backup_log.jsonl"), []byte(b.String()), 0644)
+}
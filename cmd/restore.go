@@ -0,0 +1,351 @@
+package cmd
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google-photos-backup/internal/i18n"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/processor"
+	"google-photos-backup/internal/registry"
+
+	"github.com/spf13/cobra"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:           "restore",
+	Short:         "Restore files, albums or date ranges from a snapshot",
+	Long:          `Selects files from a snapshot (latest by default) by album glob, corrected date range, hash or filename pattern and copies them (never links) to a target directory or ZIP file. Every restored file is verified against the SHA-256 stored in index.json and gets its corrected modification time back.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupPath := getBackupPath()
+		if backupPath == "" {
+			logger.Error(i18n.T("update_backup_no_config"))
+			return failed(exitConfig, fmt.Errorf("backup_path is not set"))
+		}
+
+		target, _ := cmd.Flags().GetString("target")
+		zipPath, _ := cmd.Flags().GetString("zip")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if target == "" && zipPath == "" && !dryRun {
+			return logFailed(exitConfig, fmt.Errorf("either --target or --zip is required"))
+		}
+
+		sel, err := newRestoreSelector(cmd)
+		if err != nil {
+			return logFailed(exitConfig, err)
+		}
+
+		// 1. Resolve Snapshot
+		snapName, _ := cmd.Flags().GetString("snapshot")
+		var snapPath string
+		if snapName == "" {
+			snapPath = findLatestBackup(backupPath)
+			if snapPath == "" {
+				logger.Info("⚠️  No snapshots found.")
+				return nil
+			}
+		} else {
+			snapPath = resolveSnapshotPath(backupPath, snapName)
+		}
+
+		idx, err := loadSnapshotIndex(snapPath)
+		if err != nil {
+			return logFailed(exitFailure, err)
+		}
+		defer idx.Close()
+
+		// 2. Select Entries
+		withSidecars, _ := cmd.Flags().GetBool("sidecars")
+		selected, err := sel.apply(idx, withSidecars)
+		if err != nil {
+			return logFailed(exitFailure, err)
+		}
+		if len(selected) == 0 {
+			logger.Info("⚠️  No files match the given selectors in %s.", filepath.Base(snapPath))
+			return nil
+		}
+
+		var totalBytes int64
+		for _, e := range selected {
+			totalBytes += e.Size
+		}
+		logger.Info("📦 Restoring %d files (%s) from snapshot %s", len(selected), formatSizeForBackup(totalBytes), filepath.Base(snapPath))
+
+		if dryRun {
+			for _, e := range selected {
+				fmt.Printf("  %s  %s\n", e.ModTime.Format("2006-01-02"), e.RelPath)
+			}
+			return nil
+		}
+
+		// 3. Copy & Verify
+		var restored, failures int
+		if zipPath != "" {
+			restored, failures, err = restoreToZip(snapPath, selected, expandPath(zipPath))
+			if err != nil {
+				return logFailed(exitFailure, fmt.Errorf("failed to write ZIP %s: %w", zipPath, err))
+			}
+		} else {
+			restored, failures = restoreToDir(snapPath, selected, expandPath(target))
+		}
+
+		if failures > 0 {
+			return logFailed(exitFailure, fmt.Errorf("restore finished with %d failures (%d files restored and verified)", failures, restored))
+		}
+		logger.Info("✅ Restore complete. %d files restored and verified.", restored)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+	restoreCmd.Flags().String("snapshot", "", "Snapshot to restore from (defaults to the latest)")
+	restoreCmd.Flags().String("target", "", "Directory to copy the restored files into")
+	restoreCmd.Flags().String("zip", "", "Pack the restored files into this ZIP file instead of a directory")
+	restoreCmd.Flags().String("album", "", "Album path glob, relative to 'Google Photos' (e.g. 'Trip*')")
+	restoreCmd.Flags().String("from", "", "Only files dated on or after this day (YYYY-MM-DD, corrected dates)")
+	restoreCmd.Flags().String("to", "", "Only files dated on or before this day (YYYY-MM-DD, corrected dates)")
+	restoreCmd.Flags().String("hash", "", "Only the file(s) with this SHA-256 (prefix allowed)")
+	restoreCmd.Flags().String("name", "", "Filename glob (e.g. 'IMG_2023*.jpg')")
+	restoreCmd.Flags().Bool("sidecars", false, "Also restore JSON/XMP sidecars of the selected files")
+	restoreCmd.Flags().Bool("dry-run", false, "Only list the files that would be restored")
+}
+
+// restoreSelector holds the filters given on the command line. Empty fields match everything.
+type restoreSelector struct {
+	album string
+	name  string
+	hash  string
+	from  time.Time
+	to    time.Time
+}
+
+func newRestoreSelector(cmd *cobra.Command) (*restoreSelector, error) {
+	sel := &restoreSelector{}
+	sel.album, _ = cmd.Flags().GetString("album")
+	sel.name, _ = cmd.Flags().GetString("name")
+	sel.hash, _ = cmd.Flags().GetString("hash")
+	sel.hash = strings.ToLower(sel.hash)
+
+	if from, _ := cmd.Flags().GetString("from"); from != "" {
+		t, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --from date %q: %w", from, err)
+		}
+		sel.from = t
+	}
+	if to, _ := cmd.Flags().GetString("to"); to != "" {
+		t, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --to date %q: %w", to, err)
+		}
+		sel.to = t.AddDate(0, 0, 1) // Inclusive day
+	}
+
+	if sel.album != "" {
+		if _, err := path.Match(sel.album, ""); err != nil {
+			return nil, fmt.Errorf("invalid --album pattern: %w", err)
+		}
+	}
+	if sel.name != "" {
+		if _, err := path.Match(sel.name, ""); err != nil {
+			return nil, fmt.Errorf("invalid --name pattern: %w", err)
+		}
+	}
+	return sel, nil
+}
+
+func (s *restoreSelector) matches(e registry.FileIndexEntry) bool {
+	relPath := filepath.ToSlash(e.RelPath)
+
+	if s.album != "" {
+		album := path.Dir(strings.TrimPrefix(relPath, "Google Photos/"))
+		if ok, _ := path.Match(s.album, album); !ok {
+			return false
+		}
+	}
+	if s.name != "" {
+		if ok, _ := path.Match(strings.ToLower(s.name), strings.ToLower(path.Base(relPath))); !ok {
+			return false
+		}
+	}
+	if s.hash != "" && !strings.HasPrefix(e.Hash, s.hash) {
+		return false
+	}
+	if !s.from.IsZero() && e.ModTime.Before(s.from) {
+		return false
+	}
+	if !s.to.IsZero() && !e.ModTime.Before(s.to) {
+		return false
+	}
+	return true
+}
+
+// apply returns the matching media entries (plus their sidecars if requested), sorted by path
+func (s *restoreSelector) apply(idx *registry.Index, withSidecars bool) ([]registry.FileIndexEntry, error) {
+	picked := make(map[string]registry.FileIndexEntry)
+	err := idx.ForEach(func(relPath string, e registry.FileIndexEntry) error {
+		if processor.IsIgnoredFile(relPath) || !s.matches(e) {
+			return nil
+		}
+		picked[relPath] = e
+
+		if withSidecars {
+			base := strings.TrimSuffix(relPath, filepath.Ext(relPath))
+			for _, side := range []string{relPath + ".json", relPath + ".supplemental-metadata.json", relPath + ".xmp", base + ".xmp", base + ".XMP"} {
+				if sideEntry, ok := idx.Get(side); ok {
+					picked[side] = sideEntry
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]registry.FileIndexEntry, 0, len(picked))
+	for _, e := range picked {
+		result = append(result, e)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].RelPath < result[j].RelPath })
+	return result, nil
+}
+
+// restoreToDir copies every entry below targetDir, verifying hashes and restoring mtimes
+func restoreToDir(snapPath string, entries []registry.FileIndexEntry, targetDir string) (int, int) {
+	restored, failed := 0, 0
+	for _, e := range entries {
+		src := filepath.Join(snapPath, e.RelPath)
+		dst := filepath.Join(targetDir, e.RelPath)
+		if err := copyVerified(src, dst, e.Hash); err != nil {
+			logger.Error("Failed to restore %s: %v", e.RelPath, err)
+			failed++
+			continue
+		}
+		if err := os.Chtimes(dst, e.ModTime, e.ModTime); err != nil {
+			logger.Error("Failed to restore mtime of %s: %v", e.RelPath, err)
+		}
+		restored++
+	}
+	return restored, failed
+}
+
+// copyVerified copies src to dst while hashing. The copy is written to a temporary file
+// and renamed over dst only if the hash matches, so an existing dst (possibly a hardlink
+// shared with snapshots or the mirror) is replaced, never truncated in place.
+func copyVerified(src, dst, expectedHash string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := out.Name()
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hasher), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if got := hex.EncodeToString(hasher.Sum(nil)); got != expectedHash {
+		os.Remove(tmpPath)
+		return fmt.Errorf("checksum mismatch (expected %s, got %s)", expectedHash, got)
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// restoreToZip packs every entry into a ZIP file, verifying hashes on the fly.
+// Entries whose content does not match the index are reported and left out.
+func restoreToZip(snapPath string, entries []registry.FileIndexEntry, zipPath string) (int, int, error) {
+	if err := os.MkdirAll(filepath.Dir(zipPath), 0755); err != nil {
+		return 0, 0, err
+	}
+	f, err := os.Create(zipPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	restored, failed := 0, 0
+
+	for _, e := range entries {
+		// Verify first, so that a corrupted file never ends up in the archive
+		hash, err := calculateHash(filepath.Join(snapPath, e.RelPath))
+		if err != nil || hash != e.Hash {
+			if err == nil {
+				err = fmt.Errorf("checksum mismatch (expected %s, got %s)", e.Hash, hash)
+			}
+			logger.Error("Failed to restore %s: %v", e.RelPath, err)
+			failed++
+			continue
+		}
+
+		in, err := os.Open(filepath.Join(snapPath, e.RelPath))
+		if err != nil {
+			logger.Error("Failed to restore %s: %v", e.RelPath, err)
+			failed++
+			continue
+		}
+
+		method := zip.Store // Media is already compressed
+		if strings.EqualFold(filepath.Ext(e.RelPath), ".json") || strings.EqualFold(filepath.Ext(e.RelPath), ".xmp") {
+			method = zip.Deflate
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     filepath.ToSlash(e.RelPath),
+			Method:   method,
+			Modified: e.ModTime,
+		})
+		if err != nil {
+			in.Close()
+			return restored, failed, err
+		}
+		_, err = io.Copy(w, in)
+		in.Close()
+		if err != nil {
+			return restored, failed, err
+		}
+		restored++
+	}
+
+	if err := zw.Close(); err != nil {
+		return restored, failed, err
+	}
+	return restored, failed, f.Sync()
+}
@@ -1,9 +1,14 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"google-photos-backup/internal/config"
+	"google-photos-backup/internal/fsutil"
 	"google-photos-backup/internal/i18n" // <--- Importante
+	"google-photos-backup/internal/lock"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/persist"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -16,6 +21,22 @@ var rootCmd = &cobra.Command{
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		i18n.Init()         // <--- Detectar idioma PRIMERO
 		config.InitConfig() // Luego la config
+		configureLinker()
+		persist.Recover, _ = cmd.Flags().GetBool("recover")
+
+		account, _ := cmd.Flags().GetString("account")
+		if err := config.SelectAccount(account); err != nil {
+			logger.Error("%v", err)
+			os.Exit(exitConfig)
+		}
+
+		if err := acquireLocks(cmd); err != nil {
+			logger.Error("%v", err)
+			if _, ok := err.(*lock.HeldError); ok {
+				os.Exit(exitLocked)
+			}
+			os.Exit(exitFailure)
+		}
 	},
 	// ... resto del código ...
 }
@@ -25,13 +46,33 @@ func init() {
 	rootCmd.AddCommand(syncCmd)
 	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Enable verbose output")
 	rootCmd.PersistentFlags().Bool("non-interactive", false, "Disable interactive UI (progress bars)")
+	rootCmd.PersistentFlags().String("account", "", "Account profile to use (see 'accounts' in config)")
+	rootCmd.PersistentFlags().Bool("wait", false, "Wait for another run holding the lock (default unless --non-interactive)")
+	rootCmd.PersistentFlags().Bool("no-wait", false, "Fail immediately if another run holds the lock")
+	rootCmd.PersistentFlags().Bool("recover", false, "Restore corrupt state files from their .bak copy")
 	viper.BindPFlag("verbose", rootCmd.PersistentFlags().Lookup("verbose"))
 	viper.BindPFlag("non_interactive", rootCmd.PersistentFlags().Lookup("non-interactive"))
 }
 
+// configureLinker sets the link strategy used by every deduplication step
+func configureLinker() {
+	strategy, err := fsutil.ParseStrategy(config.AppConfig.LinkStrategy)
+	if err != nil {
+		logger.Error("%v. Using hardlink.", err)
+		strategy = fsutil.StrategyHardlink
+	}
+	fsutil.SetDefault(fsutil.New(strategy))
+}
+
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	err := rootCmd.Execute()
+	releaseLocks()
+	if err != nil {
+		// Stage errors were already logged where they happened
+		var se *stageError
+		if !errors.As(err, &se) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google-photos-backup/internal/config"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/persist"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// run.go chains sync → process → update-backup → Immich master in one process. Progress is
+// saved after every stage in working_path/run_state.json, so a run that crashed or failed
+// resumes at the first stage that did not complete.
+
+const runStateFile = "run_state.json"
+
+// Pipeline stages, in order
+const (
+	stageSync    = "sync"
+	stageProcess = "process"
+	stageBackup  = "backup"
+	stageImmich  = "immich"
+)
+
+var runStages = []string{stageSync, stageProcess, stageBackup, stageImmich}
+
+// runState is the progress of the current (or last) run
+type runState struct {
+	StartedAt   time.Time `json:"started_at"`
+	Completed   []string  `json:"completed_stages"`
+	Finished    bool      `json:"finished"`
+	Downloaded  []string  `json:"downloaded_exports,omitempty"` // Set by sync
+	Waiting     bool      `json:"waiting,omitempty"`            // Set by sync: Takeout is preparing an export
+	Processed   []string  `json:"processed_exports,omitempty"`  // Set by process
+	Snapshot    string    `json:"snapshot,omitempty"`           // Set by backup
+	FailedStage string    `json:"failed_stage,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	ExitCode    int       `json:"exit_code,omitempty"`
+}
+
+func (s *runState) done(stage string) bool {
+	for _, c := range s.Completed {
+		if c == stage {
+			return true
+		}
+	}
+	return false
+}
+
+func loadRunState(path string) (*runState, error) {
+	var state runState
+	err := persist.Load(path, func(data []byte) error {
+		state = runState{}
+		return json.Unmarshal(data, &state)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (s *runState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return persist.WriteState(path, data, 0644)
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run the whole pipeline: sync, process, update-backup and Immich master",
+	Long: `Runs sync, process, update-backup and the Immich master update in order, skipping
+stages with nothing to do. Progress is saved after each stage: after a crash or a failure the
+next run resumes at the stage that did not complete (use --restart to start over).
+
+Exit codes: 0 ok, 1 unclassified error, 2 configuration, 3 sync/download, 4 process,
+5 backup, 6 not enough free space, 7 deletion alert (backup done, too many files
+disappeared upstream), 8 another run holds the lock, 9 corrupt state file (see --recover),
+10 backed-up data failed verification (scrub, verify-manifest, verify-chain, mirror).`,
+	Annotations:   locks(lockWorking, lockBackup),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+		restart, _ := cmd.Flags().GetBool("restart")
+		_, err := runPipeline(pipelineOptions{Force: force, Restart: restart})
+		return err
+	},
+}
+
+// pipelineOptions are the run flags, also set by the daemon
+type pipelineOptions struct {
+	Force   bool            // Request a new export ignoring backup_frequency
+	Restart bool            // Ignore an unfinished run
+	Context context.Context // Set by the daemon: no stage starts once it is cancelled
+	OnStage func(string)    // Called before each stage runs (daemon status)
+}
+
+// runPipeline runs the stages not completed yet, saving the progress after each one
+func runPipeline(opts pipelineOptions) (*runState, error) {
+	workingPath := getWorkingPath()
+	if workingPath == "" || getBackupPath() == "" {
+		logger.Error("working_path and backup_path must be configured (run 'configure').")
+		return nil, failed(exitConfig, fmt.Errorf("working_path or backup_path is not set"))
+	}
+	if err := os.MkdirAll(workingPath, 0755); err != nil {
+		logger.Error("Cannot create working path %s: %v", workingPath, err)
+		return nil, failed(exitConfig, err)
+	}
+
+	statePath := filepath.Join(workingPath, runStateFile)
+	state, err := loadRunState(statePath)
+	switch {
+	case err != nil && !os.IsNotExist(err) && !opts.Restart:
+		logger.Error("%v (or use --restart)", err)
+		return nil, failed(exitCorrupt, err)
+	case err == nil && !state.Finished && !opts.Restart:
+		logger.Info("↩️  Resuming run started at %s (completed: %s)", state.StartedAt.Format("02/01/2006 15:04"), stageList(state.Completed))
+		state.FailedStage, state.Error, state.ExitCode = "", "", 0
+	default:
+		state = &runState{StartedAt: time.Now()}
+	}
+	if err := state.save(statePath); err != nil {
+		logger.Error("Failed to save %s: %v", statePath, err)
+		return state, failed(exitConfig, err)
+	}
+
+	var alert error
+	for _, stage := range runStages {
+		if state.done(stage) {
+			logger.Info("⏭️  Stage %s already completed", stage)
+			continue
+		}
+		if opts.Context != nil && opts.Context.Err() != nil {
+			logger.Info("⏹️  Stopping before stage %s. The next run resumes here.", stage)
+			return state, failed(exitFailure, errInterrupted)
+		}
+		logger.Info("▶️  Stage %s", stage)
+		if opts.OnStage != nil {
+			opts.OnStage(stage)
+		}
+
+		err := runStage(stage, state, opts)
+		if exitCode(err) == exitDeletionAlert {
+			// The snapshot is complete: finish the pipeline, then report the alert
+			alert, err = err, nil
+		}
+		if errors.Is(err, errInterrupted) {
+			// Not a failure: the stage saved its progress and runs again next time
+			state.save(statePath)
+			logger.Info("⏹️  Stage %s interrupted. The next run resumes here.", stage)
+			return state, err
+		}
+		if err != nil {
+			state.FailedStage = stage
+			state.Error = err.Error()
+			state.ExitCode = exitCode(err)
+			state.save(statePath)
+			logger.Error("Stage %s failed (exit code %d). The next run resumes here.", stage, state.ExitCode)
+			return state, err
+		}
+
+		state.Completed = append(state.Completed, stage)
+		if err := state.save(statePath); err != nil {
+			logger.Error("Failed to save %s: %v", statePath, err)
+		}
+	}
+
+	state.Finished = true
+	if alert != nil {
+		state.Error = alert.Error()
+		state.ExitCode = exitCode(alert)
+	}
+	state.save(statePath)
+
+	snapshot := "none"
+	if state.Snapshot != "" {
+		snapshot = filepath.Base(state.Snapshot)
+	}
+	logger.Info("✅ Run finished: %d downloaded, %d processed, new snapshot: %s", len(state.Downloaded), len(state.Processed), snapshot)
+	return state, alert
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+	runCmd.Flags().Bool("force", false, "Request a new export ignoring backup_frequency")
+	runCmd.Flags().Bool("restart", false, "Ignore an unfinished run and start from the sync stage")
+}
+
+// runStage runs one stage, recording what the next stages need in state
+func runStage(stage string, state *runState, opts pipelineOptions) error {
+	switch stage {
+	case stageSync:
+		result, err := runSync(syncOptions{Force: opts.Force, Context: opts.Context})
+		state.Downloaded = result.Downloaded
+		state.Waiting = result.Waiting || result.Requested
+		return err
+
+	case stageProcess:
+		opts := processOptions{DeleteOrigin: true, Context: opts.Context}
+		inputDir, outputDir, _ := opts.resolveDirs()
+		pending := pendingProcessExports(inputDir, outputDir)
+		if len(pending) == 0 {
+			logger.Info("⏭️  Nothing to process")
+			return nil
+		}
+		if len(state.Downloaded) > 0 {
+			logger.Info("📦 %d export(s) to process, %d downloaded in this run", len(pending), len(state.Downloaded))
+		}
+		result, err := runProcess(opts)
+		state.Processed = result.Processed
+		return err
+
+	case stageBackup:
+		source := filepath.Join(getWorkingPath(), "downloads")
+		if pending := pendingBackupExports(source); len(pending) == 0 {
+			logger.Info("⏭️  Nothing to back up")
+			return nil
+		}
+		result, err := runUpdateBackup(updateBackupOptions{SkipImmich: true, Processed: state.Processed, Context: opts.Context})
+		state.Snapshot = result.Snapshot
+		return err
+
+	case stageImmich:
+		if !config.AppConfig.ImmichMasterEnabled && !viper.GetBool("immich_master_enabled") {
+			return nil
+		}
+		if state.Snapshot == "" {
+			logger.Info("⏭️  No new snapshot for the Immich master")
+			return nil
+		}
+		idx, err := loadSnapshotIndex(state.Snapshot)
+		if err != nil {
+			logger.Error("%v", err)
+			return failed(exitBackup, err)
+		}
+		count := updateImmichMaster(getBackupPath(), state.Snapshot, idx)
+		idx.Close()
+		logger.Info("📸 Immich Master: %d files linked", count)
+		return nil
+	}
+	return fmt.Errorf("unknown stage %q", stage)
+}
+
+// pendingBackupExports returns the export directories below source that still hold a
+// "raw" folder, i.e. have content update-backup has not moved into a snapshot yet
+func pendingBackupExports(source string) []string {
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return nil
+	}
+	var pending []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(source, entry.Name(), "raw")); err == nil && info.IsDir() {
+			pending = append(pending, entry.Name())
+		}
+	}
+	return pending
+}
+
+func stageList(stages []string) string {
+	if len(stages) == 0 {
+		return "none"
+	}
+	return strings.Join(stages, ", ")
+}
@@ -0,0 +1,443 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"syscall"
+	"time"
+
+	"google-photos-backup/internal/config"
+	"google-photos-backup/internal/i18n"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/processor"
+	"google-photos-backup/internal/registry"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// scrubRef is one indexed path somewhere in the backup (snapshot, master or mirror)
+type scrubRef struct {
+	Path string
+	registry.FileIndexEntry
+}
+
+// inodeKey identifies a physical file; all hardlinks share it
+type inodeKey struct {
+	Dev uint64
+	Ino uint64
+}
+
+var scrubCmd = &cobra.Command{
+	Use:           "scrub",
+	Short:         "Detect bit rot by re-hashing snapshot files against index.json",
+	Long:          `Fully re-reads snapshot files (or a random, rate-limited sample) and compares them with the hashes stored in index.json. Because hardlinked snapshots share a single inode, every affected path is reported. With --repair, corrupted files are restored from any other copy with the same hash: another snapshot with a distinct inode, the working directory or a mirror.`,
+	Annotations:   locks(lockBackup),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupPath := getBackupPath()
+		if backupPath == "" {
+			logger.Error(i18n.T("update_backup_no_config"))
+			return failed(exitConfig, fmt.Errorf("backup_path is not set"))
+		}
+
+		onlySnapshot, _ := cmd.Flags().GetString("snapshot")
+		sample, _ := cmd.Flags().GetInt("sample")
+		rateMB, _ := cmd.Flags().GetFloat64("rate")
+		repair, _ := cmd.Flags().GetBool("repair")
+		mirrors, _ := cmd.Flags().GetStringSlice("mirror")
+
+		snapshots, err := listSnapshots(backupPath)
+		if err != nil {
+			return logFailed(exitFailure, fmt.Errorf("error reading backup dir: %w", err))
+		}
+		if len(snapshots) == 0 {
+			logger.Info("⚠️  No snapshots found.")
+			return nil
+		}
+		if onlySnapshot != "" && !slices.Contains(snapshots, onlySnapshot) {
+			return logFailed(exitFailure, fmt.Errorf("snapshot %q not found in %s", onlySnapshot, backupPath))
+		}
+
+		// 1. Load every index we know of. Scrubbing only targets the selected snapshots,
+		// but all of them are needed to find affected paths and repair sources.
+		byHash := make(map[string][]scrubRef)
+		var targets []scrubRef
+
+		addRefs := func(root string, idx *registry.Index, isTarget bool) error {
+			defer idx.Close()
+			return idx.ForEach(func(relPath string, entry registry.FileIndexEntry) error {
+				ref := scrubRef{Path: filepath.Join(root, relPath), FileIndexEntry: entry}
+				byHash[entry.Hash] = append(byHash[entry.Hash], ref)
+				if isTarget {
+					targets = append(targets, ref)
+				}
+				return nil
+			})
+		}
+
+		for _, snapName := range snapshots {
+			snapPath := filepath.Join(backupPath, snapName)
+			idx, err := loadSnapshotIndex(snapPath)
+			if err != nil {
+				logger.Info("⚠️  Snapshot %s has no index.json. Skipping. Run 'rebuild-index' first.", snapName)
+				continue
+			}
+			if err := addRefs(snapPath, idx, onlySnapshot == "" || onlySnapshot == snapName); err != nil {
+				return logFailed(exitFailure, fmt.Errorf("cannot read index of %s: %w", snapName, err))
+			}
+		}
+
+		masterRoot := getImmichRoot(backupPath)
+		if idx, err := registry.LoadIndex(filepath.Join(masterRoot, "index.json")); err == nil {
+			if err := addRefs(masterRoot, idx, false); err != nil {
+				return logFailed(exitFailure, fmt.Errorf("cannot read the Immich Master index: %w", err))
+			}
+		}
+
+		// Extra repair sources: mirrors and the working directory
+		var repairSources []scrubRef
+		for _, mirror := range mirrors {
+			mirror = expandPath(mirror)
+			mirrorSnaps, err := listSnapshots(mirror)
+			if err != nil {
+				logger.Error("Failed to read mirror %s: %v", mirror, err)
+				continue
+			}
+			for _, snapName := range mirrorSnaps {
+				if idx, err := loadSnapshotIndex(filepath.Join(mirror, snapName)); err == nil {
+					idx.ForEach(func(relPath string, entry registry.FileIndexEntry) error {
+						repairSources = append(repairSources, scrubRef{Path: filepath.Join(mirror, snapName, relPath), FileIndexEntry: entry})
+						return nil
+					})
+					idx.Close()
+				}
+			}
+		}
+		repairSources = append(repairSources, workingDirRefs()...)
+
+		// 2. Group targets by physical inode: hashing each inode once is enough
+		groups := make(map[inodeKey]scrubRef)
+		var missing []string
+		for _, ref := range targets {
+			key, err := statInode(ref.Path)
+			if err != nil {
+				missing = append(missing, ref.Path)
+				continue
+			}
+			if _, seen := groups[key]; !seen {
+				groups[key] = ref
+			}
+		}
+
+		keys := make([]inodeKey, 0, len(groups))
+		for k := range groups {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return groups[keys[i]].Path < groups[keys[j]].Path })
+
+		if sample > 0 && sample < len(keys) {
+			rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+			keys = keys[:sample]
+			logger.Info("🎲 Sampling %d of %d unique files.", sample, len(groups))
+		}
+
+		logger.Info("🔬 Scrubbing %d unique files from %d indexed paths...", len(keys), len(targets))
+
+		// 3. Re-hash
+		throttle := newThrottle(rateMB)
+		type corruption struct {
+			ref      scrubRef
+			key      inodeKey
+			got      string
+			affected []string
+		}
+		var corrupted []corruption
+		var scrubbedBytes int64
+
+		for i, key := range keys {
+			ref := groups[key]
+			got, n, err := hashThrottled(ref.Path, throttle)
+			scrubbedBytes += n
+			if err != nil {
+				logger.Error("Failed to read %s: %v", ref.Path, err)
+				got = "unreadable"
+			}
+			if got != ref.Hash {
+				corrupted = append(corrupted, corruption{ref: ref, key: key, got: got, affected: affectedPaths(key, byHash[ref.Hash])})
+			}
+			if (i+1)%1000 == 0 {
+				logger.Info("   ... %d/%d files (%s)", i+1, len(keys), formatSizeForBackup(scrubbedBytes))
+			}
+		}
+
+		// Paths outside the indexes (Deleted upstream/, the object store, unindexed
+		// snapshots) can share a corrupted inode too: find every link by walking the backup
+		if len(corrupted) > 0 {
+			wanted := make(map[inodeKey]bool)
+			for _, c := range corrupted {
+				wanted[c.key] = true
+			}
+			links := inodeLinks(getSharedBackupPath(), wanted)
+			for i := range corrupted {
+				affected := append(corrupted[i].affected, links[corrupted[i].key]...)
+				sort.Strings(affected)
+				corrupted[i].affected = slices.Compact(affected)
+			}
+		}
+
+		// 4. Report
+		for _, p := range missing {
+			logger.Error("MISSING: %s", p)
+		}
+		for _, c := range corrupted {
+			logger.Error("CORRUPTED: %s (expected %s, got %s)", c.ref.Path, c.ref.Hash, c.got)
+			for _, p := range c.affected {
+				logger.Info("   ↳ shared by %s", p)
+			}
+		}
+
+		// 5. Repair
+		repaired := 0
+		for _, c := range corrupted {
+			candidates := append(append([]scrubRef{}, byHash[c.ref.Hash]...), repairSources...)
+			source := findRepairSource(c.ref.Hash, c.key, candidates)
+			if source == "" {
+				logger.Error("No intact copy found for %s", c.ref.Path)
+				continue
+			}
+			if !repair {
+				logger.Info("🩹 Intact copy available for %s: %s (run with --repair)", filepath.Base(c.ref.Path), source)
+				continue
+			}
+			if err := repairCorrupted(source, c.affected, c.ref.ModTime); err != nil {
+				logger.Error("Failed to repair %s: %v", c.ref.Path, err)
+				continue
+			}
+			logger.Info("✅ Repaired %d paths from %s", len(c.affected), source)
+			repaired++
+		}
+
+		logger.Info("🏁 Scrub complete: %d files (%s) checked, %d corrupted, %d missing, %d repaired.",
+			len(keys), formatSizeForBackup(scrubbedBytes), len(corrupted), len(missing), repaired)
+
+		if unrepaired := len(corrupted) - repaired; unrepaired > 0 || len(missing) > 0 {
+			return failed(exitIntegrity, fmt.Errorf("%d corrupted files not repaired, %d missing", unrepaired, len(missing)))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scrubCmd)
+	scrubCmd.Flags().String("snapshot", "", "Only scrub this snapshot (defaults to all)")
+	scrubCmd.Flags().Int("sample", 0, "Only scrub this many randomly chosen files (0 = all)")
+	scrubCmd.Flags().Float64("rate", 0, "Maximum read rate in MB/s (0 = unlimited)")
+	scrubCmd.Flags().Bool("repair", false, "Repair corrupted files from an intact copy with the same hash")
+	scrubCmd.Flags().StringSlice("mirror", nil, "Additional backup roots (mirrors) to use as repair sources")
+}
+
+// getImmichPath returns the configured Immich master directory name (relative to backup_path)
+func getImmichPath() string {
+	immichPath := config.AppConfig.ImmichMasterPath
+	if immichPath == "" {
+		immichPath = viper.GetString("immich_master_path")
+	}
+	if immichPath == "" {
+		immichPath = "immich-master"
+	}
+	return immichPath
+}
+
+// workingDirRefs lists the hashed files still present in working_path/downloads
+func workingDirRefs() []scrubRef {
+	workingPath := getWorkingPath()
+	if workingPath == "" {
+		return nil
+	}
+	downloads := filepath.Join(workingPath, "downloads")
+	entries, err := os.ReadDir(downloads)
+	if err != nil {
+		return nil
+	}
+
+	var refs []scrubRef
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		exportIndex, err := processor.OpenExportIndex(filepath.Join(downloads, e.Name()))
+		if err != nil {
+			continue
+		}
+		exportIndex.ForEach(func(meta processor.FileMetadata) error {
+			if meta.Hash != "" {
+				refs = append(refs, scrubRef{Path: meta.Path, FileIndexEntry: registry.FileIndexEntry{Hash: meta.Hash, Size: meta.Size}})
+			}
+			return nil
+		})
+		exportIndex.Close()
+	}
+	return refs
+}
+
+func statInode(path string) (inodeKey, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return inodeKey{}, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return inodeKey{}, fmt.Errorf("no inode information for %s", path)
+	}
+	return inodeKey{Dev: uint64(stat.Dev), Ino: stat.Ino}, nil
+}
+
+// affectedPaths returns every indexed path that physically is the given inode
+func affectedPaths(key inodeKey, sameHash []scrubRef) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, ref := range sameHash {
+		if seen[ref.Path] {
+			continue
+		}
+		seen[ref.Path] = true
+		if k, err := statInode(ref.Path); err == nil && k == key {
+			paths = append(paths, ref.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// inodeLinks walks root and returns, for each wanted inode, every path linked to it
+func inodeLinks(root string, wanted map[inodeKey]bool) map[inodeKey][]string {
+	links := make(map[inodeKey][]string)
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		if key := (inodeKey{Dev: uint64(stat.Dev), Ino: stat.Ino}); wanted[key] {
+			links[key] = append(links[key], path)
+		}
+		return nil
+	})
+	return links
+}
+
+// findRepairSource returns the first candidate with the expected hash that lives on a
+// different inode than the corrupted file and whose content is verified intact.
+func findRepairSource(hash string, corrupted inodeKey, candidates []scrubRef) string {
+	tried := make(map[inodeKey]bool)
+	tried[corrupted] = true
+	for _, c := range candidates {
+		if c.Hash != hash {
+			continue
+		}
+		key, err := statInode(c.Path)
+		if err != nil || tried[key] {
+			continue
+		}
+		tried[key] = true
+		if got, err := calculateHash(c.Path); err == nil && got == hash {
+			return c.Path
+		}
+	}
+	return ""
+}
+
+// repairCorrupted rewrites the first affected path from source and relinks the
+// remaining ones (every link of the corrupted inode) to it, so the hardlink structure
+// is preserved.
+func repairCorrupted(source string, affected []string, modTime time.Time) error {
+	if len(affected) == 0 {
+		return nil
+	}
+	head := affected[0]
+	tmp := head + ".scrub-tmp"
+	if err := copyFile(source, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if !modTime.IsZero() {
+		os.Chtimes(tmp, modTime, modTime)
+	}
+	if err := os.Rename(tmp, head); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	for _, p := range affected[1:] {
+		tmp := p + ".scrub-tmp"
+		if err := os.Link(head, tmp); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, p); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return nil
+}
+
+// throttle limits the read rate of scrubbing to keep the disk usable
+type throttle struct {
+	bytesPerSec float64
+	start       time.Time
+	read        int64
+}
+
+func newThrottle(mbPerSec float64) *throttle {
+	return &throttle{bytesPerSec: mbPerSec * 1024 * 1024, start: time.Now()}
+}
+
+func (t *throttle) wait(n int) {
+	if t.bytesPerSec <= 0 {
+		return
+	}
+	t.read += int64(n)
+	expected := time.Duration(float64(t.read) / t.bytesPerSec * float64(time.Second))
+	if elapsed := time.Since(t.start); expected > elapsed {
+		time.Sleep(expected - elapsed)
+	}
+}
+
+// hashThrottled computes the SHA-256 of a file honoring the throttle
+func hashThrottled(path string, t *throttle) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	buf := make([]byte, 1024*1024)
+	var total int64
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			hasher.Write(buf[:n])
+			total += int64(n)
+			t.wait(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", total, err
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), total, nil
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"google-photos-backup/internal/browser"
+	"google-photos-backup/internal/config"
+	"google-photos-backup/internal/diskspace"
+)
+
+// spaceReserve returns min_free_space in bytes
+func spaceReserve() int64 {
+	return browser.ParseSize(config.AppConfig.MinFreeSpace)
+}
+
+// spaceGuard returns the free-space guard for writes below path, honouring
+// min_free_space and disk_full_action.
+func spaceGuard(path, what string) *diskspace.Guard {
+	return &diskspace.Guard{
+		Path:    path,
+		Reserve: spaceReserve(),
+		Pause:   config.AppConfig.DiskFullAction == "pause",
+		What:    what,
+	}
+}
@@ -0,0 +1,402 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google-photos-backup/internal/browser"
+	"google-photos-backup/internal/diskspace"
+	"google-photos-backup/internal/integrity"
+	"google-photos-backup/internal/processor"
+	"google-photos-backup/internal/registry"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show where exports, downloads and backups stand",
+	Long: `Reads history.json, the download states, the processing index, backup_log.jsonl and the
+snapshots, and reports: exports by status with their age, download progress per part,
+exports processed but not backed up yet, the latest snapshot, the Immich master size,
+when the next export is due (backup_frequency), the disk usage of working_path and
+backup_path and, if one is running, the state of the daemon (read from its socket).
+Nothing is written. --json prints the same report as JSON.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		report, err := buildStatusReport(getWorkingPath(), getBackupPath())
+		if err != nil {
+			return logFailed(exitFailure, err)
+		}
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(report)
+		}
+		printStatusReport(report)
+		return nil
+	},
+}
+
+// statusReport is the output of 'status' (also its --json form)
+type statusReport struct {
+	GeneratedAt    time.Time         `json:"generated_at"`
+	WorkingPath    string            `json:"working_path,omitempty"`
+	BackupPath     string            `json:"backup_path,omitempty"`
+	ExportCounts   map[string]int    `json:"export_counts"` // registry.ExportStatus -> exports
+	Exports        []exportStatus    `json:"exports"`
+	PendingBackup  []string          `json:"pending_backup"` // Processed, still in downloads
+	SnapshotCount  int               `json:"snapshot_count"`
+	LatestSnapshot *snapshotStatus   `json:"latest_snapshot,omitempty"`
+	Immich         *immichStatus     `json:"immich_master,omitempty"`
+	NextBackup     *nextBackupStatus `json:"next_backup,omitempty"`
+	Disks          []diskStatus      `json:"disks"`
+	Daemon         *daemonStatus     `json:"daemon,omitempty"` // Not running if absent
+}
+
+type exportStatus struct {
+	ID          string                `json:"id"`
+	Status      registry.ExportStatus `json:"status"`
+	RequestedAt time.Time             `json:"requested_at"`
+	CompletedAt time.Time             `json:"completed_at,omitempty"`
+	AgeHours    float64               `json:"age_hours"` // Since requested
+	Processed   bool                  `json:"processed"`
+	Downloaded  bool                  `json:"downloaded"` // Still present in downloads
+	Parts       []partStatus          `json:"parts,omitempty"`
+	Error       string                `json:"error,omitempty"`
+}
+
+type partStatus struct {
+	Part            int     `json:"part"`
+	Filename        string  `json:"filename"`
+	Status          string  `json:"status"`
+	SizeBytes       int64   `json:"size_bytes"`
+	DownloadedBytes int64   `json:"downloaded_bytes"`
+	Percent         float64 `json:"percent"`
+}
+
+type snapshotStatus struct {
+	Name          string `json:"name"`
+	Kind          string `json:"kind"` // takeout or ingest
+	Files         int    `json:"files"`
+	Bytes         int64  `json:"bytes"`
+	Source        string `json:"source,omitempty"`
+	Added         int    `json:"added"`
+	AddedBytes    int64  `json:"added_bytes"`
+	Sealed        bool   `json:"sealed"`
+	DeletionAlert bool   `json:"deletion_alert"`
+	LoggedAt      string `json:"logged_at,omitempty"`
+}
+
+type immichStatus struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+type nextBackupStatus struct {
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	Frequency   string    `json:"frequency"`
+	DueAt       time.Time `json:"due_at"`
+	Due         bool      `json:"due"`
+}
+
+type diskStatus struct {
+	Name string `json:"name"` // working_path or backup_path
+	Path string `json:"path"`
+	Used int64  `json:"used_bytes"` // Hardlinked files counted once
+	Free int64  `json:"free_bytes"`
+}
+
+// buildStatusReport gathers the report; missing files only leave their section empty
+func buildStatusReport(workingPath, backupPath string) (*statusReport, error) {
+	if workingPath == "" && backupPath == "" {
+		return nil, fmt.Errorf("neither working_path nor backup_path is configured")
+	}
+	now := time.Now()
+	report := &statusReport{
+		GeneratedAt:   now,
+		WorkingPath:   workingPath,
+		BackupPath:    backupPath,
+		ExportCounts:  make(map[string]int),
+		Exports:       []exportStatus{},
+		PendingBackup: []string{},
+		Disks:         []diskStatus{},
+	}
+
+	if workingPath != "" {
+		reg, err := registry.New(filepath.Join(workingPath, "history.json"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot load history: %w", err)
+		}
+		outputDir := getOutputDir(workingPath)
+		processed := make(map[string]bool)
+		if state, err := processor.ReadState(outputDir); err == nil && state.ProcessedExports != nil {
+			processed = state.ProcessedExports
+		}
+
+		sort.SliceStable(reg.Exports, func(i, j int) bool {
+			return reg.Exports[i].RequestedAt.Before(reg.Exports[j].RequestedAt)
+		})
+		for _, entry := range reg.Exports {
+			report.ExportCounts[string(entry.Status)]++
+			e := exportStatus{
+				ID:          entry.ID,
+				Status:      entry.Status,
+				RequestedAt: entry.RequestedAt,
+				CompletedAt: entry.CompletedAt,
+				AgeHours:    float64(int(now.Sub(entry.RequestedAt).Hours()*10)) / 10,
+				Processed:   processed[entry.ID] || entry.Extracted(),
+				Error:       entry.Error,
+			}
+			if entry.ID != "" {
+				exportDir := filepath.Join(workingPath, "downloads", entry.ID)
+				if info, err := os.Stat(exportDir); err == nil && info.IsDir() {
+					e.Downloaded = true
+				}
+				if state, err := registry.LoadDownloadState(filepath.Join(exportDir, "state.json")); err == nil {
+					for _, f := range state.Files {
+						e.Parts = append(e.Parts, newPartStatus(f))
+					}
+				}
+				// update-backup removes raw/ (and only raw/) once the export is in a snapshot
+				if _, err := os.Stat(filepath.Join(exportDir, "raw")); err == nil && e.Processed && entry.Status != registry.StatusSnapshotted {
+					report.PendingBackup = append(report.PendingBackup, entry.ID)
+				}
+			}
+			report.Exports = append(report.Exports, e)
+		}
+
+		frequency := viper.GetDuration("backup_frequency")
+		next := &nextBackupStatus{Frequency: frequency.String(), DueAt: now, Due: true}
+		if last := reg.GetLastSuccessful(); last != nil {
+			next.LastSuccess = last.CompletedAt
+		}
+		// An export downloaded but not backed up yet also postpones the next request
+		if last := lastDownloadedExport(reg, workingPath); last != nil {
+			next.DueAt = last.CompletedAt.Add(frequency)
+			next.Due = !now.Before(next.DueAt)
+		}
+		report.NextBackup = next
+
+		report.Disks = append(report.Disks, newDiskStatus("working_path", workingPath))
+
+		if daemon, err := readDaemonStatus(getDaemonSocket(workingPath)); err == nil {
+			report.Daemon = daemon
+		}
+	}
+
+	if backupPath != "" {
+		snapshots, _ := listSnapshots(backupPath)
+		report.SnapshotCount = len(snapshots)
+		if len(snapshots) > 0 {
+			report.LatestSnapshot = latestSnapshotStatus(backupPath, snapshots[len(snapshots)-1])
+		}
+
+		immichRoot := getImmichRoot(backupPath)
+		if info, err := os.Stat(immichRoot); err == nil && info.IsDir() {
+			immich := &immichStatus{Path: immichRoot}
+			filepath.Walk(immichRoot, func(path string, info os.FileInfo, err error) error {
+				if err == nil && info.Mode().IsRegular() && info.Name() != "index.json" {
+					immich.Files++
+					immich.Bytes += info.Size()
+				}
+				return nil
+			})
+			report.Immich = immich
+		}
+
+		report.Disks = append(report.Disks, newDiskStatus("backup_path", backupPath))
+	}
+	return report, nil
+}
+
+func newPartStatus(f registry.DownloadFile) partStatus {
+	p := partStatus{
+		Part:            f.PartNumber,
+		Filename:        f.Filename,
+		Status:          f.Status,
+		SizeBytes:       f.SizeBytes,
+		DownloadedBytes: f.DownloadedBytes,
+	}
+	switch {
+	case f.Status == "completed":
+		p.Percent = 100
+	case f.SizeBytes > 0:
+		p.Percent = float64(int(float64(f.DownloadedBytes)/float64(f.SizeBytes)*1000)) / 10
+	}
+	return p
+}
+
+// latestSnapshotStatus describes a snapshot from its index and its backup_log.jsonl entry
+func latestSnapshotStatus(backupPath, name string) *snapshotStatus {
+	snapPath := filepath.Join(backupPath, name)
+	s := &snapshotStatus{Name: name, Kind: "takeout"}
+	if isIngestSnapshot(name) {
+		s.Kind = "ingest"
+	}
+	if _, err := os.Stat(filepath.Join(snapPath, integrity.ChainFileName)); err == nil {
+		s.Sealed = true
+	}
+	if idx, err := loadSnapshotIndex(snapPath); err == nil {
+		s.Files = idx.Len()
+		idx.ForEach(func(_ string, entry registry.FileIndexEntry) error {
+			s.Bytes += entry.Size
+			return nil
+		})
+		idx.Close()
+	}
+	if entries, err := readBackupLog(backupPath); err == nil {
+		for _, entry := range entries {
+			if filepath.Base(entry.Snapshot) == name {
+				s.Source = entry.Source
+				s.Added = entry.Added
+				s.AddedBytes = entry.Size
+				s.DeletionAlert = entry.Alert
+				s.LoggedAt = entry.Timestamp
+			}
+		}
+	}
+	return s
+}
+
+func newDiskStatus(name, path string) diskStatus {
+	d := diskStatus{Name: name, Path: path, Used: diskspace.Usage(path)}
+	d.Free, _ = diskspace.Free(path)
+	return d
+}
+
+func printStatusReport(r *statusReport) {
+	fmt.Println("📦 Exports")
+	if len(r.Exports) == 0 {
+		fmt.Println("  (none)")
+	}
+	var counts []string
+	for _, status := range sortedCounts(r.ExportCounts) {
+		counts = append(counts, fmt.Sprintf("%s: %d", status, r.ExportCounts[status]))
+	}
+	if len(counts) > 0 {
+		fmt.Printf("  %s\n", strings.Join(counts, ", "))
+	}
+	for _, e := range r.Exports {
+		id := e.ID
+		if id == "" {
+			id = "(pending ID)"
+		}
+		flags := ""
+		if e.Processed {
+			flags += " processed"
+		}
+		if e.Downloaded {
+			flags += " in-downloads"
+		}
+		fmt.Printf("  %-40s %-12s %s old%s\n", id, e.Status, formatAge(e.AgeHours), flags)
+		for _, p := range e.Parts {
+			fmt.Printf("      part %-3d %-11s %5.1f%%  %s / %s\n", p.Part, p.Status, p.Percent,
+				browser.FormatSize(p.DownloadedBytes), browser.FormatSize(p.SizeBytes))
+		}
+		if e.Error != "" {
+			fmt.Printf("      error: %s\n", e.Error)
+		}
+	}
+	if len(r.PendingBackup) > 0 {
+		fmt.Printf("  ⏳ Processed, waiting for update-backup: %s\n", strings.Join(r.PendingBackup, ", "))
+	}
+
+	fmt.Printf("\n📸 Snapshots: %d\n", r.SnapshotCount)
+	if s := r.LatestSnapshot; s != nil {
+		sealed := "not sealed"
+		if s.Sealed {
+			sealed = "sealed"
+		}
+		fmt.Printf("  Latest: %s (%s, %s): %d files, %s\n", s.Name, s.Kind, sealed, s.Files, browser.FormatSize(s.Bytes))
+		if s.LoggedAt != "" {
+			fmt.Printf("  Added %d files (%s) from %s\n", s.Added, browser.FormatSize(s.AddedBytes), s.Source)
+		}
+		if s.DeletionAlert {
+			fmt.Println("  ⚠️  Deletion alert raised for this snapshot")
+		}
+	}
+	if r.Immich != nil {
+		fmt.Printf("  Immich master: %d files, %s (%s)\n", r.Immich.Files, browser.FormatSize(r.Immich.Bytes), r.Immich.Path)
+	}
+
+	if n := r.NextBackup; n != nil {
+		fmt.Println("\n📅 Next export")
+		if !n.LastSuccess.IsZero() {
+			fmt.Printf("  Last successful: %s\n", n.LastSuccess.Format("02/01/2006 15:04"))
+		}
+		if n.Due {
+			fmt.Printf("  Due now (backup_frequency %s)\n", n.Frequency)
+		} else {
+			fmt.Printf("  Due %s (backup_frequency %s)\n", n.DueAt.Format("02/01/2006 15:04"), n.Frequency)
+		}
+	}
+
+	fmt.Println("\n💾 Disk usage")
+	for _, d := range r.Disks {
+		fmt.Printf("  %-13s %s used, %s free (%s)\n", d.Name, browser.FormatSize(d.Used), browser.FormatSize(d.Free), d.Path)
+	}
+
+	if r.WorkingPath != "" {
+		printDaemonStatus(r.Daemon)
+	}
+}
+
+func printDaemonStatus(d *daemonStatus) {
+	if d == nil {
+		fmt.Println("\n🤖 Daemon: not running")
+		return
+	}
+	fmt.Printf("\n🤖 Daemon: %s (pid %d, up since %s, %d cycles)\n", d.State, d.PID, d.StartedAt.Format("02/01/2006 15:04"), d.Cycles)
+	if d.Stage != "" {
+		fmt.Printf("  Stage: %s\n", d.Stage)
+	}
+	if !d.NextRunAt.IsZero() {
+		fmt.Printf("  Next run: %s (poll delay %s)\n", d.NextRunAt.Format("02/01/2006 15:04"), d.PollDelay)
+	}
+	if run := d.LastRun; run != nil {
+		result := "ok"
+		switch {
+		case run.Error != "":
+			result = fmt.Sprintf("%s (exit code %d)", run.Error, run.ExitCode)
+		case run.Waiting:
+			result = "waiting for Takeout"
+		case run.Snapshot != "":
+			result = "new snapshot " + run.Snapshot
+		}
+		fmt.Printf("  Last run: %s, %s\n", run.FinishedAt.Format("02/01/2006 15:04"), result)
+	}
+}
+
+// sortedCounts returns the keys of counts in a stable order
+func sortedCounts(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatAge formats a number of hours as "5h" or "3d"
+func formatAge(hours float64) string {
+	if hours < 48 {
+		return fmt.Sprintf("%.0fh", hours)
+	}
+	return fmt.Sprintf("%.0fd", hours/24)
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().Bool("json", false, "Print the report as JSON")
+}
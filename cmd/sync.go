@@ -1,14 +1,18 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"google-photos-backup/internal/browser"
 	"google-photos-backup/internal/config"
+	"google-photos-backup/internal/diskspace"
 	"google-photos-backup/internal/i18n"
 	"google-photos-backup/internal/registry"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"google-photos-backup/internal/logger"
@@ -181,420 +185,558 @@ func init() {
 }
 
 var syncCmd = &cobra.Command{
-	Use:   "sync",
-	Short: "Request a new Google Photos backup via Takeout",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println(i18n.T("sync_start"))
+	Use:           "sync",
+	Short:         "Request a new Google Photos backup via Takeout",
+	Annotations:   locks(lockWorking),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+		_, err := runSync(syncOptions{Force: force})
+		return err
+	},
+}
 
-		// Asegurarse de que la ruta de backup está configurada
-		if config.AppConfig.WorkingPath == "" {
-			logger.Error(i18n.T("backup_dir_error"))
-			return
-		}
+// syncOptions are the sync flags, also set by the run command
+type syncOptions struct {
+	Force   bool            // Request a new export ignoring backup_frequency
+	Context context.Context // Set by the daemon: cancelling it saves the download state and closes the browser
+}
 
-		// Asegurarse de que el directorio de backup existe
-		if err := os.MkdirAll(config.AppConfig.WorkingPath, 0755); err != nil {
-			logger.Error(i18n.T("backup_mkdir_error"), err)
-			return
-		}
+// errInterrupted is returned when the sync stopped because its context was cancelled
+var errInterrupted = errors.New("interrupted by shutdown")
 
-		userDataDir := filepath.Join(config.AppConfig.WorkingPath, "browser_data")
+func (o syncOptions) interrupted() bool {
+	return o.Context != nil && o.Context.Err() != nil
+}
 
-		// Cargar registro de exportaciones (history.json en la carpeta de backup)
-		regPath := filepath.Join(config.AppConfig.WorkingPath, "history.json")
-		reg, err := registry.New(regPath)
-		if err != nil {
-			fmt.Printf(i18n.T("sync_history_error")+"\n", err)
-		}
+// lastDownloadedExport returns the export backup_frequency counts from: the last one
+// downloaded, whether or not it has reached a snapshot yet
+func lastDownloadedExport(reg *registry.Registry, workingPath string) *registry.ExportEntry {
+	return reg.GetLastDownloaded(func(id string) bool {
+		_, err := os.Stat(filepath.Join(workingPath, "downloads", id))
+		return err == nil
+	})
+}
 
-		// CLEANUP: Remove ghost/stale entries (ID="") from previous failed runs
-		// This prevents "requested" entries from piling up if the export wasn't actually created.
-		validExports := []registry.ExportEntry{}
-		for _, e := range reg.Exports {
-			if e.ID != "" {
-				validExports = append(validExports, e)
+// syncResult tells the next stages what the sync did
+type syncResult struct {
+	Downloaded []string // Exports whose download completed in this run
+	Waiting    bool     // An export is still being prepared by Takeout
+	Requested  bool     // A new export was requested
+}
+
+// runSync checks Takeout, downloads a completed export or requests a new one
+func runSync(opts syncOptions) (result syncResult, err error) {
+	// The browser automation panics on failure (rod Must* calls)
+	defer func() {
+		if r := recover(); r != nil {
+			if opts.interrupted() {
+				// The browser was closed under the pending rod calls
+				logger.Info("⏹️  Sync interrupted")
+				err = failed(exitSync, errInterrupted)
+				return
 			}
+			logger.Error("Browser automation failed: %v", r)
+			err = failed(exitSync, fmt.Errorf("browser automation failed: %v", r))
 		}
-		if len(validExports) < len(reg.Exports) {
-			logger.Info(i18n.T("sync_ghost_removed"), len(reg.Exports)-len(validExports))
-			reg.Exports = validExports
-			reg.Save()
-		}
+	}()
 
-		// Lanzar navegador en modo headless
-		bm := browser.New(userDataDir, false) // Headless false para depurar visualmente
-		defer bm.Close()
+	fmt.Println(i18n.T("sync_start"))
 
-		// 1. Comprobar estado actual
-		statuses, err := bm.CheckExportStatus()
-		if err != nil {
-			logger.Error(i18n.T("status_check_error"), err)
-			return
-		}
+	// Asegurarse de que la ruta de backup está configurada
+	if config.AppConfig.WorkingPath == "" {
+		logger.Error(i18n.T("backup_dir_error"))
+		return result, failed(exitConfig, fmt.Errorf("working_path is not set"))
+	}
 
-		// Actualizar registro local con lo encontrado
-		var inProgressStatus *browser.ExportStatus
-		var completedStatus *browser.ExportStatus
+	// Asegurarse de que el directorio de backup existe
+	if err := os.MkdirAll(config.AppConfig.WorkingPath, 0755); err != nil {
+		logger.Error(i18n.T("backup_mkdir_error"), err)
+		return result, failed(exitConfig, err)
+	}
 
-		for _, st := range statuses {
-			if st.ID == "" {
-				continue
-			}
+	userDataDir := filepath.Join(config.AppConfig.WorkingPath, "browser_data")
 
-			// Buscar si existe en el registro
-			entry := reg.Get(st.ID)
-			if entry == nil {
-				// Si no existe, intentamos fusionar con una solicitud huérfana local
-				if reg.MergeOrphan(st.ID, st.CreatedAt) {
-					logger.Info(i18n.T("merging_orphan"), st.ID)
-					entry = reg.Get(st.ID)
-				} else {
-					// Si no hay huérfanas, creamos una nueva (importación pura)
-					logger.Info(i18n.T("importing_export"), st.ID, st.StatusText)
-					newEntry := registry.ExportEntry{
-						ID:          st.ID,
-						RequestedAt: st.CreatedAt,              // Puede ser zero si no se parseó
-						Status:      registry.StatusInProgress, // Default, se actualizará abajo
-					}
-					reg.Add(newEntry)
-					entry = reg.Get(st.ID)
+	// Cargar registro de exportaciones (history.json en la carpeta de backup)
+	regPath := filepath.Join(config.AppConfig.WorkingPath, "history.json")
+	reg, err := registry.New(regPath)
+	if err != nil {
+		fmt.Printf(i18n.T("sync_history_error")+"\n", err)
+		return result, failed(exitSync, err)
+	}
+
+	// CLEANUP: Remove ghost/stale entries (ID="") from previous failed runs
+	// This prevents "requested" entries from piling up if the export wasn't actually created.
+	validExports := []registry.ExportEntry{}
+	for _, e := range reg.Exports {
+		if e.ID != "" {
+			validExports = append(validExports, e)
+		}
+	}
+	if len(validExports) < len(reg.Exports) {
+		logger.Info(i18n.T("sync_ghost_removed"), len(reg.Exports)-len(validExports))
+		reg.Exports = validExports
+		reg.Save()
+	}
+
+	// Lanzar navegador en modo headless
+	bm := browser.New(userDataDir, false) // Headless false para depurar visualmente
+	defer bm.Close()
+
+	// On shutdown the download progress is saved and the browser closed, which makes the
+	// pending browser calls fail and DownloadFiles return
+	var stateMu sync.Mutex
+	var saveState func()
+	if opts.Context != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-opts.Context.Done():
+				stateMu.Lock()
+				if saveState != nil {
+					saveState()
 				}
+				stateMu.Unlock()
+				bm.Close()
+			case <-stop:
 			}
+		}()
+	}
 
-			// Actualizar estado
-			updated := false
-			if st.InProgress {
-				inProgressStatus = &st
-				if entry.Status != registry.StatusInProgress {
-					entry.Status = registry.StatusInProgress
-					updated = true
-				}
-				// Actualizar fecha si la tenemos y antes no
-				if !st.CreatedAt.IsZero() && entry.RequestedAt.IsZero() {
-					entry.RequestedAt = st.CreatedAt
-					updated = true
-				}
-			} else if st.Completed {
-				// 🚨 CRITICAL: If this export is marked as EXPIRED in our registry,
-				// we must IGNORE it so that we don't try to download it again.
-				// This forces the logic below to RequestTakeout() for a new one.
-				if entry.Status == registry.StatusExpired {
-					logger.Debug(i18n.T("ignoring_expired"), st.ID)
-					continue
+	// 1. Comprobar estado actual
+	statuses, err := bm.CheckExportStatus()
+	if err != nil {
+		logger.Error(i18n.T("status_check_error"), err)
+		return result, failed(exitSync, err)
+	}
+
+	// Actualizar registro local con lo encontrado
+	var inProgressStatus *browser.ExportStatus
+	var completedStatus *browser.ExportStatus
+
+	for _, st := range statuses {
+		if st.ID == "" {
+			continue
+		}
+
+		// Buscar si existe en el registro
+		entry := reg.Get(st.ID)
+		if entry == nil {
+			// Si no existe, intentamos fusionar con una solicitud huérfana local
+			if reg.MergeOrphan(st.ID, st.CreatedAt) {
+				logger.Info(i18n.T("merging_orphan"), st.ID)
+				entry = reg.Get(st.ID)
+			} else {
+				// Si no hay huérfanas, creamos una nueva (importación pura)
+				logger.Info(i18n.T("importing_export"), st.ID, st.StatusText)
+				newEntry := registry.ExportEntry{
+					ID:          st.ID,
+					RequestedAt: st.CreatedAt,              // Puede ser zero si no se parseó
+					Status:      registry.StatusInProgress, // Default, se actualizará abajo
 				}
+				reg.Add(newEntry)
+				entry = reg.Get(st.ID)
+			}
+		}
+
+		// Actualizar estado (solo hacia delante: ver registry/lifecycle.go)
+		updated := false
+		if st.InProgress {
+			inProgressStatus = &st
+			if entry.Status == registry.StatusRequested {
+				entry.Status = registry.StatusInProgress
+				updated = true
+			}
+			// Actualizar fecha si la tenemos y antes no
+			if !st.CreatedAt.IsZero() && entry.RequestedAt.IsZero() {
+				entry.RequestedAt = st.CreatedAt
+				updated = true
+			}
+		} else if st.Completed {
+			if entry.Status == registry.StatusRequested || entry.Status == registry.StatusInProgress {
+				entry.Status = registry.StatusReady // Lista para descargar
+				entry.CompletedAt = time.Now()
+				updated = true
+			} else if entry.CompletedAt.IsZero() {
+				// Si ya estaba lista pero no tenía fecha, le ponemos la actual (mejor que nada)
+				entry.CompletedAt = time.Now()
+				updated = true
+			}
 
+			// 🚨 CRITICAL: Only exports still to be downloaded are candidates. Expired ones
+			// (previous Quota Exceeded) must be IGNORED so that a new one is requested, and
+			// the ones already downloaded must not be downloaded again.
+			switch entry.Status {
+			case registry.StatusReady, registry.StatusDownloading:
 				completedStatus = &st // Guardamos la última completada encontrada
-				if entry.Status != registry.StatusReady && entry.Status != registry.StatusProcessed {
-					entry.Status = registry.StatusReady // Lista para descargar
-					entry.CompletedAt = time.Now()
-					updated = true
-				} else if (entry.Status == registry.StatusReady || entry.Status == registry.StatusProcessed) && entry.CompletedAt.IsZero() {
-					// Si ya estaba marcada como lista pero no tenía fecha, le ponemos la actual (mejor que nada)
-					entry.CompletedAt = time.Now()
-					updated = true
-				}
-			} else if strings.Contains(strings.ToLower(st.StatusText), "cancel") {
-				// Detecta "Canceled", "Cancelled", "Cancelado", etc.
-				if entry.Status != registry.StatusCancelled {
-					entry.Status = registry.StatusCancelled
-					entry.CompletedAt = time.Now()
-					updated = true
-				} else if entry.Status == registry.StatusCancelled && entry.CompletedAt.IsZero() {
-					entry.CompletedAt = time.Now()
-					updated = true
-				}
+			case registry.StatusExpired:
+				logger.Debug(i18n.T("ignoring_expired"), st.ID)
+			default:
+				logger.Debug(i18n.T("ignoring_handled"), st.ID, entry.Status)
 			}
+		} else if strings.Contains(strings.ToLower(st.StatusText), "cancel") {
+			// Detecta "Canceled", "Cancelled", "Cancelado", etc.
+			if entry.Status != registry.StatusCancelled && registry.CanTransition(entry.Status, registry.StatusCancelled) {
+				entry.Status = registry.StatusCancelled
+				entry.CompletedAt = time.Now()
+				updated = true
+			} else if entry.Status == registry.StatusCancelled && entry.CompletedAt.IsZero() {
+				entry.CompletedAt = time.Now()
+				updated = true
+			}
+		}
 
-			if updated {
-				reg.Update(*entry)
+		if updated {
+			if err := reg.Update(*entry); err != nil {
+				logger.Error("%v", err)
 			}
 		}
-		reg.Save()
+	}
+	reg.Save()
+
+	// Lógica de decisión
+	if inProgressStatus != nil {
+		logger.Info(i18n.T("sync_wait"))
+
+		// Comprobar antigüedad
+		// 1. Usar fecha detectada en la web (más fiable)
+		createdAt := inProgressStatus.CreatedAt
+
+		// Si tenemos fecha, comprobamos si es antigua (> 48h)
+		if !createdAt.IsZero() && time.Since(createdAt) > 48*time.Hour {
+			logger.Info(i18n.T("export_too_old"), createdAt)
+			if err := bm.CancelExport(); err != nil {
+				logger.Error(i18n.T("cancel_error"), err)
+				return result, failed(exitSync, err)
+			}
+			// Continuamos para solicitar una nueva
+		} else {
+			result.Waiting = true
+			return result, nil
+		}
+	}
+
+	if completedStatus != nil {
+		logger.Info(i18n.T("ready_to_download"))
+
+		// Crear carpeta de descargas específica para esta exportación
+		// Ej: backup_path/downloads/ID_EXPORTACION
+		downloadDir := filepath.Join(config.AppConfig.WorkingPath, "downloads", completedStatus.ID)
+		if err := os.MkdirAll(downloadDir, 0755); err != nil {
+			logger.Error(i18n.T("download_dir_error"), err)
+			return result, failed(exitSync, err)
+		}
 
-		// Lógica de decisión
-		if inProgressStatus != nil {
-			logger.Info(i18n.T("sync_wait"))
+		// NEW FLOW:
+		logger.Info(i18n.T("starting_manager"))
 
-			// Comprobar antigüedad
-			// 1. Usar fecha detectada en la web (más fiable)
-			createdAt := inProgressStatus.CreatedAt
+		// 1. Obtener lista de ficheros (si no la tenemos ya en registro)
+		entry := reg.Get(completedStatus.ID)
 
-			// Si tenemos fecha, comprobamos si es antigua (> 48h)
-			if !createdAt.IsZero() && time.Since(createdAt) > 48*time.Hour {
-				logger.Info(i18n.T("export_too_old"), createdAt)
-				if err := bm.CancelExport(); err != nil {
-					logger.Error(i18n.T("cancel_error"), err)
-					return
+		// 2. Load the file list from state.json if it exists (legacy lists kept in
+		// history.json are moved there by the history schema migration)
+		statePath := filepath.Join(downloadDir, "state.json")
+		var filesToDownload []registry.DownloadFile
+		state, err := registry.LoadDownloadState(statePath)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Error("%v", err)
+			return result, failed(exitSync, err)
+		}
+		if err == nil {
+			filesToDownload = state.Files
+			logger.Info(i18n.T("recovering_list"), len(filesToDownload))
+
+			// Check if any file is already downloaded (100% size) but not marked
+			for i, f := range filesToDownload {
+				if f.Status != "completed" && f.SizeBytes > 0 {
+					targetFile := filepath.Join(downloadDir, f.Filename)
+					// Check local file
+					if info, err := os.Stat(targetFile); err == nil {
+						if info.Size() >= f.SizeBytes {
+							logger.Info(i18n.T("sync_found_completed"), f.Filename, browser.FormatSize(info.Size()))
+							filesToDownload[i].Status = "completed"
+							filesToDownload[i].DownloadedBytes = info.Size()
+							// If we found it valid, ensure we don't try to download it again
+						}
+					}
 				}
-				// Continuamos para solicitar una nueva
-			} else {
-				return
 			}
 		}
 
-		if completedStatus != nil {
-			logger.Info(i18n.T("ready_to_download"))
+		// 3. If no state, fetch from Browser
+		if len(filesToDownload) == 0 {
+			fmt.Println(i18n.T("obtaining_list"))
+			files, err := bm.GetDownloadList(completedStatus.ID)
+			if err != nil {
+				logger.Error(i18n.T("list_error"), err)
+				return result, failed(exitSync, err)
+			}
+			filesToDownload = files
 
-			// Crear carpeta de descargas específica para esta exportación
-			// Ej: backup_path/downloads/ID_EXPORTACION
-			downloadDir := filepath.Join(config.AppConfig.WorkingPath, "downloads", completedStatus.ID)
-			if err := os.MkdirAll(downloadDir, 0755); err != nil {
-				logger.Error(i18n.T("download_dir_error"), err)
-				return
+			// Save new state
+			state := registry.DownloadState{
+				ID:          entry.ID,
+				Files:       files,
+				LastUpdated: time.Now(),
+			}
+			if err := state.Save(statePath); err != nil {
+				logger.Error(i18n.T("state_save_error"), err)
 			}
 
-			// NEW FLOW:
-			logger.Info(i18n.T("starting_manager"))
-
-			// 1. Obtener lista de ficheros (si no la tenemos ya en registro)
-			entry := reg.Get(completedStatus.ID)
-
-			// Logic to migrate or load state
-			statePath := filepath.Join(downloadDir, "state.json")
-			var filesToDownload []registry.DownloadFile
-
-			// 1. Check if we have legacy files in registry to migrate
-			if len(entry.Files) > 0 {
-				// Heuristic: If files have empty filenames and status failed, they might be from the "17 files" bug.
-				// In that case, we discard them to force a re-scan.
-				if entry.Files[0].Filename == "" {
-					fmt.Println(i18n.T("discarding_bad_state"))
-					// fmt.Println("⚠️  Discarding invalid legacy file list. Will re-scan.")
-					entry.Files = nil
-					reg.Update(*entry)
-					reg.Save()
-				} else {
-					// Valid files, migrate them
-					fmt.Println(i18n.T("migrating_state"))
-					state := registry.DownloadState{
-						ID:          entry.ID,
-						Files:       entry.Files,
-						LastUpdated: time.Now(),
-					}
-					if err := state.Save(statePath); err != nil {
-						fmt.Printf(i18n.T("sync_migrate_fail")+"\n", err)
-					} else {
-						entry.Files = nil // Clear from registry
-						reg.Update(*entry)
-						reg.Save()
-					}
+			fmt.Printf(i18n.T("list_saved")+"\n", len(files))
+		}
+
+		// 4. Start Download with Progress
+		// Check download mode
+		mode := entry.DownloadMode
+		if mode == "" {
+			mode = config.ModeDirectDownload
+		}
+
+		if mode == config.ModeDriveDownload {
+			logger.Info(i18n.T("drive_mode_warning"))
+			return result, nil
+		}
+
+		// Free space: every pending part must fit in the download directory
+		var remaining, largest, completed int64
+		unknownSizes := 0
+		for _, f := range filesToDownload {
+			switch {
+			case f.Status == "completed":
+				completed += f.SizeBytes
+			case f.SizeBytes > 0:
+				remaining += f.SizeBytes
+				if f.SizeBytes > largest {
+					largest = f.SizeBytes
 				}
+			default:
+				unknownSizes++
 			}
-
-			// 2. Load state from file if exists
-			if state, err := registry.LoadDownloadState(statePath); err == nil {
-				filesToDownload = state.Files
-				logger.Info(i18n.T("recovering_list"), len(filesToDownload))
-
-				// Check if any file is already downloaded (100% size) but not marked
-				for i, f := range filesToDownload {
-					if f.Status != "completed" && f.SizeBytes > 0 {
-						targetFile := filepath.Join(downloadDir, f.Filename)
-						// Check local file
-						if info, err := os.Stat(targetFile); err == nil {
-							if info.Size() >= f.SizeBytes {
-								logger.Info(i18n.T("sync_found_completed"), f.Filename, browser.FormatSize(info.Size()))
-								filesToDownload[i].Status = "completed"
-								filesToDownload[i].DownloadedBytes = info.Size()
-								// If we found it valid, ensure we don't try to download it again
-							}
-						}
+		}
+		if total := browser.ParseSize(entry.TotalSize); unknownSizes > 0 && total > completed+remaining {
+			remaining = total - completed
+		}
+		downloadGuard := spaceGuard(downloadDir, "downloads")
+		if err := downloadGuard.Ensure(opts.Context, remaining); err != nil {
+			if opts.interrupted() {
+				return result, failed(exitSync, errInterrupted)
+			}
+			logger.Error("%v", err)
+			return result, failed(exitSync, err)
+		}
+		// Chrome writes each part to ~/Downloads before it is moved here
+		if home, err := os.UserHomeDir(); err == nil {
+			browserDownloads := filepath.Join(home, "Downloads")
+			if !diskspace.SameDevice(browserDownloads, downloadDir) {
+				if err := spaceGuard(browserDownloads, "browser downloads").Ensure(opts.Context, largest); err != nil {
+					if opts.interrupted() {
+						return result, failed(exitSync, errInterrupted)
 					}
+					logger.Error("%v", err)
+					return result, failed(exitSync, err)
 				}
 			}
+		}
+		bm.BeforeDownload = func(f registry.DownloadFile) error {
+			return downloadGuard.Ensure(opts.Context, f.SizeBytes)
+		}
 
-			// 3. If no state, fetch from Browser
-			if len(filesToDownload) == 0 {
-				fmt.Println(i18n.T("obtaining_list"))
-				files, err := bm.GetDownloadList(completedStatus.ID)
-				if err != nil {
-					logger.Error(i18n.T("list_error"), err)
-					return // Next export
-				}
-				filesToDownload = files
+		// The export is downloading from now on; its size comes from the real file list
+		entry.Status = registry.StatusDownloading
+		entry.FileCount = len(filesToDownload)
+		if entry.TotalSize == "" && completed+remaining > 0 {
+			entry.TotalSize = browser.FormatSize(completed + remaining)
+		}
+		if err := reg.Update(*entry); err != nil {
+			logger.Error("%v", err)
+		}
+		reg.Save()
 
-				// Save new state
-				state := registry.DownloadState{
-					ID:          entry.ID,
-					Files:       files,
-					LastUpdated: time.Now(),
-				}
-				if err := state.Save(statePath); err != nil {
-					logger.Error(i18n.T("state_save_error"), err)
-				}
+		// Init Tracker
+		tracker := &ProgressTracker{
+			StartTime:       time.Now(),
+			TotalFiles:      len(filesToDownload),
+			TotalExportSize: browser.ParseSize(entry.TotalSize),
+			Files:           filesToDownload,
+		}
 
-				fmt.Printf(i18n.T("list_saved")+"\n", len(files))
-			}
+		nonInteractive := viper.GetBool("non_interactive")
+		if !nonInteractive {
+			tracker.Render() // Initial render
+		} else {
+			logger.Info(i18n.T("sync_export_set"), len(filesToDownload), entry.TotalSize)
+		}
 
-			// 4. Start Download with Progress
-			// Check download mode
-			mode := entry.DownloadMode
-			if mode == "" {
-				mode = config.ModeDirectDownload
+		stateMu.Lock()
+		saveState = func() {
+			state := registry.DownloadState{
+				ID:          entry.ID,
+				Files:       filesToDownload,
+				LastUpdated: time.Now(),
 			}
+			_ = state.Save(statePath)
+		}
+		stateMu.Unlock()
 
-			if mode == config.ModeDriveDownload {
-				logger.Info(i18n.T("drive_mode_warning"))
-				return
-			}
+		err = bm.DownloadFiles(completedStatus.ID, filesToDownload, downloadDir, func(idx int, updatedFile registry.DownloadFile) {
+			stateMu.Lock()
+			defer stateMu.Unlock()
+
+			// Detect status changes for logging BEFORE updating memory
+			oldStatus := filesToDownload[idx].Status
+			newStatus := updatedFile.Status
 
-			// Init Tracker
-			tracker := &ProgressTracker{
-				StartTime:       time.Now(),
-				TotalFiles:      len(filesToDownload),
-				TotalExportSize: browser.ParseSize(entry.TotalSize),
-				Files:           filesToDownload,
+			if nonInteractive {
+				if oldStatus != "downloading" && newStatus == "downloading" {
+					logger.Info(i18n.T("sync_download_start"), updatedFile.Filename, browser.FormatSize(updatedFile.SizeBytes))
+				}
+				if oldStatus != "completed" && newStatus == "completed" {
+					logger.Info(i18n.T("sync_download_finish"), updatedFile.Filename, browser.FormatSize(updatedFile.SizeBytes))
+				}
 			}
 
-			nonInteractive := viper.GetBool("non_interactive")
+			// Update in memory list
+			filesToDownload[idx] = updatedFile
+			tracker.Files = filesToDownload // Sync files to tracker (ref)
+
+			// Re-render
 			if !nonInteractive {
-				tracker.Render() // Initial render
-			} else {
-				logger.Info(i18n.T("sync_export_set"), len(filesToDownload), entry.TotalSize)
+				tracker.Render()
 			}
 
-			err = bm.DownloadFiles(completedStatus.ID, filesToDownload, downloadDir, func(idx int, updatedFile registry.DownloadFile) {
-				// Detect status changes for logging BEFORE updating memory
-				oldStatus := filesToDownload[idx].Status
-				newStatus := updatedFile.Status
+			// Save state to disk
+			saveState()
+		})
+		fmt.Println() // Newline after loop or progress
 
-				if nonInteractive {
-					if oldStatus != "downloading" && newStatus == "downloading" {
-						logger.Info(i18n.T("sync_download_start"), updatedFile.Filename, browser.FormatSize(updatedFile.SizeBytes))
-					}
-					if oldStatus != "completed" && newStatus == "completed" {
-						logger.Info(i18n.T("sync_download_finish"), updatedFile.Filename, browser.FormatSize(updatedFile.SizeBytes))
-					}
-				}
-
-				// Update in memory list
-				filesToDownload[idx] = updatedFile
-				tracker.Files = filesToDownload // Sync files to tracker (ref)
+		if opts.interrupted() {
+			// The export stays downloading: the next run resumes the missing parts
+			logger.Info("⏹️  Download interrupted, progress saved in %s", statePath)
+			return result, failed(exitSync, errInterrupted)
+		}
 
-				// Re-render
-				if !nonInteractive {
-					tracker.Render()
-				}
+		if err != nil {
+			if err == browser.ErrQuotaExceeded {
+				fmt.Println(i18n.T("sync_quota_exceeded"))
+				fmt.Println(i18n.T("sync_quota_action"))
 
-				// Save state to disk
-				state := registry.DownloadState{
-					ID:          entry.ID,
-					Files:       filesToDownload,
-					LastUpdated: time.Now(),
+				// CLEANUP: Wipe the directory to save space and remove bad state
+				if err := os.RemoveAll(downloadDir); err != nil {
+					fmt.Printf(i18n.T("sync_cleanup_error")+"\n", err)
+				} else {
+					fmt.Println(i18n.T("sync_cleanup_success"))
 				}
-				_ = state.Save(statePath)
-			})
-			fmt.Println() // Newline after loop or progress
 
-			if err != nil {
-				if err == browser.ErrQuotaExceeded {
-					fmt.Println(i18n.T("sync_quota_exceeded"))
-					fmt.Println(i18n.T("sync_quota_action"))
-
-					// CLEANUP: Wipe the directory to save space and remove bad state
-					if err := os.RemoveAll(downloadDir); err != nil {
-						fmt.Printf(i18n.T("sync_cleanup_error")+"\n", err)
-					} else {
-						fmt.Println(i18n.T("sync_cleanup_success"))
-					}
-
-					entry.Status = registry.StatusExpired
-					entry.Files = nil
-					reg.Update(*entry)
-					reg.Save()
-					return // Loop will continue? Or return to main? Current loop is over exports.
-					// We return to allow next run to request new.
+				entry.Status = registry.StatusExpired
+				if err := reg.Update(*entry); err != nil {
+					logger.Error("%v", err)
 				}
-				// We need a "Downloaded" status.
-				// For now, let's leave as Downloading but maybe set a flag or logic?
-				// Or assume Processed means Downloaded? No, Processed means Metadata fixed.
-
-				// Let's just say "Descarga finalizada"
-				logger.Error(i18n.T("download_finished_error"), err)
-				// Don't mark as downloaded if failed
-			} else {
-				logger.Info(i18n.T("download_completed"), downloadDir)
+				reg.Save()
+				// The next run requests a new export
+				return result, failed(exitSync, err)
 			}
-			reg.Update(*entry)
-			reg.Save()
+			// The export stays downloading: the next run resumes the missing parts
+			logger.Error(i18n.T("download_finished_error"), err)
+			entry.Error = err.Error()
+		} else {
+			logger.Info(i18n.T("download_completed"), downloadDir)
+			result.Downloaded = append(result.Downloaded, entry.ID)
+			entry.Status = registry.StatusDownloaded
+			entry.Error = ""
+		}
+		if err := reg.Update(*entry); err != nil {
+			logger.Error("%v", err)
+		}
+		reg.Save()
 
-			return
+		if err != nil {
+			return result, failed(exitSync, err)
 		}
+		return result, nil
+	}
 
-		// 2. Si no hay nada en curso, comprobar frecuencia antes de solicitar nueva
-		lastSuccess := reg.GetLastSuccessful()
-		frequency := viper.GetDuration("backup_frequency")
-		force, _ := cmd.Flags().GetBool("force")
+	// 2. Si no hay nada en curso, comprobar frecuencia antes de solicitar nueva
+	lastSuccess := lastDownloadedExport(reg, config.AppConfig.WorkingPath)
+	frequency := viper.GetDuration("backup_frequency")
 
-		// Si hay una copia exitosa reciente, no hacemos nada
-		if !force && lastSuccess != nil && time.Since(lastSuccess.CompletedAt) < frequency {
-			nextBackup := lastSuccess.CompletedAt.Add(frequency)
+	// Si hay una copia exitosa reciente, no hacemos nada
+	if !opts.Force && lastSuccess != nil && time.Since(lastSuccess.CompletedAt) < frequency {
+		nextBackup := lastSuccess.CompletedAt.Add(frequency)
+		if lastSuccess.Status == registry.StatusSnapshotted {
 			logger.Info(i18n.T("last_success"), lastSuccess.CompletedAt.Format("02/01/2006 15:04"))
 			logger.Info(i18n.T("last_stats"),
 				lastSuccess.FileCount, lastSuccess.TotalSize, lastSuccess.NewPhotosCount)
-
-			logger.Info(i18n.T("next_backup"), frequency, nextBackup.Format("02/01/2006 15:04"))
-			logger.Info(i18n.T("use_force"))
-			return
+		} else {
+			// A new export would not fix the stage that keeps failing
+			logger.Info(i18n.T("last_not_backed_up"), lastSuccess.ID, lastSuccess.Status)
 		}
 
-		// Check config mode for new export
-		mode := config.AppConfig.DownloadMode
-		if mode == "" {
-			mode = config.ModeDirectDownload
-		}
+		logger.Info(i18n.T("next_backup"), frequency, nextBackup.Format("02/01/2006 15:04"))
+		logger.Info(i18n.T("use_force"))
+		return result, nil
+	}
 
-		if mode == config.ModeDriveDownload {
-			logger.Info(i18n.T("drive_mode_new"))
-			return
-		}
+	// Check config mode for new export
+	mode := config.AppConfig.DownloadMode
+	if mode == "" {
+		mode = config.ModeDirectDownload
+	}
 
-		if err := bm.RequestTakeout(mode); err != nil {
-			logger.Error(i18n.T("takeout_req_error"), err)
-			return
-		}
+	if mode == config.ModeDriveDownload {
+		logger.Info(i18n.T("drive_mode_new"))
+		return result, nil
+	}
 
-		// Double-check status to get the new ID immediately
-		// This ensures we don't save a ghost entry.
-		time.Sleep(5 * time.Second) // Give it a moment
-		newStatuses, err := bm.CheckExportStatus()
-		newID := ""
-		if err == nil {
-			for _, st := range newStatuses {
-				// If we find one that is InProgress (or Created recently), use it
-				if st.InProgress {
-					newID = st.ID
-					break
-				}
+	if err := bm.RequestTakeout(mode); err != nil {
+		logger.Error(i18n.T("takeout_req_error"), err)
+		return result, failed(exitSync, err)
+	}
+
+	// Double-check status to get the new ID immediately
+	// This ensures we don't save a ghost entry.
+	time.Sleep(5 * time.Second) // Give it a moment
+	newStatuses, err := bm.CheckExportStatus()
+	newID := ""
+	if err == nil {
+		for _, st := range newStatuses {
+			// If we find one that is InProgress (or Created recently), use it
+			if st.InProgress {
+				newID = st.ID
+				break
 			}
 		}
+	}
 
-		if newID != "" {
-			logger.Info(i18n.T("sync_new_export"), newID)
-			reg.Add(registry.ExportEntry{
-				ID:          newID,
-				RequestedAt: time.Now(),
-				Status:      registry.StatusInProgress,
-			})
-		} else {
-			// Fallback if we can't find the ID yet (maybe slow backend)
-			// We save it as "requested" but without ID.
-			// Ideally we shouldn't do this if we want to avoid ghosts,
-			// but we need to record that we tried.
-			// With the cleanup logic at start, this is safe-ish.
-			logger.Info(i18n.T("sync_pending_export"))
-			reg.Add(registry.ExportEntry{
-				RequestedAt: time.Now(),
-				Status:      registry.StatusRequested,
-			})
-		}
-
-		if err := reg.Save(); err != nil {
-			logger.Error(i18n.T("history_save_error"), err)
-		} else {
-			logger.Info(i18n.T("history_updated"), regPath)
-		}
+	if newID != "" {
+		logger.Info(i18n.T("sync_new_export"), newID)
+		reg.Add(registry.ExportEntry{
+			ID:          newID,
+			RequestedAt: time.Now(),
+			Status:      registry.StatusInProgress,
+		})
+	} else {
+		// Fallback if we can't find the ID yet (maybe slow backend)
+		// We save it as "requested" but without ID.
+		// Ideally we shouldn't do this if we want to avoid ghosts,
+		// but we need to record that we tried.
+		// With the cleanup logic at start, this is safe-ish.
+		logger.Info(i18n.T("sync_pending_export"))
+		reg.Add(registry.ExportEntry{
+			RequestedAt: time.Now(),
+			Status:      registry.StatusRequested,
+		})
+	}
 
-		fmt.Println(i18n.T("sync_success"))
-	},
+	result.Requested = true
+	if err := reg.Save(); err != nil {
+		logger.Error(i18n.T("history_save_error"), err)
+		return result, failed(exitSync, err)
+	}
+	logger.Info(i18n.T("history_updated"), regPath)
+
+	fmt.Println(i18n.T("sync_success"))
+	return result, nil
 }
@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -11,499 +14,718 @@ import (
 	"time"
 
 	"google-photos-backup/internal/config"
+	"google-photos-backup/internal/diskspace"
+	"google-photos-backup/internal/fsutil"
 	"google-photos-backup/internal/i18n"
 	"google-photos-backup/internal/logger"
 	"google-photos-backup/internal/processor"
 	"google-photos-backup/internal/registry"
+	"google-photos-backup/internal/schema"
+	"google-photos-backup/internal/store"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-type BackupLogEntry struct {
-	Timestamp string   `json:"timestamp"`
-	Source    string   `json:"source"`
-	Snapshot  string   `json:"snapshot_path"`
-	Added     int      `json:"added_count"`
-	Linked    int      `json:"linked_count"`
-	Internal  int      `json:"internal_links"`
-	Size      int64    `json:"total_new_bytes"`
-	Files     []string `json:"added_files"`
+// backupStats accumulates the counters of one update-backup run
+type backupStats struct {
+	Added    int
+	Linked   int
+	Copied   int // Known content the linker had to copy (link limit, other filesystem)
+	Internal int
+	Bytes    int64
+	Files    []string
 }
 
-var updateBackupCmd = &cobra.Command{
-	Use:   "update-backup",
-	Short: "Create an incremental snapshot in final backup location",
-	Long:  `Scans the downloads directory for processed exports (folders with 'raw' subdirectory), backs them up to a timestamped snapshot using hardlinks for deduplication, and deletes the source exports upon success.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		logger.Info(i18n.T("update_backup_start"))
-
-		backupPath := config.AppConfig.BackupPath
-		if backupPath == "" {
-			backupPath = viper.GetString("backup_path")
-		}
-		backupPath = expandPath(backupPath)
-
-		if backupPath == "" {
-			logger.Error(i18n.T("update_backup_no_config"))
-			return
-		}
-
-		// Determine Source Root (downloads folder)
-		// Default: working_path/downloads
-		rootSource, _ := cmd.Flags().GetString("source")
-		if rootSource == "" {
-			workingPath := config.AppConfig.WorkingPath
-			if workingPath == "" {
-				workingPath = viper.GetString("working_path")
-			}
-			workingPath = expandPath(workingPath)
+// linked counts a file made from content already in the backup by the strategy the
+// linker actually used: a fallback copy takes new space, so its bytes are counted
+func (s *backupStats) linked(used fsutil.Strategy, size int64) {
+	if used == fsutil.StrategyCopy {
+		s.Copied++
+		s.Bytes += size
+		return
+	}
+	s.Linked++
+}
 
-			if workingPath != "" {
-				rootSource = filepath.Join(workingPath, "downloads")
-			} else {
-				rootSource = "downloads"
-			}
-		}
-		rootSource = expandPath(rootSource)
+type BackupLogEntry struct {
+	Timestamp     string   `json:"timestamp"`
+	Source        string   `json:"source"`
+	Snapshot      string   `json:"snapshot_path"`
+	Added         int      `json:"added_count"`
+	Linked        int      `json:"linked_count"`
+	Copied        int      `json:"copied_count,omitempty"` // Known content copied because linking failed
+	Internal      int      `json:"internal_links"`
+	Size          int64    `json:"total_new_bytes"`
+	Files         []string `json:"added_files"`
+	Disappeared   []string `json:"disappeared_upstream,omitempty"` // Files in previous snapshot missing from this export
+	Alert         bool     `json:"deletion_alert,omitempty"`       // Disappearance ratio exceeded deletion_alert_ratio
+	SchemaVersion int      `json:"schema_version,omitempty"`       // Per line, see internal/schema
+}
 
-		dryRun, _ := cmd.Flags().GetBool("dry-run")
+var updateBackupCmd = &cobra.Command{
+	Use:           "update-backup",
+	Short:         "Create an incremental snapshot in final backup location",
+	Long:          `Scans the downloads directory for processed exports (folders with 'raw' subdirectory), backs them up to a timestamped snapshot using hardlinks for deduplication, and deletes the source exports upon success.`,
+	Annotations:   locks(lockWorking, lockBackup),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := updateBackupOptions{}
+		opts.Source, _ = cmd.Flags().GetString("source")
+		opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+		opts.DeletionAlertRatio, _ = cmd.Flags().GetFloat64("deletion-alert-ratio")
+
+		_, err := runUpdateBackup(opts)
+		return err
+	},
+}
 
-		logger.Info(i18n.T("update_backup_source"), rootSource)
+// updateBackupOptions are the update-backup flags, also set by the run command
+type updateBackupOptions struct {
+	Source             string          // Defaults to working_path/downloads
+	DryRun             bool            // Simulate the update
+	DeletionAlertRatio float64         // Overrides deletion_alert_ratio when > 0
+	SkipImmich         bool            // The caller updates the Immich master itself
+	Processed          []string        // Exports the caller just processed, complete even without processing_index.json
+	Context            context.Context // Set by the daemon: cancelling it ends a free-space pause
+}
 
-		if _, err := os.Stat(rootSource); os.IsNotExist(err) {
-			logger.Error(i18n.T("update_backup_source_missing"), rootSource)
-			return
-		}
+// backupResult tells the run command what update-backup created
+type backupResult struct {
+	Snapshot string   // Snapshot directory, "" if none was created
+	Exports  []string // Exports backed up into it
+	Alert    bool     // deletion_alert_ratio was exceeded
+}
 
-		// Create Snapshot Directory
-		timestamp := time.Now().Format("2006-01-02-150405")
-		snapshotDir := filepath.Join(backupPath, timestamp)
-		logger.Info(i18n.T("update_backup_dest"), snapshotDir)
+// runUpdateBackup moves every processed export into a new snapshot
+func runUpdateBackup(opts updateBackupOptions) (backupResult, error) {
+	var result backupResult
+	logger.Info(i18n.T("update_backup_start"))
 
-		if dryRun {
-			logger.Info(i18n.T("update_backup_dry_run"))
-		} else {
-			if err := os.MkdirAll(snapshotDir, 0755); err != nil {
-				logger.Error(i18n.T("update_backup_mkdir_fail"), err)
-				return
-			}
-		}
+	backupPath := config.AppConfig.BackupPath
+	if backupPath == "" {
+		backupPath = viper.GetString("backup_path")
+	}
+	backupPath = expandPath(backupPath)
 
-		// Find Previous Backup
-		prevBackup := findLatestBackup(backupPath)
-		if prevBackup != "" {
-			logger.Info(i18n.T("update_backup_linking"), filepath.Base(prevBackup))
-		}
+	if backupPath == "" {
+		logger.Error(i18n.T("update_backup_no_config"))
+		return result, failed(exitConfig, fmt.Errorf("backup_path is not set"))
+	}
 
-		// Load History for Ordering
-		// Typically in working_path/history.json
+	// Determine Source Root (downloads folder)
+	// Default: working_path/downloads
+	rootSource := opts.Source
+	if rootSource == "" {
 		workingPath := config.AppConfig.WorkingPath
 		if workingPath == "" {
 			workingPath = viper.GetString("working_path")
 		}
 		workingPath = expandPath(workingPath)
 
-		historyPath := filepath.Join(workingPath, "history.json")
-		reg, err := registry.New(historyPath)
-		var validExports []registry.ExportEntry
-		if err == nil {
-			// Sort chronological by creation date (RequestedAt)
-			sort.Slice(reg.Exports, func(i, j int) bool {
-				return reg.Exports[i].RequestedAt.Before(reg.Exports[j].RequestedAt)
-			})
-			validExports = reg.Exports
-			logger.Info(i18n.T("update_backup_history_loaded"), len(validExports))
+		if workingPath != "" {
+			rootSource = filepath.Join(workingPath, "downloads")
 		} else {
-			logger.Info(i18n.T("update_backup_history_fail"), err)
+			rootSource = "downloads"
 		}
+	}
+	rootSource = expandPath(rootSource)
 
-		// Load Processing Index for Validation
-		processingIndex := make(map[string]bool)
-		processedArchives := make(map[string]bool)
+	dryRun := opts.DryRun
 
-		// Attempt to locate processing_index.json
-		candidates := []string{
-			filepath.Join(rootSource, "processing_index.json"),
-			filepath.Join(workingPath, "output", "processing_index.json"),
-		}
-		if outDir := viper.GetString("output_dir"); outDir != "" {
-			candidates = append(candidates, filepath.Join(expandPath(outDir), "processing_index.json"))
+	logger.Info(i18n.T("update_backup_source"), rootSource)
+
+	if _, err := os.Stat(rootSource); os.IsNotExist(err) {
+		logger.Error(i18n.T("update_backup_source_missing"), rootSource)
+		return result, failed(exitConfig, fmt.Errorf("source %s does not exist", rootSource))
+	}
+
+	// Find Previous Backup (before creating the new one, otherwise it would find itself)
+	prevBackup := findLatestBackup(backupPath)
+	if prevBackup != "" {
+		logger.Info(i18n.T("update_backup_linking"), filepath.Base(prevBackup))
+	}
+
+	// Create Snapshot Directory
+	timestamp := time.Now().Format("2006-01-02-150405")
+	snapshotDir := filepath.Join(backupPath, timestamp)
+	logger.Info(i18n.T("update_backup_dest"), snapshotDir)
+
+	if dryRun {
+		logger.Info(i18n.T("update_backup_dry_run"))
+	} else {
+		if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+			logger.Error(i18n.T("update_backup_mkdir_fail"), err)
+			return result, failed(exitBackup, err)
 		}
-		if outDir := viper.GetString("output"); outDir != "" {
-			candidates = append(candidates, filepath.Join(expandPath(outDir), "processing_index.json"))
+	}
+
+	// Load History for Ordering
+	// Typically in working_path/history.json
+	workingPath := config.AppConfig.WorkingPath
+	if workingPath == "" {
+		workingPath = viper.GetString("working_path")
+	}
+	workingPath = expandPath(workingPath)
+
+	historyPath := filepath.Join(workingPath, "history.json")
+	reg, err := registry.New(historyPath)
+	var validExports []registry.ExportEntry
+	if err == nil {
+		// Sort chronological by creation date (RequestedAt)
+		sort.Slice(reg.Exports, func(i, j int) bool {
+			return reg.Exports[i].RequestedAt.Before(reg.Exports[j].RequestedAt)
+		})
+		validExports = reg.Exports
+		logger.Info(i18n.T("update_backup_history_loaded"), len(validExports))
+	} else {
+		logger.Info(i18n.T("update_backup_history_fail"), err)
+		if !dryRun {
+			os.Remove(snapshotDir)
 		}
-		candidates = append(candidates, "output/processing_index.json")
+		return result, failed(exitBackup, err)
+	}
 
-		var indexPath string
-		for _, cand := range candidates {
-			if _, err := os.Stat(cand); err == nil {
-				indexPath = cand
-				break
-			}
+	// Load Processing Index for Validation
+	processingIndex := make(map[string]bool)
+	processedArchives := make(map[string]bool)
+
+	// Attempt to locate processing_index.json
+	candidates := []string{
+		filepath.Join(rootSource, "processing_index.json"),
+		filepath.Join(workingPath, "output", "processing_index.json"),
+	}
+	if outDir := viper.GetString("output_dir"); outDir != "" {
+		candidates = append(candidates, filepath.Join(expandPath(outDir), "processing_index.json"))
+	}
+	if outDir := viper.GetString("output"); outDir != "" {
+		candidates = append(candidates, filepath.Join(expandPath(outDir), "processing_index.json"))
+	}
+	candidates = append(candidates, "output/processing_index.json")
+
+	var indexPath string
+	for _, cand := range candidates {
+		if _, err := os.Stat(cand); err == nil {
+			indexPath = cand
+			break
 		}
+	}
 
-		if indexPath != "" {
-			if data, err := os.ReadFile(indexPath); err == nil {
-				var state struct {
-					ProcessedExports  map[string]bool `json:"processed_exports"`
-					ProcessedArchives map[string]bool `json:"processed_archives"`
-				}
-				if err := json.Unmarshal(data, &state); err == nil {
-					processingIndex = state.ProcessedExports
-					processedArchives = state.ProcessedArchives
-					logger.Info(i18n.T("update_backup_index_loaded"), indexPath, len(processingIndex), len(processedArchives))
-				}
+	if indexPath != "" {
+		state, err := processor.ReadState(filepath.Dir(indexPath))
+		if err != nil {
+			logger.Error("%v", err)
+			if !dryRun {
+				os.Remove(snapshotDir)
 			}
-		} else {
-			logger.Info(i18n.T("update_backup_index_missing"), rootSource)
+			return result, failed(exitBackup, err)
 		}
+		processingIndex = state.ProcessedExports
+		processedArchives = state.ProcessedArchives
+		logger.Info(i18n.T("update_backup_index_loaded"), indexPath, len(processingIndex), len(processedArchives))
+	} else {
+		logger.Info(i18n.T("update_backup_index_missing"), rootSource)
+	}
+	if len(opts.Processed) > 0 && processingIndex == nil {
+		processingIndex = make(map[string]bool)
+	}
+	for _, id := range opts.Processed {
+		processingIndex[id] = true
+	}
 
-		totalStats := struct {
-			Added    int
-			Linked   int
-			Internal int
-			Bytes    int64
-			Files    []string
-		}{}
+	totalStats := backupStats{}
 
-		inodeMap := make(map[uint64]string)
-		processedExportsCount := 0
+	inodeMap := make(map[uint64]string)
+	processedExportsCount := 0
+	incrementalExports := 0 // api-sync exports: only new items
 
-		// Helper to process an ID
-		processID := func(exportID string) {
-			exportPath := filepath.Join(rootSource, exportID)
+	backend, viewMode := getStorageBackend()
+	objectStore := store.Open(getSharedBackupPath())
+	if backend == store.BackendObjects {
+		logger.Info("🗄️  Storage backend: object store (%s, %s views)", objectStore.Root, viewMode)
+	}
 
-			// Check if exists
-			if _, err := os.Stat(exportPath); os.IsNotExist(err) {
-				return
-			}
+	// Content already backed up by other accounts (hardlink backend; the store is shared)
+	var sharedHashes map[string]string
+	if backend != store.BackendObjects {
+		sharedHashes = otherAccountHashes()
+	}
+	if config.ActiveAccount != "" {
+		logger.Info("👤 Account: %s (%d files shared with other accounts)", config.ActiveAccount, len(sharedHashes))
+	}
 
-			// Check if it's a valid export to process
-			// Heuristic: Must have "raw" folder AND be marked as complete in processing_index.json
-			rawPath := filepath.Join(exportPath, "raw")
-			if _, err := os.Stat(rawPath); os.IsNotExist(err) {
-				return
+	// Content the previous snapshot already has is linked, not copied (free-space estimate)
+	prevHashes := make(map[string]bool)
+	if prevBackup != "" {
+		if idx, err := loadSnapshotIndex(prevBackup); err == nil {
+			hashes, _ := uniqueHashes(idx)
+			for _, hash := range hashes {
+				prevHashes[hash] = true
 			}
+			idx.Close()
+		}
+	}
+	var outOfSpace error
+	var failedExports []string
+	newMedia := make(map[string]int)       // Export ID -> media files added (NewPhotosCount)
+	exportErrors := make(map[string]error) // Export ID -> backup error
+
+	// Helper to process an ID
+	processID := func(exportID string) {
+		if outOfSpace != nil {
+			return
+		}
+		exportPath := filepath.Join(rootSource, exportID)
 
-			// Validate Completeness
-			// 1. Check if marked completely processed
-			isComplete := processingIndex[exportID]
-			// 2. Fallback: Check if all archives are processed
-			if !isComplete {
-				// Load local state
-				statePath := filepath.Join(exportPath, "state.json")
-				state, err := registry.LoadDownloadState(statePath)
-				if err == nil {
-					allArchivesDone := true
-					for _, f := range state.Files {
-						key := exportID + "/" + f.Filename
-						// Check if ZIP/TGZ
-						ext := strings.ToLower(filepath.Ext(f.Filename))
-						if ext != ".zip" && ext != ".tgz" && !strings.HasSuffix(strings.ToLower(f.Filename), ".tar.gz") {
-							continue
-						}
+		// Check if exists
+		if _, err := os.Stat(exportPath); os.IsNotExist(err) {
+			return
+		}
 
-						if !processedArchives[key] {
-							// DEBUG: Inspect why it failed
-							if exportID == "bfd62949-e1de-4e34-b2ca-c13cc1c7b12f" {
-								logger.Info("DEBUG: Validate Key Failed: '%s'", key)
-								// logger.Info("DEBUG: Known Keys (sample): %v", processedArchives)
-							}
-							allArchivesDone = false
-							break
-						}
+		// Check if it's a valid export to process
+		// Heuristic: Must have "raw" folder AND be marked as complete in processing_index.json
+		rawPath := filepath.Join(exportPath, "raw")
+		if _, err := os.Stat(rawPath); os.IsNotExist(err) {
+			return
+		}
+
+		// Validate Completeness
+		// 1. Check if marked completely processed
+		isComplete := processingIndex[exportID]
+		// 2. Fallback: Check if all archives are processed
+		if !isComplete {
+			// Load local state
+			statePath := filepath.Join(exportPath, "state.json")
+			state, err := registry.LoadDownloadState(statePath)
+			if err == nil {
+				allArchivesDone := true
+				for _, f := range state.Files {
+					key := exportID + "/" + f.Filename
+					// Check if ZIP/TGZ
+					ext := strings.ToLower(filepath.Ext(f.Filename))
+					if ext != ".zip" && ext != ".tgz" && !strings.HasSuffix(strings.ToLower(f.Filename), ".tar.gz") {
+						continue
 					}
-					if allArchivesDone && len(state.Files) > 0 {
-						isComplete = true
-						logger.Info(i18n.T("update_backup_implicit_complete"), exportID)
 
-						// Update Global State in memory and on disk
-						if processingIndex == nil {
-							processingIndex = make(map[string]bool)
-						}
-						processingIndex[exportID] = true
-
-						// We need to write back the full state
-						// We don't have the full state object easily here unless we refactor loading.
-
-						// Refactoring Load:
-						// Already loaded into 'processingIndex' and 'processedArchives'.
-						// But to save, we need the *full* structure (including FileIndex if any).
-						// Solution: Re-read as map[string]interface{} or similar?
-						// Better: Just update the specific field in the file.
-						// Or: Load into processor.State struct if available.
-
-						// Let's assume we re-read and write.
-						// Since we are in a loop, maybe we should batch updates?
-						// For now, let's do it immediately for safety.
-
-						content, err := os.ReadFile(filepath.Join(rootSource, "processing_index.json"))
-						if err == nil {
-							var fullState processor.State
-							if err := json.Unmarshal(content, &fullState); err == nil {
-								if fullState.ProcessedExports == nil {
-									fullState.ProcessedExports = make(map[string]bool)
-								}
-								fullState.ProcessedExports[exportID] = true
-
-								// Write back
-								newContent, _ := json.MarshalIndent(fullState, "", "  ")
-								_ = os.WriteFile(filepath.Join(rootSource, "processing_index.json"), newContent, 0644)
-								logger.Info(i18n.T("update_backup_index_updated"))
-							}
-						}
+					if !processedArchives[key] {
+						allArchivesDone = false
+						break
 					}
 				}
-			}
+				if allArchivesDone && len(state.Files) > 0 {
+					isComplete = true
+					logger.Info(i18n.T("update_backup_implicit_complete"), exportID)
 
-			if !isComplete {
-				logger.Info(i18n.T("update_backup_skip_incomplete"), exportID)
-				return
-			}
-
-			logger.Info(i18n.T("update_backup_processing"), exportID)
+					// Update Global State in memory and on disk
+					if processingIndex == nil {
+						processingIndex = make(map[string]bool)
+					}
+					processingIndex[exportID] = true
 
-			// Load File Index from process step
-			var exportFileIndex map[string]processor.FileMetadata
-			exportIndexPath := filepath.Join(exportPath, "processing_index.json")
-			if data, err := os.ReadFile(exportIndexPath); err == nil {
-				var state processor.State
-				if err := json.Unmarshal(data, &state); err == nil {
-					exportFileIndex = state.FileIndex
+					// Write it back so the next run does not have to infer it again
+					if err := processor.MarkExportProcessed(rootSource, exportID); err != nil {
+						if !os.IsNotExist(err) {
+							logger.Error("%v", err)
+						}
+					} else {
+						logger.Info(i18n.T("update_backup_index_updated"))
+					}
 				}
 			}
+		}
 
-			// Run Backup Logic for this Export
-			startBytes := totalStats.Bytes
+		if !isComplete {
+			logger.Info(i18n.T("update_backup_skip_incomplete"), exportID)
+			return
+		}
 
-			err := backupExport(exportPath, snapshotDir, prevBackup, inodeMap, exportFileIndex, &totalStats, dryRun)
-			if err != nil {
-				logger.Error(i18n.T("update_backup_fail_export"), exportID, err)
-			} else {
-				// Success! Delete Source Export Content (Only 'raw')
-				if !dryRun {
-					logger.Info(i18n.T("update_backup_delete_content"), exportID)
-					// We only delete the 'raw' folder to save space, but keep state.json/metadata
-					if err := os.RemoveAll(rawPath); err != nil {
-						logger.Error(i18n.T("update_backup_delete_fail"), rawPath, err)
-					}
+		logger.Info(i18n.T("update_backup_processing"), exportID)
+
+		// Load File Index from process step
+		exportFileIndex, err := processor.OpenExportIndex(exportPath)
+		if err != nil {
+			logger.Error(i18n.T("update_backup_fail_export"), exportID, err)
+			failedExports = append(failedExports, exportID)
+			return
+		}
+		defer exportFileIndex.Close()
+
+		// Free space: only needed when files are copied to another disk instead of moved
+		target := snapshotDir
+		if backend == store.BackendObjects {
+			target = objectStore.Root
+		}
+		if !dryRun && !diskspace.SameDevice(rawPath, target) {
+			need := backupSpaceNeeded(rawPath, exportFileIndex, prevHashes, backend, objectStore)
+			if err := spaceGuard(target, "backup of "+exportID).Ensure(opts.Context, need); err != nil {
+				if opts.Context != nil && opts.Context.Err() != nil {
+					err = errInterrupted
 				} else {
-					logger.Info(i18n.T("update_backup_dry_delete"), rawPath)
+					logger.Error("%v", err)
 				}
-				processedExportsCount++
-			}
-
-			if totalStats.Bytes > startBytes {
-				// Logic to show incremental progress if needed
+				logger.Info("Export %s and the following ones are left in %s.", exportID, rootSource)
+				outOfSpace = err
+				return
 			}
 		}
 
-		// 1. Process From History (Ordered)
-		processedIDs := make(map[string]bool)
-		for _, entry := range validExports {
-			if entry.ID == "" {
-				continue
-			}
-			processID(entry.ID)
-			processedIDs[entry.ID] = true
-		}
-
-		// 2. Process Remaining Directories (if any not in history but exist on disk)
-		// Only if history failed or incomplete? User specifically asked for order.
-		// If history loaded, we trust it. But maybe scan directories too for safety?
-		// No, user wants STRICT order. If not in history, maybe ignore?
-		// Let's iterate dir entries too just in case history is out of sync, but append them at end.
-		entries, err := os.ReadDir(rootSource)
-		if err == nil {
-			for _, entry := range entries {
-				if !entry.IsDir() {
-					continue
+		// Run Backup Logic for this Export
+		startBytes := totalStats.Bytes
+		startFiles := len(totalStats.Files)
+
+		if backend == store.BackendObjects {
+			err = backupExportToStore(exportSource(exportPath, exportFileIndex), snapshotDir, objectStore, viewMode, &totalStats, dryRun)
+		} else {
+			err = backupExport(exportSource(exportPath, exportFileIndex), snapshotDir, prevBackup, inodeMap, sharedHashes, &totalStats, dryRun)
+		}
+		if err != nil {
+			logger.Error(i18n.T("update_backup_fail_export"), exportID, err)
+			failedExports = append(failedExports, exportID)
+			exportErrors[exportID] = err
+		} else {
+			for _, f := range totalStats.Files[startFiles:] {
+				if !strings.EqualFold(filepath.Ext(f), ".json") {
+					newMedia[exportID]++
 				}
-				id := entry.Name()
-				if !processedIDs[id] {
-					// Is it a valid UUID-like ID? Assume yes if folder matches structure.
-					// Or skipping to enforce "only history-known exports".
-					// Safe bet: Process it.
-					processID(id)
+			}
+			// Success! Delete Source Export Content (Only 'raw')
+			if !dryRun {
+				logger.Info(i18n.T("update_backup_delete_content"), exportID)
+				// We only delete the 'raw' folder to save space, but keep state.json/metadata
+				if err := os.RemoveAll(rawPath); err != nil {
+					logger.Error(i18n.T("update_backup_delete_fail"), rawPath, err)
 				}
+			} else {
+				logger.Info(i18n.T("update_backup_dry_delete"), rawPath)
+			}
+			processedExportsCount++
+			result.Exports = append(result.Exports, exportID)
+			if state, err := registry.LoadDownloadState(filepath.Join(exportPath, "state.json")); err == nil && state.Incremental {
+				incrementalExports++
 			}
 		}
 
-		if processedExportsCount == 0 {
-			logger.Info(i18n.T("update_backup_no_exports"))
-			// Cleanup empty snapshot if created?
-			if !dryRun && totalStats.Added == 0 && totalStats.Linked == 0 {
-				os.Remove(snapshotDir)
-			}
-			return
+		if totalStats.Bytes > startBytes {
+			// Logic to show incremental progress if needed
 		}
+	}
 
-		// 3. Update Immich Master (if enabled)
-		immichEnabled := config.AppConfig.ImmichMasterEnabled
-		// Fallback to viper if not set in struct (legacy/viper overlap)
-		if !immichEnabled {
-			immichEnabled = viper.GetBool("immich_master_enabled")
+	// 1. Process From History (Ordered)
+	processedIDs := make(map[string]bool)
+	for _, entry := range validExports {
+		if entry.ID == "" {
+			continue
 		}
+		processID(entry.ID)
+		processedIDs[entry.ID] = true
+	}
 
-		immichCount := 0
-		if immichEnabled && !dryRun {
-			immichPath := config.AppConfig.ImmichMasterPath
-			if immichPath == "" {
-				immichPath = viper.GetString("immich_master_path")
+	// 2. Process Remaining Directories (if any not in history but exist on disk)
+	// Only if history failed or incomplete? User specifically asked for order.
+	// If history loaded, we trust it. But maybe scan directories too for safety?
+	// No, user wants STRICT order. If not in history, maybe ignore?
+	// Let's iterate dir entries too just in case history is out of sync, but append them at end.
+	entries, err := os.ReadDir(rootSource)
+	if err == nil {
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
 			}
-			if immichPath == "" {
-				immichPath = "immich-master"
+			id := entry.Name()
+			if !processedIDs[id] {
+				// Is it a valid UUID-like ID? Assume yes if folder matches structure.
+				// Or skipping to enforce "only history-known exports".
+				// Safe bet: Process it.
+				processID(id)
 			}
-			logger.Info("📸 Updating Immich Master Directory (%s)...", immichPath)
-			masterRoot := filepath.Join(backupPath, immichPath)
-
-			// A. Ensure Index for New Snapshot
-			// We scan the WHOLE snapshot to be safe and robust, using Inode optimization.
-			// This covers 'Added', 'Linked', and 'Internal' files uniformly.
-			snapIdx, err := processor.EnsureSnapshotIndex(snapshotDir)
-			if err != nil {
-				logger.Error("Failed to generate index for new snapshot: %v", err)
-			} else {
-				// B. Load Master Index
-				masterIndexPath := filepath.Join(masterRoot, "index.json")
-				masterIndex, err := registry.LoadIndex(masterIndexPath)
-				if err != nil {
-					// logger.Info("⚠️  Could not load master index (will create new): %v", err)
-					masterIndex = registry.NewIndex()
-				}
-				masterHashMap := processor.GetMasterHashMap(masterIndex)
+		}
+	}
 
-				// C. Link to Master
-				if err := processor.LinkSnapshotToMaster(snapshotDir, snapIdx, masterRoot, masterIndex, masterHashMap); err != nil {
-					logger.Error("Failed to link new snapshot to master: %v", err)
-				} else {
-					// Count how many files we *know* are in master now (just for stats/log)
-					// Actually we can't easily count *newly* linked without modifying return of LinkSnapshotToMaster
-					// But we can just say "Updated".
-					immichCount = len(snapIdx.Files) // Reporting total files tracked for this snapshot
-				}
+	if processedExportsCount == 0 {
+		if !dryRun {
+			recordBackupStatus(reg, nil, nil, exportErrors)
+		}
+		logger.Info(i18n.T("update_backup_no_exports"))
+		// Cleanup empty snapshot if created?
+		if !dryRun && totalStats.Added == 0 && totalStats.Linked == 0 && totalStats.Copied == 0 {
+			os.Remove(snapshotDir)
+		}
+		return result, backupFailure(outOfSpace, failedExports)
+	}
 
-				// D. Save Master Index
-				if err := masterIndex.Save(masterIndexPath); err != nil {
-					logger.Error("Failed to save Master Index: %v", err)
-				}
-			}
+	// Incremental exports only hold new items: everything else comes from the previous snapshot
+	fullExports := processedExportsCount - incrementalExports
+	if fullExports == 0 && prevBackup != "" && !dryRun {
+		carried, err := carryForward(prevBackup, snapshotDir)
+		if err != nil {
+			logger.Error("Failed to carry the previous snapshot forward: %v", err)
 		}
+		logger.Info("↪️  Incremental export: %d files carried forward from %s.", carried, filepath.Base(prevBackup))
+	}
 
-		// Logging
-		if !dryRun {
-			logEntry := BackupLogEntry{
-				Timestamp: timestamp,
-				Source:    rootSource,
-				Snapshot:  snapshotDir,
-				Added:     totalStats.Added,
-				Linked:    totalStats.Linked,
-				Internal:  totalStats.Internal,
-				Size:      totalStats.Bytes,
-				Files:     totalStats.Files,
-			}
+	// Index the new snapshot once; both deletion detection and Immich need it.
+	var snapIdx *registry.Index
+	if !dryRun {
+		snapIdx, err = processor.EnsureSnapshotIndex(snapshotDir)
+		if err != nil {
+			logger.Error("Failed to generate index for new snapshot: %v", err)
+			failedExports = append(failedExports, "index.json")
+		}
+		defer snapIdx.Close()
+	}
 
-			logPath := filepath.Join(backupPath, "backup_log.jsonl")
-			f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				logger.Error("❌ Failed to open backup log: %v", err)
-			} else {
-				jsonBytes, _ := json.Marshal(logEntry)
-				if _, err := f.Write(append(jsonBytes, '\n')); err != nil {
-					logger.Error("❌ Failed to write to backup log: %v", err)
-				}
-				f.Close()
-				logger.Info(i18n.T("update_backup_log_updated"), logPath)
-			}
+	// Seal the snapshot into the tamper-evident chain
+	if snapIdx != nil {
+		if err := sealSnapshot(backupPath, timestamp); err != nil {
+			logger.Error("Failed to seal snapshot into chain: %v", err)
+			failedExports = append(failedExports, "chain.json")
 		}
+	}
+
+	// 3. Detect files that disappeared upstream since the previous export
+	var disappeared []string
+	deletionAlert := false
+	if snapIdx != nil && prevBackup != "" && fullExports > 0 {
+		disappeared, deletionAlert = checkUpstreamDeletions(backupPath, prevBackup, timestamp, snapIdx, opts.DeletionAlertRatio)
+	}
 
-		// Summary
-		logger.Info(i18n.T("update_backup_success"), totalStats.Added, formatSizeForBackup(totalStats.Bytes), totalStats.Linked, rootSource)
-		logger.Info(i18n.T("update_backup_summary_links"), totalStats.Linked)
-		logger.Info(i18n.T("update_backup_summary_internal"), totalStats.Internal)
-		if immichEnabled && !dryRun {
-			logger.Info("📸 Immich Master: %d files linked", immichCount)
+	// 4. Update Immich Master (if enabled)
+	immichEnabled := config.AppConfig.ImmichMasterEnabled
+	// Fallback to viper if not set in struct (legacy/viper overlap)
+	if !immichEnabled {
+		immichEnabled = viper.GetBool("immich_master_enabled")
+	}
+
+	immichCount := 0
+	if immichEnabled && !dryRun && snapIdx != nil && !opts.SkipImmich {
+		immichCount = updateImmichMaster(backupPath, snapshotDir, snapIdx)
+	}
+
+	// Logging
+	if !dryRun {
+		// Paths are stored relative to their root so the backup can be relocated
+		logEntry := BackupLogEntry{
+			Timestamp: timestamp,
+			Source:    relativeTo(workingPath, rootSource),
+			Snapshot:  relativeTo(backupPath, snapshotDir),
+			Added:     totalStats.Added,
+			Linked:    totalStats.Linked,
+			Copied:    totalStats.Copied,
+			Internal:  totalStats.Internal,
+			Size:      totalStats.Bytes,
+			Files:     totalStats.Files,
+
+			Disappeared: disappeared,
+			Alert:       deletionAlert,
 		}
-		logger.Info(i18n.T("update_backup_summary_exports"), processedExportsCount)
-	},
+
+		appendBackupLog(backupPath, logEntry)
+		recordBackupStatus(reg, result.Exports, newMedia, exportErrors)
+	}
+
+	// Summary
+	logger.Info(i18n.T("update_backup_success"), totalStats.Added, formatSizeForBackup(totalStats.Bytes), totalStats.Linked, rootSource)
+	logger.Info(i18n.T("update_backup_summary_links"), totalStats.Linked)
+	logger.Info(i18n.T("update_backup_summary_internal"), totalStats.Internal)
+	if totalStats.Copied > 0 {
+		logger.Info(i18n.T("update_backup_summary_copied"), totalStats.Copied)
+	}
+	if immichEnabled && !dryRun && !opts.SkipImmich {
+		logger.Info("📸 Immich Master: %d files linked", immichCount)
+	}
+	logger.Info(i18n.T("update_backup_summary_exports"), processedExportsCount)
+
+	if !dryRun {
+		result.Snapshot = snapshotDir
+	}
+	result.Alert = deletionAlert
+	if err := backupFailure(outOfSpace, failedExports); err != nil {
+		return result, err
+	}
+	if deletionAlert {
+		return result, failed(exitDeletionAlert, fmt.Errorf("%d files disappeared upstream", len(disappeared)))
+	}
+	return result, nil
+}
+
+// recordBackupStatus advances the exports now in the snapshot to snapshotted, with the
+// number of media files they added, and marks the ones that failed
+func recordBackupStatus(reg *registry.Registry, backedUp []string, newMedia map[string]int, failures map[string]error) {
+	if len(backedUp) == 0 && len(failures) == 0 {
+		return
+	}
+	for _, id := range backedUp {
+		recordStatus(reg, id, registry.StatusSnapshotted, func(e *registry.ExportEntry) {
+			e.NewPhotosCount = newMedia[id]
+			e.Error = ""
+		})
+	}
+	for id, err := range failures {
+		recordStatus(reg, id, registry.StatusFailed, func(e *registry.ExportEntry) { e.Error = err.Error() })
+	}
+	if err := reg.Save(); err != nil {
+		logger.Error("Cannot save history: %v", err)
+	}
+}
+
+// backupFailure returns the error of a partial update-backup: exports left behind for
+// lack of space (or a shutdown while waiting for it), or steps that failed while the rest
+// was backed up
+func backupFailure(outOfSpace error, failedSteps []string) error {
+	if errors.Is(outOfSpace, errInterrupted) {
+		return failed(exitBackup, outOfSpace)
+	}
+	if outOfSpace != nil {
+		return failed(exitDiskSpace, outOfSpace)
+	}
+	if len(failedSteps) > 0 {
+		return failed(exitBackup, fmt.Errorf("backup failed for %s", strings.Join(failedSteps, ", ")))
+	}
+	return nil
 }
 
 func init() {
 	rootCmd.AddCommand(updateBackupCmd)
 	updateBackupCmd.Flags().String("source", "", "Source directory (defaults to working_path/downloads)")
 	updateBackupCmd.Flags().Bool("dry-run", false, "Simulate the update")
+	updateBackupCmd.Flags().Float64("deletion-alert-ratio", 0, "Alert if more than this fraction of files disappeared upstream (overrides config)")
 }
 
-// backupExport recursively backups a single export directory
-func backupExport(srcDir, snapshotRoot, prevBackupRoot string, inodeMap map[uint64]string, fileIndex map[string]processor.FileMetadata, stats *struct {
-	Added    int
-	Linked   int
-	Internal int
-	Bytes    int64
-	Files    []string
-}, dryRun bool) error {
+// checkUpstreamDeletions compares the new snapshot against the previous one, keeps every
+// file that disappeared upstream reachable under "Deleted upstream/<timestamp>" and
+// returns the disappeared paths and whether the alert ratio was exceeded.
+func checkUpstreamDeletions(backupPath, prevBackup, timestamp string, snapIdx *registry.Index, ratio float64) ([]string, bool) {
+	prevIdx, err := snapshotIndex(prevBackup)
+	if err != nil {
+		logger.Error("Failed to load the index of previous snapshot %s: %v", filepath.Base(prevBackup), err)
+		return nil, false
+	}
+	defer prevIdx.Close()
+
+	missing, checked, err := processor.DetectUpstreamDeletions(prevIdx, snapIdx)
+	if err != nil {
+		logger.Error("Failed to compare with previous snapshot %s: %v", filepath.Base(prevBackup), err)
+		return nil, false
+	}
+	if len(missing) == 0 {
+		logger.Info("✅ No files disappeared upstream since %s.", filepath.Base(prevBackup))
+		return nil, false
+	}
 
-	// We want to flatten the structure.
-	// Source: downloads/ID/raw/Takeout/Google Photos/...
-	// Dest:   snapshot/Google Photos/...
+	deletedRoot := filepath.Join(backupPath, processor.DeletedUpstreamDir, timestamp)
+	preserved, err := processor.PreserveDeleted(prevBackup, prevIdx, missing, deletedRoot)
+	if err != nil {
+		logger.Error("Failed to preserve deleted files: %v", err)
+	}
 
-	// 1. Locate the actual content root within srcDir (which is downloads/ID)
-	// Usually it's srcDir/raw/Takeout/Google Photos
-	// But sometimes Takeout is skipped if zip structure was different?
-	// Let's find "Google Photos" directory.
+	paths := make([]string, 0, len(missing))
+	for _, entry := range missing {
+		paths = append(paths, entry.RelPath)
+	}
+	logger.Info("🗑️  %d files disappeared upstream since %s (%d kept in %s).", len(missing), filepath.Base(prevBackup), preserved, deletedRoot)
 
-	contentRoot := ""
-	possibleRoots := []string{
-		filepath.Join(srcDir, "raw", "Takeout", "Google Photos"),
-		filepath.Join(srcDir, "raw", "Google Photos"),
-		filepath.Join(srcDir, "raw"), // Fallback if no specific folder structure? No, that would dump raw files.
+	if ratio <= 0 {
+		ratio = config.AppConfig.DeletionAlertRatio
+	}
+	if ratio <= 0 {
+		ratio = viper.GetFloat64("deletion_alert_ratio")
 	}
 
-	for _, p := range possibleRoots {
-		if info, err := os.Stat(p); err == nil && info.IsDir() {
-			contentRoot = p
-			break
-		}
+	rate := float64(len(missing)) / float64(checked)
+	if ratio > 0 && rate > ratio {
+		logger.Error("ALERT: %.1f%% of files (%d/%d) disappeared upstream (threshold %.1f%%). The export is probably broken or partial.", rate*100, len(missing), checked, ratio*100)
+		return paths, true
+	}
+	return paths, false
+}
+
+// backupSource is what backupExport and backupExportToStore walk: the content of an
+// extracted export, or a folder given to 'ingest'
+type backupSource struct {
+	ContentRoot  string                 // Walked tree, mapped to DestDir in the snapshot
+	DestDir      string                 // "Google Photos", or ingestedDir
+	FileIndex    *processor.ExportIndex // Hashes recorded by 'process'; nil computes them
+	Keep         bool                   // Copy new files instead of moving them (the source is left untouched)
+	SkipArchives bool                   // Leave the original .zip/.tgz archives out
+	// Date returns the date of a new file (EXIF for ingest); nil keeps its mtime
+	Date func(path string) (time.Time, bool)
+}
+
+// exportSource is the backupSource of an export directory (downloads/<ID>)
+func exportSource(srcDir string, fileIndex *processor.ExportIndex) backupSource {
+	return backupSource{
+		ContentRoot:  findContentRoot(srcDir),
+		DestDir:      "Google Photos",
+		FileIndex:    fileIndex,
+		SkipArchives: true,
+	}
+}
+
+// hash returns the content hash of path: from the export index when it has a matching
+// entry, computed for sources without an index, "" if unknown
+func (src backupSource) hash(path string, size int64) string {
+	if src.FileIndex == nil {
+		h, _ := calculateHash(path)
+		return h
+	}
+	if meta, ok := src.FileIndex.Get(path); ok && meta.Size == size {
+		return meta.Hash
 	}
+	return ""
+}
+
+// skip reports whether a walked file is left out of the snapshot
+func (src backupSource) skip(path string) bool {
+	switch filepath.Base(path) {
+	case ".DS_Store", "Thumbs.db", "desktop.ini":
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	return src.SkipArchives && (ext == ".zip" || ext == ".tgz")
+}
+
+// store puts a new file at destPath, copying it when the source must be kept
+func (src backupSource) store(path, destPath string) error {
+	if !src.Keep {
+		return moveFile(path, destPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	return fsutil.CopyFile(path, destPath)
+}
 
-	if contentRoot == "" {
-		// Log warning but maybe process raw directly if structured differently?
-		// For now, assume "Google Photos" must exist to be a valid backup source
-		logger.Info("⚠️ Could not find 'Google Photos' folder in %s. Skipping flattening.", srcDir)
-		// Fallback to processing 'raw' as root, but mapping to 'Google Photos' in dest?
-		contentRoot = filepath.Join(srcDir, "raw")
+// setDate gives a new file the date resolved by src.Date, if any
+func (src backupSource) setDate(path, target string) {
+	if src.Date == nil {
+		return
 	}
+	if date, ok := src.Date(path); ok {
+		os.Chtimes(target, date, date)
+	}
+}
+
+// backupExport recursively backs up a source (an export directory or an ingested folder)
+// into snapshotRoot/src.DestDir
+func backupExport(src backupSource, snapshotRoot, prevBackupRoot string, inodeMap map[uint64]string, sharedHashes map[string]string, stats *backupStats, dryRun bool) error {
+	contentRoot := src.ContentRoot
 
-	// Calculate prefix length to strip
-	// We want relative path from contentRoot
-	// e.g. contentRoot = .../Google Photos
-	// file = .../Google Photos/Album/Img.jpg
-	// rel = Album/Img.jpg
+	// Paths are relative to the content root:
+	// contentRoot = .../Google Photos, file = .../Google Photos/Album/Img.jpg
 	// Dest = snapshot/Google Photos/Album/Img.jpg
 
-	targetDestRoot := filepath.Join(snapshotRoot, "Google Photos")
+	targetDestRoot := filepath.Join(snapshotRoot, src.DestDir)
 	prevDestRoot := ""
 	if prevBackupRoot != "" {
-		prevDestRoot = filepath.Join(prevBackupRoot, "Google Photos")
+		prevDestRoot = filepath.Join(prevBackupRoot, src.DestDir)
 	}
 
 	return filepath.Walk(contentRoot, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
-			return nil
-		}
-
-		// Skip system files
-		if info.Name() == ".DS_Store" {
-			return nil
-		}
-
-		// Skip original archives if present
-		ext := strings.ToLower(filepath.Ext(path))
-		if ext == ".zip" || ext == ".tgz" {
+		if info.IsDir() || src.skip(path) {
 			return nil
 		}
 
@@ -519,6 +741,13 @@ func backupExport(srcDir, snapshotRoot, prevBackupRoot string, inodeMap map[uint
 			return nil
 		}
 
+		// A kept source is copied: symlinks in it stand for the file they point to
+		if src.Keep && info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(path); err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+		}
+
 		// Get Source Inode
 		stat, ok := info.Sys().(*syscall.Stat_t)
 		if !ok {
@@ -535,11 +764,13 @@ func backupExport(srcDir, snapshotRoot, prevBackupRoot string, inodeMap map[uint
 				return err
 			}
 
-			if err := os.Link(prevPath, destPath); err == nil {
-				stats.Linked++
+			if used, err := fsutil.Default().Link(prevPath, destPath); err == nil {
+				stats.linked(used, info.Size())
 				// If it was from previous run, it counts as linked.
 				// If it was internal from same run, it also counts.
-				inodeMap[inode] = destPath // Update map
+				// Update map. If the link limit forced a copy, later occurrences link to
+				// this new copy (a new inode generation) instead of the full inode.
+				inodeMap[inode] = destPath
 				linkedFromPrev = true
 				return nil
 			} else {
@@ -556,10 +787,7 @@ func backupExport(srcDir, snapshotRoot, prevBackupRoot string, inodeMap map[uint
 				if prevInfo.Size() == info.Size() {
 					// Size matches. Check Hash.
 					// Get Source Hash from Index
-					sourceHash := ""
-					if meta, ok := fileIndex[path]; ok {
-						sourceHash = meta.Hash
-					}
+					sourceHash := src.hash(path, info.Size())
 
 					// Get Dest Hash (Compute)
 					// Only compute if we have source hash (otherwise comparison impossible efficiently?)
@@ -586,9 +814,9 @@ func backupExport(srcDir, snapshotRoot, prevBackupRoot string, inodeMap map[uint
 						if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
 							return err
 						}
-						// Hardlink: Dest -> Previous
-						if err := os.Link(prevFile, destPath); err == nil {
-							stats.Linked++
+						// Link: Dest -> Previous
+						if used, err := fsutil.Default().Link(prevFile, destPath); err == nil {
+							stats.linked(used, info.Size())
 							inodeMap[inode] = destPath
 							linkedFromPrev = true
 							logger.Info(i18n.T("update_backup_linked_prev"), relPath)
@@ -599,40 +827,312 @@ func backupExport(srcDir, snapshotRoot, prevBackupRoot string, inodeMap map[uint
 			}
 		}
 
-		// 3. Copy/Move (New File)
+		// 3. Same content elsewhere in the backup (other accounts, or the snapshots an
+		// ingest links to)
+		hash := ""
+		if !linkedFromPrev && sharedHashes != nil {
+			if hash = src.hash(path, info.Size()); hash != "" {
+				if sharedFile, ok := sharedHashes[hash]; ok {
+					if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+						return err
+					}
+					if used, err := fsutil.Default().Link(sharedFile, destPath); err == nil {
+						stats.linked(used, info.Size())
+						inodeMap[inode] = destPath
+						return nil
+					}
+				}
+			}
+		}
+
+		// 4. Copy/Move (New File)
 		if !linkedFromPrev {
-			if err := moveFile(path, destPath); err != nil {
+			if err := src.store(path, destPath); err != nil {
 				logger.Error("Failed to move/copy %s: %v", relPath, err)
 				return err
 			}
+			src.setDate(destPath, destPath)
 			stats.Added++
 			stats.Bytes += info.Size()
 			stats.Files = append(stats.Files, relPath)
 			inodeMap[inode] = destPath
+			if hash != "" {
+				sharedHashes[hash] = destPath
+			}
+			logger.Info(i18n.T("update_backup_copied"), relPath)
+		}
+		return nil
+	})
+}
+
+// backupSpaceNeeded estimates the bytes an export adds to the backup: each hash once,
+// leaving out content the previous snapshot (or the object store) already holds.
+func backupSpaceNeeded(rawPath string, fileIndex *processor.ExportIndex, prevHashes map[string]bool, backend string, st *store.Store) int64 {
+	if fileIndex.Empty() {
+		return diskspace.TreeSize(rawPath)
+	}
+	var need int64
+	counted := make(map[string]bool)
+	fileIndex.ForEach(func(meta processor.FileMetadata) error {
+		if meta.Hash == "" {
+			need += meta.Size
+			return nil
+		}
+		if counted[meta.Hash] || prevHashes[meta.Hash] || (backend == store.BackendObjects && st.Has(meta.Hash)) {
+			return nil
+		}
+		counted[meta.Hash] = true
+		need += meta.Size
+		return nil
+	})
+	return need
+}
+
+// carryForward links every file of the previous snapshot that the new one lacks, so a
+// snapshot built from incremental exports is still complete. Returns the number linked.
+func carryForward(prevBackup, snapshotDir string) (int, error) {
+	prevRoot := filepath.Join(prevBackup, "Google Photos")
+	linker := fsutil.Default()
+	count := 0
+	err := filepath.Walk(prevRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == prevRoot {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, _ := filepath.Rel(prevBackup, path)
+		destPath := filepath.Join(snapshotDir, relPath)
+		if _, err := os.Lstat(destPath); err == nil {
+			return nil // Replaced by the new export
+		}
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return err
+		}
+		if _, err := linker.Link(path, destPath); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// findContentRoot locates the directory inside an export (downloads/ID) that maps to
+// snapshot/Google Photos. Structure is flattened:
+// Source: downloads/ID/raw/Takeout/Google Photos/...
+// Dest:   snapshot/Google Photos/...
+func findContentRoot(srcDir string) string {
+	possibleRoots := []string{
+		filepath.Join(srcDir, "raw", "Takeout", "Google Photos"),
+		filepath.Join(srcDir, "raw", "Google Photos"),
+		filepath.Join(srcDir, "raw"), // Fallback if no specific folder structure
+	}
+	for _, p := range possibleRoots {
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			return p
+		}
+	}
+
+	// Assume "Google Photos" must exist to be a valid backup source
+	logger.Info("⚠️ Could not find 'Google Photos' folder in %s. Skipping flattening.", srcDir)
+	return filepath.Join(srcDir, "raw")
+}
+
+// backupExportToStore is backupExport for the "objects" storage backend: each file is moved
+// (copied for a kept source) into the object store once per hash and the snapshot entry is
+// materialised from it. Content the store already holds counts as linked.
+func backupExportToStore(src backupSource, snapshotRoot string, st *store.Store, viewMode string, stats *backupStats, dryRun bool) error {
+	contentRoot := src.ContentRoot
+	targetDestRoot := filepath.Join(snapshotRoot, src.DestDir)
+
+	return filepath.Walk(contentRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || src.skip(path) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(contentRoot, path)
+		if err != nil {
+			return nil
+		}
+		if dryRun {
+			return nil
+		}
+
+		// Symlinks left by 'process' deduplication point at another file of the export:
+		// their content is copied, the link itself must never be moved into the store
+		isLink := info.Mode()&os.ModeSymlink != 0
+		if isLink {
+			if info, err = os.Stat(path); err != nil {
+				logger.Error("Broken symlink %s: %v", relPath, err)
+				return nil
+			}
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		hash, added, err := st.Put(path, src.hash(path, info.Size()), !src.Keep && !isLink)
+		if err != nil {
+			logger.Error("Failed to store %s: %v", relPath, err)
+			return err
+		}
+		if added {
+			src.setDate(path, st.ObjectPath(hash))
+		}
+		if err := st.Materialize(hash, filepath.Join(targetDestRoot, relPath), viewMode); err != nil {
+			logger.Error("Failed to materialise %s: %v", relPath, err)
+			return err
+		}
+
+		if added {
+			stats.Added++
+			stats.Bytes += info.Size()
+			stats.Files = append(stats.Files, relPath)
 			logger.Info(i18n.T("update_backup_copied"), relPath)
+		} else {
+			stats.Linked++
 		}
 		return nil
 	})
 }
 
+// updateImmichMaster links a freshly indexed snapshot into the Immich Master directory
+// and returns the number of files tracked for it.
+func updateImmichMaster(backupPath, snapshotDir string, snapIdx *registry.Index) int {
+	immichPath := getImmichPath()
+	logger.Info("📸 Updating Immich Master Directory (%s)...", immichPath)
+	masterRoot := getImmichRoot(backupPath)
+
+	// A. Index for New Snapshot (generated by the caller)
+	// We scan the WHOLE snapshot to be safe and robust, using Inode optimization.
+	// This covers 'Added', 'Linked', and 'Internal' files uniformly.
+
+	// B. Load Master Index
+	masterIndexPath := filepath.Join(masterRoot, "index.json")
+	masterIndex, err := registry.LoadIndex(masterIndexPath)
+	if err != nil {
+		masterIndex = registry.NewIndex()
+	}
+	defer masterIndex.Close()
+
+	// C. Link to Master
+	count := 0
+	if err := processor.LinkSnapshotToMaster(snapshotDir, snapIdx, masterRoot, masterIndex); err != nil {
+		logger.Error("Failed to link new snapshot to master: %v", err)
+	} else {
+		// We can't easily count *newly* linked files: report total files tracked for this snapshot
+		count = snapIdx.Len()
+	}
+
+	// D. Save Master Index
+	if err := masterIndex.Save(masterIndexPath); err != nil {
+		logger.Error("Failed to save Master Index: %v", err)
+	}
+	return count
+}
+
+// appendBackupLog adds one entry to backup_path/backup_log.jsonl
+func appendBackupLog(backupPath string, entry BackupLogEntry) {
+	logPath := filepath.Join(backupPath, "backup_log.jsonl")
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Error("❌ Failed to open backup log: %v", err)
+		return
+	}
+	defer f.Close()
+
+	entry.SchemaVersion = schema.Current(schema.BackupLog)
+	jsonBytes, _ := json.Marshal(entry)
+	if _, err := f.Write(append(jsonBytes, '\n')); err != nil {
+		logger.Error("❌ Failed to write to backup log: %v", err)
+		return
+	}
+	logger.Info(i18n.T("update_backup_log_updated"), logPath)
+}
+
 // Helpers
 
+// getStorageBackend returns the configured storage backend ("hardlink" or "objects") and
+// how views of the object store are materialised
+func getStorageBackend() (string, string) {
+	backend := config.AppConfig.StorageBackend
+	if backend == "" {
+		backend = viper.GetString("storage_backend")
+	}
+	viewMode := config.AppConfig.StoreViewMode
+	if viewMode == "" {
+		viewMode = viper.GetString("store_view_mode")
+	}
+	if backend == "" {
+		backend = store.BackendHardlink
+	}
+	return backend, viewMode
+}
+
+// findLatestBackup returns the latest export snapshot. Ingested snapshots are skipped:
+// they are not a previous state of the Google library.
 func findLatestBackup(finalPath string) string {
-	entries, err := os.ReadDir(finalPath)
+	dirs, err := listSnapshots(finalPath)
 	if err != nil {
 		return ""
 	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if !isIngestSnapshot(dirs[i]) {
+			return filepath.Join(finalPath, dirs[i])
+		}
+	}
+	return ""
+}
+
+// listSnapshots returns the names of all timestamped snapshots in backupPath, oldest first
+func listSnapshots(backupPath string) ([]string, error) {
+	entries, err := os.ReadDir(backupPath)
+	if err != nil {
+		return nil, err
+	}
 	var dirs []string
 	for _, entry := range entries {
 		if entry.IsDir() && isTimestamp(entry.Name()) {
 			dirs = append(dirs, entry.Name())
 		}
 	}
-	if len(dirs) == 0 {
-		return ""
-	}
 	sort.Strings(dirs)
-	return filepath.Join(finalPath, dirs[len(dirs)-1])
+	return dirs, nil
+}
+
+// getBackupPath resolves the configured backup_path (struct first, viper fallback)
+func getBackupPath() string {
+	backupPath := config.AppConfig.BackupPath
+	if backupPath == "" {
+		backupPath = viper.GetString("backup_path")
+	}
+	return expandPath(backupPath)
+}
+
+// getWorkingPath resolves the configured working_path (struct first, viper fallback)
+func getWorkingPath() string {
+	workingPath := config.AppConfig.WorkingPath
+	if workingPath == "" {
+		workingPath = viper.GetString("working_path")
+	}
+	return expandPath(workingPath)
+}
+
+// getOutputDir resolves the directory holding the global processing index: output_dir,
+// or working_path/output
+func getOutputDir(workingPath string) string {
+	if outputDir := expandPath(viper.GetString("output_dir")); outputDir != "" {
+		return outputDir
+	}
+	return filepath.Join(workingPath, "output")
 }
 
 // isTimestamp is available in package cmd (from fix_hardlinks.go)
@@ -695,6 +1195,55 @@ func moveFile(src, dst string) error {
 	return os.Remove(src)
 }
 
+// relativeTo returns path relative to root when it lies inside it, or path unchanged otherwise
+func relativeTo(root, path string) string {
+	if root == "" {
+		return path
+	}
+	absRoot, err1 := filepath.Abs(root)
+	absPath, err2 := filepath.Abs(path)
+	if err1 != nil || err2 != nil {
+		return path
+	}
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return rel
+}
+
+// readBackupLog parses backup_log.jsonl. Relative snapshot paths are resolved against backupPath.
+func readBackupLog(backupPath string) ([]BackupLogEntry, error) {
+	logPath := filepath.Join(backupPath, "backup_log.jsonl")
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		return nil, err
+	}
+	var entries []BackupLogEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		raw, _, err := schema.Upgrade(schema.Context{Path: logPath}, schema.BackupLog, []byte(line))
+		if err != nil {
+			var tooNew *schema.TooNewError
+			if errors.As(err, &tooNew) {
+				return nil, err
+			}
+			continue
+		}
+		var entry BackupLogEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			continue
+		}
+		if entry.Snapshot != "" && !filepath.IsAbs(entry.Snapshot) {
+			entry.Snapshot = filepath.Join(backupPath, entry.Snapshot)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
 func expandPath(path string) string {
 	if strings.HasPrefix(path, "~/") {
 		home, _ := os.UserHomeDir()
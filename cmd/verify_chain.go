@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google-photos-backup/internal/config"
+	"google-photos-backup/internal/i18n"
+	"google-photos-backup/internal/integrity"
+	"google-photos-backup/internal/logger"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var verifyChainCmd = &cobra.Command{
+	Use:           "verify-chain",
+	Short:         "Verify the tamper-evident chain of snapshots",
+	Long:          `Walks all snapshots in chronological order and checks that each chain.json is linked to the previous one, that its Ed25519 signature is valid and that index.json still matches what was sealed. With a signing key configured, an unsigned link after a signed one breaks the chain. With --deep every file is also re-hashed against index.json. With --seal, unsealed snapshots at the end of the chain are sealed first. Exits with code 9 if the chain is broken.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupPath := getBackupPath()
+		if backupPath == "" {
+			logger.Error(i18n.T("update_backup_no_config"))
+			return failed(exitConfig, fmt.Errorf("backup_path is not set"))
+		}
+
+		deep, _ := cmd.Flags().GetBool("deep")
+		seal, _ := cmd.Flags().GetBool("seal")
+
+		snapshots, err := listSnapshots(backupPath)
+		if err != nil {
+			return logFailed(exitFailure, fmt.Errorf("error reading backup dir: %w", err))
+		}
+		if len(snapshots) == 0 {
+			logger.Info("⚠️  No snapshots found.")
+			return nil
+		}
+
+		if seal {
+			sealUnsealedTail(backupPath, snapshots)
+		}
+
+		// Trusted key (if configured)
+		var pub ed25519.PublicKey
+		if keyPath := getSigningKeyPath(); keyPath != "" {
+			if pub, err = integrity.LoadPublicKey(keyPath); err != nil {
+				return logFailed(exitConfig, fmt.Errorf("failed to load signing key %s: %w", keyPath, err))
+			}
+		}
+
+		broken, unsealed, warned := 0, 0, 0
+		prevName, prevHash := "", ""
+		signedBefore := false
+
+		for _, snapName := range snapshots {
+			snapPath := filepath.Join(backupPath, snapName)
+
+			link, err := integrity.LoadLink(snapPath)
+			if err != nil {
+				if os.IsNotExist(err) {
+					logger.Info("⚪ %s: not sealed", snapName)
+					unsealed++
+				} else {
+					logger.Error("%s: unreadable chain.json: %v", snapName, err)
+					broken++
+				}
+				continue
+			}
+
+			problems, warnings := integrity.VerifyLink(snapPath, link, prevName, prevHash, pub, signedBefore)
+			if deep {
+				problems = append(problems, integrity.VerifyContent(snapPath)...)
+			}
+
+			for _, w := range warnings {
+				logger.Info("⚠️  %s: %s", snapName, w)
+			}
+			if len(warnings) > 0 {
+				warned++
+			}
+
+			if len(problems) > 0 {
+				broken++
+				for _, p := range problems {
+					logger.Error("%s: %s", snapName, p)
+				}
+			} else {
+				logger.Info("✅ %s: OK (%d files)", snapName, link.FileCount)
+			}
+
+			prevName = snapName
+			prevHash, _ = integrity.LinkHash(snapPath)
+			signedBefore = signedBefore || link.Signature != ""
+		}
+
+		if broken > 0 {
+			return logFailed(exitIntegrity, fmt.Errorf("chain verification failed: %d broken, %d unsealed, %d with warnings (of %d snapshots)", broken, unsealed, warned, len(snapshots)))
+		}
+		logger.Info("🔗 Chain intact: %d snapshots verified, %d unsealed, %d with warnings.", len(snapshots)-unsealed, unsealed, warned)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyChainCmd)
+	verifyChainCmd.Flags().Bool("deep", false, "Also re-hash every file against index.json")
+	verifyChainCmd.Flags().Bool("seal", false, "Seal unsealed snapshots after the last sealed one before verifying")
+}
+
+// getSigningKeyPath resolves the configured signing_key_path (struct first, viper fallback)
+func getSigningKeyPath() string {
+	keyPath := config.AppConfig.SigningKeyPath
+	if keyPath == "" {
+		keyPath = viper.GetString("signing_key_path")
+	}
+	return expandPath(keyPath)
+}
+
+// sealSnapshot writes chain.json for a snapshot, linking it to the latest sealed
+// snapshot before it and signing it if a key is configured.
+func sealSnapshot(backupPath, snapName string) error {
+	snapshots, err := listSnapshots(backupPath)
+	if err != nil {
+		return err
+	}
+
+	prevPath := ""
+	for _, name := range snapshots {
+		if name >= snapName {
+			break
+		}
+		if _, err := os.Stat(filepath.Join(backupPath, name, integrity.ChainFileName)); err == nil {
+			prevPath = filepath.Join(backupPath, name)
+		}
+	}
+
+	var key ed25519.PrivateKey
+	if keyPath := getSigningKeyPath(); keyPath != "" {
+		var created bool
+		key, created, err = integrity.LoadOrCreateKey(keyPath)
+		if err != nil {
+			return err
+		}
+		if created {
+			logger.Info("🔑 Generated new signing key: %s (keep a copy outside the backup disk)", keyPath)
+		}
+	}
+
+	_, err = integrity.Seal(filepath.Join(backupPath, snapName), prevPath, key)
+	return err
+}
+
+// sealUnsealedTail seals, in order, every snapshot after the last sealed one.
+// Older gaps are left alone: sealing them would break the links that follow.
+func sealUnsealedTail(backupPath string, snapshots []string) {
+	start := 0
+	for i, name := range snapshots {
+		if _, err := os.Stat(filepath.Join(backupPath, name, integrity.ChainFileName)); err == nil {
+			start = i + 1
+		}
+	}
+
+	for _, name := range snapshots[start:] {
+		if _, err := os.Stat(filepath.Join(backupPath, name, "index.json")); err != nil {
+			logger.Info("⚠️  Snapshot %s has no index.json. Stopping sealing. Run 'rebuild-index' first.", name)
+			return
+		}
+		if err := sealSnapshot(backupPath, name); err != nil {
+			logger.Error("Failed to seal %s: %v", name, err)
+			return
+		}
+		logger.Info("🔏 Sealed %s", name)
+	}
+}
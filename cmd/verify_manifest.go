@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google-photos-backup/internal/i18n"
+	"google-photos-backup/internal/integrity"
+	"google-photos-backup/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var verifyManifestCmd = &cobra.Command{
+	Use:           "verify-manifest [snapshot]",
+	Short:         "Verify a snapshot using only its SHA256SUMS file",
+	Long:          `Checks a snapshot (latest by default) against its SHA256SUMS manifest, exactly like 'sha256sum -c SHA256SUMS' would, and validates the manifest itself against SHA256SUMS.sha256. index.json is not used.`,
+	Args:          cobra.MaximumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupPath := getBackupPath()
+		if backupPath == "" {
+			logger.Error(i18n.T("update_backup_no_config"))
+			return failed(exitConfig, fmt.Errorf("backup_path is not set"))
+		}
+
+		var snapPath string
+		if len(args) == 1 {
+			snapPath = resolveSnapshotPath(backupPath, args[0])
+		} else {
+			snapPath = findLatestBackup(backupPath)
+			if snapPath == "" {
+				logger.Info("⚠️  No snapshots found.")
+				return nil
+			}
+		}
+
+		logger.Info("🔍 Verifying manifest of %s", filepath.Base(snapPath))
+
+		// 1. The manifest itself
+		actual, recorded, err := integrity.ManifestHash(snapPath)
+		if err != nil {
+			logger.Error("Cannot read %s: %v (run 'rebuild-index' to generate it)", integrity.SumsFileName, err)
+			return failed(exitIntegrity, err)
+		}
+		if recorded == "" {
+			// Without it a swapped manifest cannot be told apart from the sealed one
+			return logFailed(exitIntegrity, fmt.Errorf("%s is missing, the manifest cannot be trusted", integrity.SumsHashFileName))
+		}
+		if recorded != actual {
+			return logFailed(exitIntegrity, fmt.Errorf("%s has been modified (expected %s, got %s)", integrity.SumsFileName, recorded, actual))
+		}
+
+		entries, err := integrity.ReadSums(filepath.Join(snapPath, integrity.SumsFileName))
+		if err != nil {
+			return logFailed(exitIntegrity, fmt.Errorf("failed to parse %s: %w", integrity.SumsFileName, err))
+		}
+
+		// 2. Every file listed
+		okCount, bad, missing := 0, 0, 0
+		for _, e := range entries {
+			fullPath := filepath.Join(snapPath, filepath.FromSlash(e.RelPath))
+			got, err := calculateHash(fullPath)
+			if err != nil {
+				if os.IsNotExist(err) {
+					logger.Error("MISSING: %s", e.RelPath)
+					missing++
+				} else {
+					logger.Error("UNREADABLE: %s: %v", e.RelPath, err)
+					bad++
+				}
+				continue
+			}
+			if got != e.Hash {
+				logger.Error("FAILED: %s", e.RelPath)
+				bad++
+				continue
+			}
+			okCount++
+		}
+
+		if bad > 0 || missing > 0 {
+			return logFailed(exitIntegrity, fmt.Errorf("verification failed: %d OK, %d failed, %d missing (manifest %s)", okCount, bad, missing, actual))
+		}
+		logger.Info("✅ All %d files verified (manifest %s).", okCount, actual)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyManifestCmd)
+}
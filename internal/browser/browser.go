@@ -31,6 +31,12 @@ const (
 type Manager struct {
 	Browser *rod.Browser
 	DataDir string // Directory to save cookies and session
+
+	// BeforeDownload is called before each part is requested (e.g. free-space check).
+	// An error aborts DownloadFiles with that error.
+	BeforeDownload func(registry.DownloadFile) error
+
+	closeOnce sync.Once
 }
 
 // New creates a new browser manager instance
@@ -111,9 +117,12 @@ func New(userDataDir string, headless bool) *Manager {
 
 // Close closes the browser
 func (m *Manager) Close() {
-	if m.Browser != nil {
-		m.Browser.MustClose()
-	}
+	// Safe to call more than once, and from another goroutine on shutdown (no panic)
+	m.closeOnce.Do(func() {
+		if m.Browser != nil {
+			_ = m.Browser.Close()
+		}
+	})
 }
 
 // ManualLogin opens a page and waits for user to close browser
@@ -762,6 +771,16 @@ func (m *Manager) DownloadFiles(id string, files []registry.DownloadFile, destDi
 		clickFileWithCheck := func(fileIdx int) bool {
 			pNum := files[fileIdx].PartNumber
 
+			if m.BeforeDownload != nil {
+				if err := m.BeforeDownload(files[fileIdx]); err != nil {
+					select {
+					case errChan <- err:
+					default:
+					}
+					return false
+				}
+			}
+
 			// 1. Ensure we are on the correct page (recover from Auth redirects) and LANGUAGE is English
 			currentInfo, err := page.Info()
 			currentURL := "unknown"
@@ -0,0 +1,355 @@
+package catalog
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google-photos-backup/internal/processor"
+	"google-photos-backup/internal/registry"
+
+	_ "modernc.org/sqlite" // Pure Go driver, registered as "sqlite"
+)
+
+// catalog.go writes the SQLite catalog (see schema.go). Refreshes are incremental:
+// exports are mirrored from history.json every time, but a snapshot is only re-read when
+// its index.json changed since the last export.
+
+// Catalog is an open catalog database
+type Catalog struct {
+	db *sql.DB
+}
+
+// Export is one history entry with what the working directory still knows about it
+type Export struct {
+	registry.ExportEntry
+	Archives    []registry.DownloadFile // Empty once the download directory is gone
+	Extracted   map[string]bool         // Archive filename -> extracted
+	Incremental bool
+	Processed   bool
+}
+
+// Snapshot describes a snapshot directory
+type Snapshot struct {
+	Name          string
+	Path          string
+	TakenAt       time.Time
+	Kind          string // takeout or ingest
+	Source        string
+	AddedCount    int
+	AddedBytes    int64
+	Sealed        bool
+	DeletionAlert bool
+	Signature     string // Changes whenever index.json is rewritten
+}
+
+// Open opens (creating it if needed) the catalog at dbPath. A catalog written with
+// another schema version, or any catalog when rebuild is set, is emptied first.
+func Open(dbPath string, rebuild bool) (*Catalog, error) {
+	db, err := sql.Open("sqlite", "file:"+dbPath+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(10000)")
+	if err != nil {
+		return nil, err
+	}
+	// One connection: the pragmas above are per connection
+	db.SetMaxOpenConns(1)
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open %s: %w", dbPath, err)
+	}
+	if rebuild || (version != 0 && version != SchemaVersion) {
+		if _, err := db.Exec(dropSQL); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if _, err := db.Exec(schemaSQL); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Catalog{db: db}, nil
+}
+
+// Close closes the database
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+// SetInfo records a catalog_info value
+func (c *Catalog) SetInfo(key, value string) error {
+	_, err := c.db.Exec(`INSERT INTO catalog_info (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+// SyncExports makes the exports table mirror history.json. Archives are replaced for
+// exports whose state.json is still around and kept otherwise.
+func (c *Catalog) SyncExports(exports []Export) error {
+	return c.inTx(func(tx *sql.Tx) error {
+		ids := make([]interface{}, 0, len(exports))
+		for _, e := range exports {
+			ids = append(ids, e.ID)
+			_, err := tx.Exec(`INSERT INTO exports (id, status, requested_at, completed_at, download_mode, archive_count, total_size, new_photos_count, incremental, processed, error)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(id) DO UPDATE SET status = excluded.status, requested_at = excluded.requested_at,
+					completed_at = excluded.completed_at, download_mode = excluded.download_mode,
+					archive_count = excluded.archive_count, total_size = excluded.total_size,
+					new_photos_count = excluded.new_photos_count, incremental = excluded.incremental,
+					processed = excluded.processed, error = excluded.error`,
+				e.ID, string(e.Status), timeValue(e.RequestedAt), timeValue(e.CompletedAt), nullString(e.DownloadMode),
+				e.FileCount, nullString(e.TotalSize), e.NewPhotosCount, e.Incremental, e.Processed, nullString(e.Error))
+			if err != nil {
+				return err
+			}
+
+			if len(e.Archives) == 0 {
+				continue
+			}
+			if _, err := tx.Exec(`DELETE FROM archives WHERE export_id = ?`, e.ID); err != nil {
+				return err
+			}
+			for _, f := range e.Archives {
+				_, err := tx.Exec(`INSERT INTO archives (export_id, filename, part_number, size_bytes, status, extracted) VALUES (?, ?, ?, ?, ?, ?)`,
+					e.ID, f.Filename, f.PartNumber, f.SizeBytes, f.Status, e.Extracted[f.Filename])
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		query := `DELETE FROM exports`
+		if len(ids) > 0 {
+			query += ` WHERE id NOT IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		}
+		_, err := tx.Exec(query, ids...)
+		return err
+	})
+}
+
+// SnapshotSignature returns the index signature stored for a snapshot ("" if unknown)
+func (c *Catalog) SnapshotSignature(name string) (string, error) {
+	var signature string
+	err := c.db.QueryRow(`SELECT index_signature FROM snapshots WHERE name = ?`, name).Scan(&signature)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return signature, err
+}
+
+// UpdateSnapshot refreshes the fields of a snapshot that do not depend on its index
+func (c *Catalog) UpdateSnapshot(s Snapshot) error {
+	_, err := c.db.Exec(`UPDATE snapshots SET kind = ?, source = ?, added_count = ?, added_bytes = ?, sealed = ?, deletion_alert = ? WHERE name = ?`,
+		s.Kind, nullString(s.Source), s.AddedCount, s.AddedBytes, s.Sealed, s.DeletionAlert, s.Name)
+	return err
+}
+
+// PutSnapshot (re)loads a snapshot from its index: entries, albums, and the metadata of
+// contents not described yet, read from the sidecars in s.Path
+func (c *Catalog) PutSnapshot(s Snapshot, idx *registry.Index) error {
+	fileCount := 0
+	var totalBytes int64
+	jsonFiles := make(map[string]bool)
+	jsonByDir := make(map[string][]string)
+	err := idx.ForEach(func(relPath string, entry registry.FileIndexEntry) error {
+		fileCount++
+		totalBytes += entry.Size
+		if strings.EqualFold(filepath.Ext(relPath), ".json") {
+			full := filepath.Join(s.Path, relPath)
+			jsonFiles[full] = true
+			jsonByDir[filepath.Dir(full)] = append(jsonByDir[filepath.Dir(full)], filepath.Base(full))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM snapshots WHERE name = ?`, s.Name); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO snapshots (name, taken_at, kind, source, file_count, total_bytes, added_count, added_bytes, sealed, deletion_alert, index_signature)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			s.Name, timeValue(s.TakenAt), s.Kind, nullString(s.Source), fileCount, totalBytes, s.AddedCount, s.AddedBytes, s.Sealed, s.DeletionAlert, s.Signature)
+		if err != nil {
+			return err
+		}
+
+		insertEntry, err := tx.Prepare(`INSERT INTO snapshot_entries (snapshot, path, album, hash, size, mod_time, extension, media_type) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer insertEntry.Close()
+		described, err := tx.Prepare(`SELECT 1 FROM metadata WHERE hash = ? AND sidecar IS NOT NULL`)
+		if err != nil {
+			return err
+		}
+		defer described.Close()
+		upsertMeta, err := tx.Prepare(`INSERT INTO metadata (hash, taken_at, title, sidecar) VALUES (?, ?, ?, ?)
+			ON CONFLICT(hash) DO UPDATE SET taken_at = excluded.taken_at, title = excluded.title, sidecar = excluded.sidecar
+			WHERE metadata.sidecar IS NULL AND excluded.sidecar IS NOT NULL`)
+		if err != nil {
+			return err
+		}
+		defer upsertMeta.Close()
+
+		err = idx.ForEach(func(relPath string, entry registry.FileIndexEntry) error {
+			slashPath := filepath.ToSlash(relPath)
+			ext := strings.ToLower(path.Ext(slashPath))
+			mediaType := MediaType(ext)
+			album := path.Base(path.Dir(slashPath))
+			if album == "." {
+				album = ""
+			}
+			if _, err := insertEntry.Exec(s.Name, slashPath, album, entry.Hash, entry.Size, timeValue(entry.ModTime), ext, mediaType); err != nil {
+				return err
+			}
+
+			if mediaType != "photo" && mediaType != "video" {
+				return nil
+			}
+			var one int
+			if err := described.QueryRow(entry.Hash).Scan(&one); err == nil {
+				return nil
+			} else if err != sql.ErrNoRows {
+				return err
+			}
+
+			var takenAt, title, sidecar interface{}
+			full := filepath.Join(s.Path, relPath)
+			if jsonPath := processor.MatchSidecar(full, jsonFiles, jsonByDir); jsonPath != "" {
+				if rel, err := filepath.Rel(s.Path, jsonPath); err == nil {
+					sidecar = s.Name + "/" + filepath.ToSlash(rel)
+				}
+				if meta, err := processor.ReadSidecar(jsonPath); err == nil {
+					title = nullString(meta.Title)
+					if t, err := meta.TakenAt(); err == nil {
+						takenAt = timeValue(t)
+					}
+				}
+			}
+			_, err := upsertMeta.Exec(entry.Hash, takenAt, title, sidecar)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO albums (snapshot, name, file_count, total_bytes)
+			SELECT snapshot, album, count(*), sum(size) FROM snapshot_entries
+			WHERE snapshot = ? AND album != '' GROUP BY album`, s.Name)
+		return err
+	})
+}
+
+// RemoveSnapshotsExcept deletes the snapshots that are no longer on disk
+func (c *Catalog) RemoveSnapshotsExcept(names []string) (int, error) {
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+	}
+	rows, err := c.db.Query(`SELECT name FROM snapshots`)
+	if err != nil {
+		return 0, err
+	}
+	var gone []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !keep[name] {
+			gone = append(gone, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	err = c.inTx(func(tx *sql.Tx) error {
+		for _, name := range gone {
+			if _, err := tx.Exec(`DELETE FROM snapshots WHERE name = ?`, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(gone), err
+}
+
+// Finish rebuilds the per-content tables from the snapshot entries
+func (c *Catalog) Finish() error {
+	return c.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM files`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO files (hash, size, extension, media_type, first_snapshot, last_snapshot, snapshot_count, example_path)
+			SELECT hash, max(size), min(extension), min(media_type), min(snapshot), max(snapshot), count(DISTINCT snapshot), min(snapshot || '/' || path)
+			FROM snapshot_entries GROUP BY hash`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM metadata WHERE hash NOT IN (SELECT hash FROM files)`)
+		return err
+	})
+}
+
+// Stats returns the number of snapshots and unique contents in the catalog
+func (c *Catalog) Stats() (snapshots, files int, err error) {
+	if err = c.db.QueryRow(`SELECT count(*) FROM snapshots`).Scan(&snapshots); err != nil {
+		return
+	}
+	err = c.db.QueryRow(`SELECT count(*) FROM files`).Scan(&files)
+	return
+}
+
+// MediaType classifies a file by its (lower case) extension: photo, video, sidecar or other
+func MediaType(ext string) string {
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".heic", ".heif", ".webp", ".gif", ".bmp", ".tif", ".tiff",
+		".nef", ".cr2", ".cr3", ".orf", ".arw", ".dng", ".raf", ".rw2", ".srw", ".pef":
+		return "photo"
+	case ".mp4", ".mov", ".avi", ".3gp", ".mkv", ".m4v", ".wmv", ".mpg", ".mpeg", ".mts", ".webm":
+		return "video"
+	case ".json":
+		return "sidecar"
+	}
+	return "other"
+}
+
+func (c *Catalog) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// timeValue stores times as RFC 3339 UTC text, zero times as NULL
+func timeValue(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
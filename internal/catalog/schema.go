@@ -0,0 +1,113 @@
+package catalog
+
+// schema.go is the documented layout of the catalog database. It is derived data: when
+// SchemaVersion changes the tables are dropped and rebuilt on the next export.
+
+// SchemaVersion is stored in PRAGMA user_version
+const SchemaVersion = 1
+
+const schemaSQL = `
+-- catalog_info: facts about the catalog itself (backup_path, working_path, refreshed_at)
+CREATE TABLE IF NOT EXISTS catalog_info (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+
+-- exports: one row per export in history.json (Takeout or api-sync)
+CREATE TABLE IF NOT EXISTS exports (
+	id               TEXT PRIMARY KEY,
+	status           TEXT NOT NULL,               -- requested, ready, processed, expired, ...
+	requested_at     TEXT,                        -- RFC 3339 UTC
+	completed_at     TEXT,                        -- RFC 3339 UTC, NULL while not completed
+	download_mode    TEXT,
+	archive_count    INTEGER,
+	total_size       TEXT,                        -- As shown by Takeout ("50 GB")
+	new_photos_count INTEGER,
+	incremental      INTEGER NOT NULL DEFAULT 0,  -- 1 for api-sync exports
+	processed        INTEGER NOT NULL DEFAULT 0,  -- 1 once 'process' finished it
+	error            TEXT
+);
+
+-- archives: the zip/tgz parts of an export (downloads/<ID>/state.json). Kept after the
+-- download directory is cleaned up, removed with their export.
+CREATE TABLE IF NOT EXISTS archives (
+	export_id   TEXT NOT NULL REFERENCES exports(id) ON DELETE CASCADE,
+	filename    TEXT NOT NULL,
+	part_number INTEGER,
+	size_bytes  INTEGER,
+	status      TEXT,                             -- pending, downloading, completed, failed
+	extracted   INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (export_id, filename)
+);
+
+-- snapshots: one row per snapshot directory in backup_path
+CREATE TABLE IF NOT EXISTS snapshots (
+	name            TEXT PRIMARY KEY,             -- Directory name (YYYY-MM-DD-HHMMSS[...])
+	taken_at        TEXT,                         -- RFC 3339 UTC, from the name
+	kind            TEXT NOT NULL,                -- takeout or ingest
+	source          TEXT,                         -- From backup_log.jsonl
+	file_count      INTEGER NOT NULL,
+	total_bytes     INTEGER NOT NULL,
+	added_count     INTEGER,                      -- New files (backup_log.jsonl)
+	added_bytes     INTEGER,
+	sealed          INTEGER NOT NULL DEFAULT 0,   -- Has chain.json
+	deletion_alert  INTEGER NOT NULL DEFAULT 0,
+	index_signature TEXT NOT NULL                 -- Size and mtime of the index.json loaded
+);
+
+-- snapshot_entries: every file of every snapshot (from its index.json)
+CREATE TABLE IF NOT EXISTS snapshot_entries (
+	snapshot   TEXT NOT NULL REFERENCES snapshots(name) ON DELETE CASCADE,
+	path       TEXT NOT NULL,                     -- Relative to the snapshot, '/' separated
+	album      TEXT NOT NULL,                     -- Parent directory name, '' at the root
+	hash       TEXT NOT NULL,                     -- SHA-256 of the content
+	size       INTEGER NOT NULL,
+	mod_time   TEXT,                              -- RFC 3339 UTC
+	extension  TEXT NOT NULL,                     -- Lower case, with the dot
+	media_type TEXT NOT NULL,                     -- photo, video, sidecar or other
+	PRIMARY KEY (snapshot, path)
+);
+CREATE INDEX IF NOT EXISTS snapshot_entries_hash ON snapshot_entries(hash);
+CREATE INDEX IF NOT EXISTS snapshot_entries_album ON snapshot_entries(album);
+
+-- albums: files and bytes per album and snapshot
+CREATE TABLE IF NOT EXISTS albums (
+	snapshot    TEXT NOT NULL REFERENCES snapshots(name) ON DELETE CASCADE,
+	name        TEXT NOT NULL,
+	file_count  INTEGER NOT NULL,
+	total_bytes INTEGER NOT NULL,
+	PRIMARY KEY (snapshot, name)
+);
+
+-- files: one row per unique content across all snapshots
+CREATE TABLE IF NOT EXISTS files (
+	hash           TEXT PRIMARY KEY,
+	size           INTEGER NOT NULL,
+	extension      TEXT NOT NULL,
+	media_type     TEXT NOT NULL,
+	first_snapshot TEXT NOT NULL,
+	last_snapshot  TEXT NOT NULL,
+	snapshot_count INTEGER NOT NULL,
+	example_path   TEXT NOT NULL                  -- <snapshot>/<path> of one copy
+);
+
+-- metadata: capture date and title of each photo/video content, from its Google sidecar
+CREATE TABLE IF NOT EXISTS metadata (
+	hash     TEXT PRIMARY KEY,
+	taken_at TEXT,                                -- RFC 3339 UTC, NULL when unknown
+	title    TEXT,
+	sidecar  TEXT                                 -- <snapshot>/<path> of the sidecar, NULL when none
+);
+`
+
+// dropSQL removes every table, for rebuilds
+const dropSQL = `
+DROP TABLE IF EXISTS metadata;
+DROP TABLE IF EXISTS files;
+DROP TABLE IF EXISTS albums;
+DROP TABLE IF EXISTS snapshot_entries;
+DROP TABLE IF EXISTS snapshots;
+DROP TABLE IF EXISTS archives;
+DROP TABLE IF EXISTS exports;
+DROP TABLE IF EXISTS catalog_info;
+`
@@ -1,29 +1,59 @@
 package config
 
 import (
+	"fmt"
 	"google-photos-backup/internal/i18n"
 	"google-photos-backup/internal/logger"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	UserID               string        `mapstructure:"user_id"`
-	WorkingPath          string        `mapstructure:"working_path"`
-	IndexPath            string        `mapstructure:"index_path"`
-	ClientID             string        `mapstructure:"client_id"`
-	ClientSecret         string        `mapstructure:"client_secret"`
-	TokenPath            string        `mapstructure:"token_path"`
-	BackupFrequency      time.Duration `mapstructure:"backup_frequency"`
-	DownloadMode         string        `mapstructure:"download_mode"`          // "directDownload" or "driveDownload"
-	FixAmbiguousMetadata string        `mapstructure:"fix_ambiguous_metadata"` // "yes", "no", "interactive"
-	BackupPath           string        `mapstructure:"backup_path"`            // Where to store the final organized photos
-	ImmichMasterEnabled  bool          `mapstructure:"immich_master_enabled"`  // Whether to maintain a master directory for Immich
-	ImmichMasterPath     string        `mapstructure:"immich_master_path"`     // Relative path for Immich master directory
+	UserID               string             `mapstructure:"user_id"`
+	WorkingPath          string             `mapstructure:"working_path"`
+	IndexPath            string             `mapstructure:"index_path"`
+	ClientID             string             `mapstructure:"client_id"`
+	ClientSecret         string             `mapstructure:"client_secret"`
+	TokenPath            string             `mapstructure:"token_path"`
+	BackupFrequency      time.Duration      `mapstructure:"backup_frequency"`
+	DownloadMode         string             `mapstructure:"download_mode"`          // "directDownload" or "driveDownload"
+	FixAmbiguousMetadata string             `mapstructure:"fix_ambiguous_metadata"` // "yes", "no", "interactive"
+	BackupPath           string             `mapstructure:"backup_path"`            // Where to store the final organized photos
+	ImmichMasterEnabled  bool               `mapstructure:"immich_master_enabled"`  // Whether to maintain a master directory for Immich
+	ImmichMasterPath     string             `mapstructure:"immich_master_path"`     // Relative path for Immich master directory
+	DeletionAlertRatio   float64            `mapstructure:"deletion_alert_ratio"`   // Alert if more than this fraction of files disappears upstream
+	SigningKeyPath       string             `mapstructure:"signing_key_path"`       // Ed25519 key (PEM) used to sign the snapshot chain
+	StorageBackend       string             `mapstructure:"storage_backend"`        // "hardlink" (default) or "objects"
+	StoreViewMode        string             `mapstructure:"store_view_mode"`        // How "objects" views are materialised (a link strategy)
+	LinkStrategy         string             `mapstructure:"link_strategy"`          // "hardlink", "symlink", "reflink" or "copy"
+	Accounts             map[string]Account `mapstructure:"accounts"`               // Named Google account profiles
+	DefaultAccount       string             `mapstructure:"default_account"`        // Account used when --account is not given
+	ImmichMasterMode     string             `mapstructure:"immich_master_mode"`     // With accounts: "account" (one master each) or "merged"
+	PhotosAPIURL         string             `mapstructure:"photos_api_url"`         // Library API base URL (api-sync)
+	OAuthAuthURL         string             `mapstructure:"oauth_auth_url"`         // OAuth endpoints (overridable for a stub server)
+	OAuthTokenURL        string             `mapstructure:"oauth_token_url"`
+	OAuthDeviceURL       string             `mapstructure:"oauth_device_url"`
+	MinFreeSpace         string             `mapstructure:"min_free_space"`       // Reserve kept free on every target disk, e.g. "5 GB"
+	DiskFullAction       string             `mapstructure:"disk_full_action"`     // "abort" (default) or "pause" until space is freed
+	DaemonQuietHours     string             `mapstructure:"daemon_quiet_hours"`   // Daily windows without runs, e.g. "23:00-07:00,12:00-13:00"
+	DaemonPollInterval   time.Duration      `mapstructure:"daemon_poll_interval"` // First delay between checks of an export being prepared
+	DaemonPollMax        time.Duration      `mapstructure:"daemon_poll_max"`      // Backoff limit of that delay
+	DaemonSocket         string             `mapstructure:"daemon_socket"`        // Status socket, defaults to <working_path>/daemon.sock
+}
+
+// Account is a Google account profile. Each one has its own browser profile, history.json,
+// downloads and snapshots; content is deduplicated across accounts.
+type Account struct {
+	UserID      string `mapstructure:"user_id"`
+	WorkingPath string `mapstructure:"working_path"` // Defaults to <working_path>/accounts/<name>
+	BackupPath  string `mapstructure:"backup_path"`  // Defaults to <backup_path>/accounts/<name>
+	TokenPath   string `mapstructure:"token_path"`   // Defaults to <account working_path>/token.json
 }
 
 const (
@@ -33,6 +63,12 @@ const (
 
 var AppConfig Config
 
+// ActiveAccount is the account selected with --account (or default_account), "" if none
+var ActiveAccount string
+
+// SharedBackupPath is the top-level backup_path: common object store and merged Immich master
+var SharedBackupPath string
+
 func InitConfig() {
 	// 1. Define config filename
 	viper.SetConfigName("config")
@@ -58,6 +94,20 @@ func InitConfig() {
 	viper.SetDefault("backup_path", "") // Empty by default
 	viper.SetDefault("immich_master_enabled", false)
 	viper.SetDefault("immich_master_path", "immich-master")
+	viper.SetDefault("deletion_alert_ratio", 0.05)
+	viper.SetDefault("storage_backend", "hardlink")
+	viper.SetDefault("store_view_mode", "hardlink")
+	viper.SetDefault("link_strategy", "hardlink")
+	viper.SetDefault("immich_master_mode", "account")
+	viper.SetDefault("photos_api_url", "https://photoslibrary.googleapis.com")
+	viper.SetDefault("oauth_auth_url", "https://accounts.google.com/o/oauth2/v2/auth")
+	viper.SetDefault("oauth_token_url", "https://oauth2.googleapis.com/token")
+	viper.SetDefault("oauth_device_url", "https://oauth2.googleapis.com/device/code")
+	viper.SetDefault("min_free_space", "2 GB")
+	viper.SetDefault("disk_full_action", "abort")
+	viper.SetDefault("daemon_quiet_hours", "")
+	viper.SetDefault("daemon_poll_interval", "30m")
+	viper.SetDefault("daemon_poll_max", "6h")
 
 	// Define default path for token inside config directory
 	if home, err := os.UserHomeDir(); err == nil {
@@ -80,3 +130,68 @@ func InitConfig() {
 		logger.Error(i18n.T("config_decode_error"), err)
 	}
 }
+
+// SelectAccount points WorkingPath/BackupPath/UserID at the given account profile.
+// An empty name selects default_account, or keeps the single-account layout if unset.
+func SelectAccount(name string) error {
+	SharedBackupPath = AppConfig.BackupPath
+
+	// Accounts without settings ("family: {}") are dropped by Unmarshal
+	for accountName := range viper.GetStringMap("accounts") {
+		if _, ok := AppConfig.Accounts[accountName]; !ok {
+			if AppConfig.Accounts == nil {
+				AppConfig.Accounts = make(map[string]Account)
+			}
+			AppConfig.Accounts[accountName] = Account{}
+		}
+	}
+
+	if name == "" {
+		name = AppConfig.DefaultAccount
+	}
+	if name == "" {
+		return nil
+	}
+
+	name = strings.ToLower(name) // Viper lowercases map keys
+	account, ok := AppConfig.Accounts[name]
+	if !ok {
+		return fmt.Errorf("unknown account %q (configured: %s)", name, strings.Join(AccountNames(), ", "))
+	}
+	ActiveAccount = name
+
+	if account.WorkingPath == "" {
+		account.WorkingPath = filepath.Join(AppConfig.WorkingPath, "accounts", name)
+	}
+	if account.BackupPath == "" && AppConfig.BackupPath != "" {
+		account.BackupPath = filepath.Join(AppConfig.BackupPath, "accounts", name)
+	}
+	AppConfig.WorkingPath = account.WorkingPath
+	AppConfig.BackupPath = account.BackupPath
+	if account.TokenPath == "" {
+		account.TokenPath = filepath.Join(account.WorkingPath, "token.json")
+	}
+	AppConfig.TokenPath = account.TokenPath
+	if account.UserID != "" {
+		AppConfig.UserID = account.UserID
+	}
+	return nil
+}
+
+// AccountNames returns the configured account names, sorted
+func AccountNames() []string {
+	names := make([]string, 0, len(AppConfig.Accounts))
+	for name := range AppConfig.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AccountKey returns the config key holding key for the active account
+func AccountKey(key string) string {
+	if ActiveAccount == "" {
+		return key
+	}
+	return "accounts." + ActiveAccount + "." + key
+}
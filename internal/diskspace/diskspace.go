@@ -0,0 +1,225 @@
+package diskspace
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"google-photos-backup/internal/browser"
+	"google-photos-backup/internal/logger"
+)
+
+// diskspace.go checks free space before and during the steps that write a lot of data
+// (download, extraction, cross-device backup). Needs are grouped per filesystem so two
+// targets on the same disk are added up, and a configurable reserve is always kept free.
+
+// Free returns the bytes available to the user on the filesystem holding path. The path
+// does not need to exist yet: its closest existing parent is used.
+func Free(path string) (int64, error) {
+	dir, err := existingParent(path)
+	if err != nil {
+		return 0, err
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// SameDevice reports whether a and b (or their closest existing parents) are on the same filesystem
+func SameDevice(a, b string) bool {
+	da, errA := device(a)
+	db, errB := device(b)
+	return errA == nil && errB == nil && da == db
+}
+
+// InsufficientError reports a filesystem without room for the planned writes
+type InsufficientError struct {
+	Path    string
+	What    []string
+	Need    int64
+	Free    int64
+	Reserve int64
+}
+
+func (e *InsufficientError) Error() string {
+	return fmt.Sprintf("not enough free space on %s for %s: need %s + %s reserve, %s free",
+		e.Path, strings.Join(e.What, ", "), browser.FormatSize(e.Need), browser.FormatSize(e.Reserve), browser.FormatSize(e.Free))
+}
+
+// Planner collects the writes an operation is about to make
+type Planner struct {
+	Reserve int64 // Bytes that must stay free on every filesystem
+	needs   []need
+}
+
+type need struct {
+	path  string
+	bytes int64
+	what  string
+}
+
+// Add plans bytes to be written below path
+func (p *Planner) Add(path string, bytes int64, what string) {
+	if bytes < 0 {
+		bytes = 0
+	}
+	p.needs = append(p.needs, need{path: path, bytes: bytes, what: what})
+}
+
+// Check verifies every filesystem involved; the first one short of space is returned
+func (p *Planner) Check() error {
+	type group struct {
+		path  string
+		bytes int64
+		what  []string
+	}
+	groups := make(map[uint64]*group)
+	var order []uint64
+	for _, n := range p.needs {
+		dev, err := device(n.path)
+		if err != nil {
+			return err
+		}
+		g, ok := groups[dev]
+		if !ok {
+			g = &group{path: n.path}
+			groups[dev] = g
+			order = append(order, dev)
+		}
+		g.bytes += n.bytes
+		g.what = append(g.what, n.what)
+	}
+
+	for _, dev := range order {
+		g := groups[dev]
+		free, err := Free(g.path)
+		if err != nil {
+			return err
+		}
+		if free-g.bytes < p.Reserve {
+			return &InsufficientError{Path: g.path, What: g.what, Need: g.bytes, Free: free, Reserve: p.Reserve}
+		}
+	}
+	return nil
+}
+
+// Guard re-checks one target during a long operation
+type Guard struct {
+	Path     string
+	Reserve  int64
+	Pause    bool          // Wait for space to be freed instead of failing
+	Interval time.Duration // Poll interval while paused (default 30s)
+	What     string        // Shown in messages, e.g. "downloads"
+}
+
+// Ensure returns nil when bytes more can be written to Path while keeping the reserve.
+// Otherwise it fails with an InsufficientError, or with Pause waits until space is freed
+// or ctx is cancelled (ctx.Err() is returned; a nil ctx waits for space only).
+func (g *Guard) Ensure(ctx context.Context, bytes int64) error {
+	if g == nil {
+		return nil
+	}
+	p := Planner{Reserve: g.Reserve}
+	p.Add(g.Path, bytes, g.What)
+	err := p.Check()
+	if err == nil || !g.Pause {
+		return err
+	}
+	if _, ok := err.(*InsufficientError); !ok {
+		return err
+	}
+
+	interval := g.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	logger.Info("⏸️  Paused: %v. Free some space to continue (Ctrl+C to stop).", err)
+	for {
+		select {
+		case <-done:
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		if err := p.Check(); err == nil {
+			logger.Info("▶️  Enough free space again, resuming.")
+			return nil
+		} else if _, ok := err.(*InsufficientError); !ok {
+			return err
+		}
+	}
+}
+
+// TreeSize returns the total size of the regular files below root
+func TreeSize(root string) int64 {
+	var total int64
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// Usage returns the disk space used by the regular files below root, counting files
+// hardlinked several times (snapshots) once, like du
+func Usage(root string) int64 {
+	var total int64
+	seen := make(map[[2]uint64]bool)
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Nlink > 1 {
+			key := [2]uint64{uint64(st.Dev), uint64(st.Ino)}
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+		total += info.Size()
+		return nil
+	})
+	return total
+}
+
+func device(path string) (uint64, error) {
+	dir, err := existingParent(path)
+	if err != nil {
+		return 0, err
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Dev), nil
+}
+
+func existingParent(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(abs); err == nil {
+			return abs, nil
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return "", fmt.Errorf("no existing parent for %s", path)
+		}
+		abs = parent
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package fsutil
+
+import "golang.org/x/sys/unix"
+
+// Filesystems without hardlinks (statfs f_type)
+const (
+	msdosSuperMagic = 0x4d44     // FAT12/16/32 (vfat)
+	exfatSuperMagic = 0x2011bab0 // exFAT
+)
+
+// hasHardlinks reports whether the filesystem holding dir supports hardlinks. When it
+// cannot be determined it answers true, so a refusal is reported rather than hidden.
+func hasHardlinks(dir string) bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return true
+	}
+	switch uint32(st.Type) {
+	case msdosSuperMagic, exfatSuperMagic:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,250 @@
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"google-photos-backup/internal/logger"
+)
+
+// link.go is the single place where the tool makes one file share another's content.
+// The strategy is chosen in config (link_strategy); when it cannot be applied (other
+// filesystem, link limit, no reflink support) the next one in the chain is used.
+
+// Strategy is a way to make dst show the content of src
+type Strategy string
+
+const (
+	StrategyHardlink Strategy = "hardlink" // Same inode; needs same filesystem, limited link count
+	StrategySymlink  Strategy = "symlink"  // Relative symlink; breaks if the target is deleted
+	StrategyReflink  Strategy = "reflink"  // Copy-on-write clone (FICLONE: btrfs, XFS, ...)
+	StrategyCopy     Strategy = "copy"     // Independent full copy
+)
+
+// ParseStrategy validates a link_strategy config value ("" means hardlink)
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case "":
+		return StrategyHardlink, nil
+	case StrategyHardlink, StrategySymlink, StrategyReflink, StrategyCopy:
+		return Strategy(s), nil
+	}
+	return "", fmt.Errorf("unknown link strategy %q (hardlink, symlink, reflink, copy)", s)
+}
+
+// Linker applies a strategy with automatic fallback
+type Linker struct {
+	Strategy Strategy
+	// SplitOnLimit returns EMLINK to the caller instead of falling back, so it can start
+	// a new inode generation for the content (see IsLinkLimit).
+	SplitOnLimit bool
+}
+
+// New returns a Linker for the given strategy
+func New(s Strategy) *Linker {
+	return &Linker{Strategy: s}
+}
+
+var defaultLinker = New(StrategyHardlink)
+
+// SetDefault sets the linker returned by Default (configured at startup)
+func SetDefault(l *Linker) {
+	defaultLinker = l
+}
+
+// Default returns the configured linker
+func Default() *Linker {
+	return defaultLinker
+}
+
+// chain returns the strategies to try, in order. Symlinks are never an automatic
+// fallback: a backup must not silently depend on another snapshot staying around.
+func (l *Linker) chain() []Strategy {
+	switch l.Strategy {
+	case StrategySymlink:
+		return []Strategy{StrategySymlink, StrategyCopy}
+	case StrategyReflink:
+		return []Strategy{StrategyReflink, StrategyCopy}
+	case StrategyCopy:
+		return []Strategy{StrategyCopy}
+	}
+	return []Strategy{StrategyHardlink, StrategyReflink, StrategyCopy}
+}
+
+// Link creates dst (which must not exist) with the content of src and returns the
+// strategy that was actually used.
+func (l *Linker) Link(src, dst string) (Strategy, error) {
+	var lastErr error
+	for _, s := range l.chain() {
+		err := apply(s, src, dst)
+		if err == nil {
+			return s, nil
+		}
+		if !canFallBack(s, err, dst) || (l.SplitOnLimit && IsLinkLimit(err)) {
+			return s, err
+		}
+		logFallback(s, err, dst)
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// Replace atomically replaces dst with a link to src: the link is created next to dst
+// and renamed over it, so a failure never leaves dst missing.
+func (l *Linker) Replace(src, dst string) (Strategy, error) {
+	tmp := dst + ".linktmp"
+	os.Remove(tmp)
+	used, err := l.Link(src, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return used, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return used, err
+	}
+	return used, nil
+}
+
+// IsLinked reports whether dst already shares src's content the way this strategy would
+// do it. Reflinks and copies cannot be told apart from independent files.
+func (l *Linker) IsLinked(src, dst string) bool {
+	switch l.Strategy {
+	case StrategySymlink:
+		info, err := os.Lstat(dst)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return false
+		}
+		return sameFile(os.Stat, src, dst)
+	case StrategyHardlink:
+		return sameFile(os.Lstat, src, dst)
+	}
+	return false
+}
+
+// SharesStorage reports whether linking saves space (everything but plain copies)
+func (l *Linker) SharesStorage() bool {
+	return l.Strategy != StrategyCopy
+}
+
+// Splitting returns a copy of the linker that reports link limits instead of copying
+func (l *Linker) Splitting() *Linker {
+	split := *l
+	split.SplitOnLimit = true
+	return &split
+}
+
+// IsLinkLimit reports whether err is the filesystem's maximum hardlink count (ext4: 65000)
+func IsLinkLimit(err error) bool {
+	return errors.Is(err, syscall.EMLINK)
+}
+
+// IsFallbackError reports whether err means "this strategy is not possible here"
+// rather than a real failure (missing source, permissions on the directory, ...).
+func IsFallbackError(err error) bool {
+	return errors.Is(err, syscall.EXDEV) ||
+		errors.Is(err, syscall.EMLINK) ||
+		errors.Is(err, syscall.EOPNOTSUPP) ||
+		errors.Is(err, syscall.ENOTSUP) ||
+		errors.Is(err, syscall.ENOTTY) ||
+		errors.Is(err, syscall.ENOSYS) ||
+		errors.Is(err, errors.ErrUnsupported)
+}
+
+// canFallBack reports whether the next strategy may be tried after s failed with err.
+// EPERM from a hardlink is also a permission problem or a protected_hardlinks refusal,
+// so it only counts when the filesystem of dst really has no hardlinks (FAT, exFAT).
+// EINVAL only means "unsupported" for the FICLONE ioctl.
+func canFallBack(s Strategy, err error, dst string) bool {
+	switch {
+	case IsFallbackError(err):
+		return true
+	case s == StrategyHardlink && errors.Is(err, syscall.EPERM):
+		return !hasHardlinks(filepath.Dir(dst))
+	case s == StrategyReflink && errors.Is(err, syscall.EINVAL):
+		return true
+	}
+	return false
+}
+
+// fallbackLogged holds the strategy/reason pairs already reported at Info level
+var fallbackLogged sync.Map
+
+// logFallback reports a fallback: once per strategy and reason at Info level (a copy
+// takes real space), then every file at Debug level
+func logFallback(s Strategy, err error, dst string) {
+	reason := err
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	switch {
+	case errors.As(err, &linkErr):
+		reason = linkErr.Err
+	case errors.As(err, &pathErr):
+		reason = pathErr.Err
+	}
+	if _, seen := fallbackLogged.LoadOrStore(string(s)+": "+reason.Error(), true); !seen {
+		logger.Info("⚠️  Cannot use %s links here (%v): falling back to the next link strategy", s, reason)
+	}
+	logger.Debug("%s link for %s failed (%v), falling back", s, dst, err)
+}
+
+func apply(s Strategy, src, dst string) error {
+	switch s {
+	case StrategyHardlink:
+		return os.Link(src, dst)
+	case StrategySymlink:
+		rel, err := filepath.Rel(filepath.Dir(dst), src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(rel, dst)
+	case StrategyReflink:
+		return reflink(src, dst)
+	}
+	return CopyFile(src, dst)
+}
+
+// CopyFile copies src to dst preserving permissions and modification time
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+func sameFile(stat func(string) (os.FileInfo, error), a, b string) bool {
+	fa, err := stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fa, fb)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fsutil
+
+import "errors"
+
+// reflink is only implemented on Linux (FICLONE); elsewhere the linker falls back to a copy
+func reflink(src, dst string) error {
+	return errors.ErrUnsupported
+}
@@ -213,6 +213,10 @@ var messages = map[string]map[string]string{
 		"en": "⚠️  Ignoring expired export (previous Quota Exceeded): %s",
 		"es": "⚠️  Ignorando exportación expirada (Quota Exceeded previo): %s",
 	},
+	"ignoring_handled": {
+		"en": "   - Export %s is already %s, not downloading it again.",
+		"es": "   - La exportación %s ya está %s, no se vuelve a descargar.",
+	},
 	"export_too_old": {
 		"en": "⚠️  Export is too old (%v). It will be cancelled.",
 		"es": "⚠️  La exportación lleva demasiado tiempo (%v). Se cancelará.",
@@ -261,6 +265,10 @@ var messages = map[string]map[string]string{
 		"en": "   Files: %d | Size: %s | New photos: %d",
 		"es": "   Archivos: %d | Tamaño: %s | Nuevas fotos: %d",
 	},
+	"last_not_backed_up": {
+		"en": "⚠️ Export %s is downloaded but not backed up yet (%s). Run 'process' and 'update-backup' to finish it.",
+		"es": "⚠️ La exportación %s está descargada pero aún no respaldada (%s). Ejecuta 'process' y 'update-backup' para completarla.",
+	},
 	"next_backup": {
 		"en": "⏳ Too early for new backup (Freq: %s). Next: %s",
 		"es": "⏳ No toca nueva copia todavía (Frecuencia: %s). Próxima: %s",
@@ -477,6 +485,10 @@ var messages = map[string]map[string]string{
 		"en": "   🔗 Internal hardlinks preserved: %d",
 		"es": "   🔗 Hardlinks internos preservados: %d",
 	},
+	"update_backup_summary_copied": {
+		"en": "   📄 Copied because they could not be linked: %d",
+		"es": "   📄 Copiados por no poder enlazarse: %d",
+	},
 	"update_backup_summary_exports": {
 		"en": "   📦 Exports Processed: %d",
 		"es": "   📦 Exportaciones Procesadas: %d",
@@ -537,10 +549,6 @@ var messages = map[string]map[string]string{
 		"en": "🧹 Removed %d incomplete/ghost entries from history.",
 		"es": "🧹 Eliminadas %d entradas incompletas/fantasma del historial.",
 	},
-	"sync_migrate_fail": {
-		"en": "❌ Failed to migrate state: %v",
-		"es": "❌ Fallo al migrar estado: %v",
-	},
 	"sync_found_completed": {
 		"en": "✅ Found completed file: %s (Size: %s)",
 		"es": "✅ Encontrado fichero completado: %s (Tamaño: %s)",
@@ -0,0 +1,292 @@
+package integrity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google-photos-backup/internal/persist"
+	"google-photos-backup/internal/registry"
+)
+
+// chain.go links every snapshot to the previous one: each snapshot gets a chain.json
+// recording the hash of its index and the hash of the previous snapshot's chain.json,
+// optionally signed with a local Ed25519 key. Rewriting an old snapshot breaks the chain.
+
+const ChainFileName = "chain.json"
+
+// ChainLink is the content of a snapshot's chain.json
+type ChainLink struct {
+	Snapshot     string    `json:"snapshot"`
+	SealedAt     time.Time `json:"sealed_at"`
+	IndexHash    string    `json:"index_sha256"` // Raw index.json file
+	IndexDigest  string    `json:"index_digest"` // Canonical path/hash/size/mtime listing (inode independent)
+	FileCount    int       `json:"file_count"`
+	PrevSnapshot string    `json:"prev_snapshot,omitempty"`    // Previous sealed snapshot
+	PrevLinkHash string    `json:"prev_link_sha256,omitempty"` // Hash of the previous chain.json
+	PublicKey    string    `json:"public_key,omitempty"`       // Base64 Ed25519 public key
+	Signature    string    `json:"signature,omitempty"`        // Base64 signature of the link without this field
+}
+
+// IndexDigest hashes the parts of an index that describe content: path, hash, size and mtime.
+// Inodes are left out because fix-hardlinks legitimately changes them.
+func IndexDigest(idx *registry.Index) (string, error) {
+	h := sha256.New()
+	err := idx.ForEach(func(relPath string, e registry.FileIndexEntry) error {
+		fmt.Fprintf(h, "%s %d %d %s\n", e.Hash, e.Size, e.ModTime.UnixNano(), filepath.ToSlash(relPath))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// LoadLink reads the chain.json of a snapshot
+func LoadLink(snapshotPath string) (*ChainLink, error) {
+	data, err := os.ReadFile(filepath.Join(snapshotPath, ChainFileName))
+	if err != nil {
+		return nil, err
+	}
+	var link ChainLink
+	if err := json.Unmarshal(data, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// LinkHash returns the SHA-256 of a snapshot's chain.json as stored on disk
+func LinkHash(snapshotPath string) (string, error) {
+	return fileHash(filepath.Join(snapshotPath, ChainFileName))
+}
+
+// Seal writes chain.json for snapshotPath, chaining it to prevSnapshotPath (may be empty for
+// the first snapshot). If key is nil the link is only hash-chained, not signed.
+func Seal(snapshotPath, prevSnapshotPath string, key ed25519.PrivateKey) (*ChainLink, error) {
+	indexPath := filepath.Join(snapshotPath, "index.json")
+	idx, err := registry.LoadIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Close()
+	indexHash, err := fileHash(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := IndexDigest(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	link := &ChainLink{
+		Snapshot:    filepath.Base(snapshotPath),
+		SealedAt:    time.Now().UTC(),
+		IndexHash:   indexHash,
+		IndexDigest: digest,
+		FileCount:   idx.Len(),
+	}
+
+	if prevSnapshotPath != "" {
+		prevHash, err := LinkHash(prevSnapshotPath)
+		if err != nil {
+			return nil, fmt.Errorf("previous snapshot %s is not sealed: %w", filepath.Base(prevSnapshotPath), err)
+		}
+		link.PrevSnapshot = filepath.Base(prevSnapshotPath)
+		link.PrevLinkHash = prevHash
+	}
+
+	if key != nil {
+		link.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+		payload, err := signingPayload(link)
+		if err != nil {
+			return nil, err
+		}
+		link.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	}
+
+	data, err := json.MarshalIndent(link, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := persist.WriteFile(filepath.Join(snapshotPath, ChainFileName), data, 0644); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// VerifyLink checks a snapshot against its chain.json. prevName/prevHash describe the
+// previous sealed snapshot as found on disk; pub (optional) is the trusted public key and
+// signedBefore tells whether an earlier link of the chain was signed. With a trusted key,
+// an unsigned link after a signed one breaks the chain (signatures cannot be stripped);
+// unsigned links sealed before the key was set up are only a warning.
+// Problems are returned as human readable strings; warnings do not break the chain.
+func VerifyLink(snapshotPath string, link *ChainLink, prevName, prevHash string, pub ed25519.PublicKey, signedBefore bool) (problems []string, warnings []string) {
+	if link.Snapshot != filepath.Base(snapshotPath) {
+		problems = append(problems, fmt.Sprintf("link belongs to snapshot %s", link.Snapshot))
+	}
+
+	// 1. Chain continuity
+	if link.PrevSnapshot != prevName {
+		if prevName == "" {
+			problems = append(problems, fmt.Sprintf("previous snapshot %s is missing or unsealed", link.PrevSnapshot))
+		} else {
+			problems = append(problems, fmt.Sprintf("chained to %q but previous sealed snapshot is %q", link.PrevSnapshot, prevName))
+		}
+	} else if link.PrevLinkHash != prevHash {
+		problems = append(problems, fmt.Sprintf("previous chain.json (%s) has been modified", prevName))
+	}
+
+	// 2. Signature
+	if link.Signature != "" {
+		keyBytes, err := base64.StdEncoding.DecodeString(link.PublicKey)
+		sig, sigErr := base64.StdEncoding.DecodeString(link.Signature)
+		payload, payloadErr := signingPayload(link)
+		switch {
+		case err != nil || len(keyBytes) != ed25519.PublicKeySize || sigErr != nil || payloadErr != nil:
+			problems = append(problems, "malformed signature or public key")
+		case pub != nil && !pub.Equal(ed25519.PublicKey(keyBytes)):
+			problems = append(problems, "signed with an unknown key")
+		case !ed25519.Verify(ed25519.PublicKey(keyBytes), payload, sig):
+			problems = append(problems, "invalid signature")
+		}
+	} else if pub != nil && signedBefore {
+		problems = append(problems, "link is not signed but earlier links are")
+	} else if pub != nil {
+		warnings = append(warnings, "link is not signed")
+	}
+
+	// 3. Index
+	indexPath := filepath.Join(snapshotPath, "index.json")
+	idx, err := registry.LoadIndex(indexPath)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("cannot read index.json: %v", err))
+		return problems, warnings
+	}
+	defer idx.Close()
+	digest, err := IndexDigest(idx)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("cannot read index.json: %v", err))
+		return problems, warnings
+	}
+	if digest != link.IndexDigest {
+		problems = append(problems, "index.json content no longer matches the sealed index")
+	} else if h, err := fileHash(indexPath); err == nil && h != link.IndexHash {
+		warnings = append(warnings, "index.json was rewritten (inodes changed) but describes the same content")
+	}
+	return problems, warnings
+}
+
+// VerifyContent rehashes every file of the snapshot against its index.json
+func VerifyContent(snapshotPath string) []string {
+	idx, err := registry.LoadIndex(filepath.Join(snapshotPath, "index.json"))
+	if err != nil {
+		return []string{fmt.Sprintf("cannot read index.json: %v", err)}
+	}
+	defer idx.Close()
+
+	var problems []string
+	err = idx.ForEach(func(relPath string, entry registry.FileIndexEntry) error {
+		got, err := fileHash(filepath.Join(snapshotPath, relPath))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", relPath, err))
+		} else if got != entry.Hash {
+			problems = append(problems, fmt.Sprintf("%s: content modified", relPath))
+		}
+		return nil
+	})
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("cannot read index.json: %v", err))
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// LoadOrCreateKey loads an Ed25519 private key (PKCS#8 PEM) from path, generating
+// and saving a new one with 0600 permissions if the file does not exist.
+func LoadOrCreateKey(path string) (ed25519.PrivateKey, bool, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := parsePrivateKey(data)
+		return key, false, err
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, false, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// LoadPublicKey returns the public half of the key stored at path
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return key.Public().(ed25519.PublicKey), nil
+}
+
+func parsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || !strings.Contains(block.Type, "PRIVATE KEY") {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key is not Ed25519")
+	}
+	return key, nil
+}
+
+// signingPayload is the JSON of the link without its signature
+func signingPayload(link *ChainLink) ([]byte, error) {
+	unsigned := *link
+	unsigned.Signature = ""
+	return json.Marshal(unsigned)
+}
+
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
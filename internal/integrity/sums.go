@@ -0,0 +1,134 @@
+package integrity
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google-photos-backup/internal/persist"
+	"google-photos-backup/internal/registry"
+)
+
+// sums.go writes and reads SHA256SUMS manifests in the format understood by `sha256sum -c`,
+// so a snapshot can be verified with standard tools and without this binary.
+
+const (
+	SumsFileName     = "SHA256SUMS"
+	SumsHashFileName = "SHA256SUMS.sha256" // Hash of SHA256SUMS itself, also in sha256sum format
+)
+
+// SumEntry is one line of a SHA256SUMS file
+type SumEntry struct {
+	Hash    string
+	RelPath string
+}
+
+// RenderSums renders the index as SHA256SUMS content, sorted by path
+func RenderSums(idx *registry.Index) ([]byte, error) {
+	var b strings.Builder
+	err := idx.ForEach(func(relPath string, entry registry.FileIndexEntry) error {
+		b.WriteString(formatSumLine(entry.Hash, filepath.ToSlash(relPath)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// WriteSums writes SHA256SUMS and SHA256SUMS.sha256 into snapshotPath from the given index
+// (no rehashing) and returns the manifest hash.
+func WriteSums(snapshotPath string, idx *registry.Index) (string, error) {
+	content, err := RenderSums(idx)
+	if err != nil {
+		return "", err
+	}
+	if err := persist.WriteFile(filepath.Join(snapshotPath, SumsFileName), content, 0644); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(content)
+	manifestHash := hex.EncodeToString(sum[:])
+	if err := persist.WriteFile(filepath.Join(snapshotPath, SumsHashFileName), []byte(formatSumLine(manifestHash, SumsFileName)), 0644); err != nil {
+		return "", err
+	}
+	return manifestHash, nil
+}
+
+// ReadSums parses a SHA256SUMS file
+func ReadSums(path string) ([]SumEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []SumEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		entry, err := parseSumLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// ManifestHash returns the SHA-256 of a snapshot's SHA256SUMS file and the hash
+// recorded for it in SHA256SUMS.sha256 (empty if that file is missing).
+func ManifestHash(snapshotPath string) (actual string, recorded string, err error) {
+	content, err := os.ReadFile(filepath.Join(snapshotPath, SumsFileName))
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256(content)
+	actual = hex.EncodeToString(sum[:])
+
+	if entries, err := ReadSums(filepath.Join(snapshotPath, SumsHashFileName)); err == nil && len(entries) > 0 {
+		recorded = entries[0].Hash
+	}
+	return actual, recorded, nil
+}
+
+// IsManifestFile reports whether relPath (relative to the snapshot root) is one of the
+// integrity files stored in a snapshot
+func IsManifestFile(relPath string) bool {
+	return relPath == SumsFileName || relPath == SumsHashFileName || relPath == ChainFileName
+}
+
+// formatSumLine follows GNU coreutils: names with '\' or newlines are escaped and the
+// line is prefixed with a backslash.
+func formatSumLine(hash, name string) string {
+	if strings.ContainsAny(name, "\\\n\r") {
+		escaped := strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(name)
+		return "\\" + hash + "  " + escaped + "\n"
+	}
+	return hash + "  " + name + "\n"
+}
+
+func parseSumLine(line string) (SumEntry, error) {
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+	if len(line) < 66 || (line[64] != ' ') || (line[65] != ' ' && line[65] != '*') {
+		return SumEntry{}, fmt.Errorf("malformed line")
+	}
+	entry := SumEntry{Hash: strings.ToLower(line[:64]), RelPath: line[66:]}
+	if escaped {
+		entry.RelPath = strings.NewReplacer("\\\\", "\\", "\\n", "\n", "\\r", "\r").Replace(entry.RelPath)
+	}
+	return entry, nil
+}
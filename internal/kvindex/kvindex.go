@@ -0,0 +1,330 @@
+package kvindex
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// kvindex.go is the on-disk file index used where a JSON map would have to be loaded and
+// rewritten whole: a bbolt file mapping path -> value, plus hash -> paths to group files
+// by content. Updates are incremental and iteration streams from disk in key order, so
+// memory does not grow with the number of files.
+
+var (
+	pathsBucket  = []byte("paths")  // path -> hash \x00 JSON value
+	hashesBucket = []byte("hashes") // hash \x00 path -> (empty)
+)
+
+const sep = 0
+
+// Index maps paths to values of type V. Each path may carry a content hash; paths with
+// an empty hash are stored but not grouped.
+type Index[V any] struct {
+	db   *bolt.DB
+	temp bool
+}
+
+// Open opens (creating it if needed) the index stored at path. Another process holding
+// the file open for writing makes Open wait up to 10 seconds.
+func Open[V any](path string) (*Index[V], error) {
+	return open[V](path, &bolt.Options{Timeout: 10 * time.Second}, false)
+}
+
+// OpenReadOnly opens an existing index for reading. A missing file is reported as
+// os.ErrNotExist.
+func OpenReadOnly[V any](path string) (*Index[V], error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return open[V](path, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true}, false)
+}
+
+// OpenTemp creates a scratch index in dir (os.TempDir() if empty), deleted by Close.
+// Writes are not fsynced.
+func OpenTemp[V any](dir string) (*Index[V], error) {
+	f, err := os.CreateTemp(dir, ".gpb-index-*.db")
+	if err != nil {
+		return nil, err
+	}
+	path := f.Name()
+	f.Close()
+	os.Remove(path) // bbolt initialises the file itself
+	x, err := open[V](path, &bolt.Options{NoSync: true, NoFreelistSync: true}, true)
+	if err != nil {
+		os.Remove(path)
+	}
+	return x, err
+}
+
+func open[V any](path string, opts *bolt.Options, temp bool) (*Index[V], error) {
+	db, err := bolt.Open(path, 0644, opts)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	if !opts.ReadOnly {
+		err = db.Update(func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{pathsBucket, hashesBucket} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &Index[V]{db: db, temp: temp}, nil
+}
+
+// Path returns the file holding the index
+func (x *Index[V]) Path() string {
+	return x.db.Path()
+}
+
+// Close closes the index (and deletes it if it is a scratch index)
+func (x *Index[V]) Close() error {
+	path := x.db.Path()
+	err := x.db.Close()
+	if x.temp {
+		os.Remove(path)
+	}
+	return err
+}
+
+// Tx is a read-write transaction: every change made in one Update is committed at once
+type Tx[V any] struct {
+	paths, hashes *bolt.Bucket
+}
+
+// Update runs fn in one read-write transaction. Use it for bulk changes: each
+// transaction costs one fsync.
+func (x *Index[V]) Update(fn func(tx *Tx[V]) error) error {
+	return x.db.Update(func(btx *bolt.Tx) error {
+		return fn(&Tx[V]{paths: btx.Bucket(pathsBucket), hashes: btx.Bucket(hashesBucket)})
+	})
+}
+
+// Put stores v for path under hash, replacing any previous value
+func (x *Index[V]) Put(path, hash string, v V) error {
+	return x.Update(func(tx *Tx[V]) error { return tx.Put(path, hash, v) })
+}
+
+// Delete removes path
+func (x *Index[V]) Delete(path string) error {
+	return x.Update(func(tx *Tx[V]) error { return tx.Delete(path) })
+}
+
+// Put stores v for path under hash, replacing any previous value
+func (tx *Tx[V]) Put(path, hash string, v V) error {
+	if err := tx.Delete(path); err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	record := append(append([]byte(hash), sep), data...)
+	if err := tx.paths.Put([]byte(path), record); err != nil {
+		return err
+	}
+	if hash == "" {
+		return nil
+	}
+	return tx.hashes.Put(hashKey(hash, path), nil)
+}
+
+// Delete removes path (a missing path is not an error)
+func (tx *Tx[V]) Delete(path string) error {
+	old := tx.paths.Get([]byte(path))
+	if old == nil {
+		return nil
+	}
+	if hash, _, err := splitRecord(old); err == nil && hash != "" {
+		if err := tx.hashes.Delete(hashKey(hash, path)); err != nil {
+			return err
+		}
+	}
+	return tx.paths.Delete([]byte(path))
+}
+
+// Clear removes every entry
+func (tx *Tx[V]) Clear() error {
+	for _, b := range []*bolt.Bucket{tx.paths, tx.hashes} {
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Get returns the value stored for path
+func (x *Index[V]) Get(path string) (v V, found bool, err error) {
+	err = x.db.View(func(tx *bolt.Tx) error {
+		record := tx.Bucket(pathsBucket).Get([]byte(path))
+		if record == nil {
+			return nil
+		}
+		found = true
+		_, v, err = decode[V](record)
+		return err
+	})
+	return v, found, err
+}
+
+// Len returns the number of paths
+func (x *Index[V]) Len() (n int, err error) {
+	err = x.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(pathsBucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// ForEach calls fn for every path in key order. fn must not modify the index.
+func (x *Index[V]) ForEach(fn func(path, hash string, v V) error) error {
+	return x.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pathsBucket).ForEach(func(k, record []byte) error {
+			hash, v, err := decode[V](record)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			return fn(string(k), hash, v)
+		})
+	})
+}
+
+// PathsByHash returns the paths stored under hash, sorted
+func (x *Index[V]) PathsByHash(hash string) (paths []string, err error) {
+	err = x.db.View(func(tx *bolt.Tx) error {
+		prefix := hashKey(hash, "")
+		c := tx.Bucket(hashesBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			paths = append(paths, string(k[len(prefix):]))
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// Ref is one path found by Merge, with the position of its index in the list
+type Ref struct {
+	Index int
+	Path  string
+}
+
+// Merge walks the hashes of several indexes at once, in hash order, and calls fn once per
+// hash with every path holding it. Only one group is in memory at a time.
+func Merge[V any](indexes []*Index[V], fn func(hash string, refs []Ref) error) error {
+	cursors := make([]*bolt.Cursor, len(indexes))
+	keys := make([][]byte, len(indexes))
+	for i, x := range indexes {
+		tx, err := x.db.Begin(false)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		b := tx.Bucket(hashesBucket)
+		if b == nil {
+			continue // Never written to: nothing grouped
+		}
+		cursors[i] = b.Cursor()
+		keys[i], _ = cursors[i].First()
+	}
+
+	for {
+		var min []byte
+		for _, k := range keys {
+			if k == nil {
+				continue
+			}
+			if h := hashOf(k); min == nil || bytes.Compare(h, min) < 0 {
+				min = h
+			}
+		}
+		if min == nil {
+			return nil
+		}
+		hash := string(min)
+
+		var refs []Ref
+		for i, k := range keys {
+			for k != nil && string(hashOf(k)) == hash {
+				refs = append(refs, Ref{Index: i, Path: string(k[len(hash)+1:])})
+				k, _ = cursors[i].Next()
+			}
+			keys[i] = k
+		}
+		if err := fn(hash, refs); err != nil {
+			return err
+		}
+	}
+}
+
+// ErrCorrupt reports a record that cannot be decoded
+var ErrCorrupt = errors.New("corrupt index record")
+
+func hashKey(hash, path string) []byte {
+	key := make([]byte, 0, len(hash)+1+len(path))
+	key = append(key, hash...)
+	key = append(key, sep)
+	return append(key, path...)
+}
+
+func hashOf(key []byte) []byte {
+	if i := bytes.IndexByte(key, sep); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+func splitRecord(record []byte) (string, []byte, error) {
+	i := bytes.IndexByte(record, sep)
+	if i < 0 {
+		return "", nil, ErrCorrupt
+	}
+	return string(record[:i]), record[i+1:], nil
+}
+
+func decode[V any](record []byte) (string, V, error) {
+	var v V
+	hash, data, err := splitRecord(record)
+	if err != nil {
+		return "", v, err
+	}
+	err = json.Unmarshal(data, &v)
+	return hash, v, err
+}
+
+// Replace atomically installs a freshly built index at path: build writes into a new
+// index next to path, which is renamed over path once complete
+func Replace[V any](path string, build func(tx *Tx[V]) error) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	os.Remove(tmp)
+	x, err := Open[V](tmp)
+	if err != nil {
+		return err
+	}
+	err = x.Update(build)
+	if cerr := x.db.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
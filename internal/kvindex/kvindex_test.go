@@ -0,0 +1,148 @@
+package kvindex
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testValue struct {
+	Size int64 `json:"size"`
+}
+
+func openTest(t *testing.T, records map[string]string) *Index[testValue] {
+	t.Helper()
+	x, err := OpenTemp[testValue](t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenTemp: %v", err)
+	}
+	t.Cleanup(func() { x.Close() })
+	err = x.Update(func(tx *Tx[testValue]) error {
+		for path, hash := range records {
+			if err := tx.Put(path, hash, testValue{Size: int64(len(path))}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	return x
+}
+
+func TestPathsByHash(t *testing.T) {
+	x := openTest(t, map[string]string{
+		"b.jpg":   "h1",
+		"a.jpg":   "h1",
+		"c.jpg":   "h2",
+		"n.json":  "",
+		"h1x.jpg": "h1x", // Hash sharing a prefix with h1
+	})
+
+	check := func(hash string, want []string) {
+		t.Helper()
+		got, err := x.PathsByHash(hash)
+		if err != nil {
+			t.Fatalf("PathsByHash(%s): %v", hash, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("PathsByHash(%s) = %v, want %v", hash, got, want)
+		}
+	}
+	check("h1", []string{"a.jpg", "b.jpg"})
+	check("h1x", []string{"h1x.jpg"})
+	check("missing", nil)
+
+	// Replacing a path moves it to its new hash; deleting it removes it from its hash
+	if err := x.Put("a.jpg", "h2", testValue{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Delete("c.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	check("h1", []string{"b.jpg"})
+	check("h2", []string{"a.jpg"})
+
+	if n, err := x.Len(); err != nil || n != 4 {
+		t.Errorf("Len = %d, %v, want 4", n, err)
+	}
+	if v, found, err := x.Get("n.json"); err != nil || !found || v.Size != 6 {
+		t.Errorf("Get(n.json) = %v, %v, %v", v, found, err)
+	}
+}
+
+func TestForEachKeyOrder(t *testing.T) {
+	x := openTest(t, map[string]string{"c": "h1", "a": "h2", "b": ""})
+	var paths, hashes []string
+	err := x.ForEach(func(path, hash string, v testValue) error {
+		paths = append(paths, path)
+		hashes = append(hashes, hash)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEach: %v", err)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %v, want %v", paths, want)
+	}
+	if want := []string{"h2", "", "h1"}; !reflect.DeepEqual(hashes, want) {
+		t.Errorf("hashes = %v, want %v", hashes, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	first := openTest(t, map[string]string{"1/a.jpg": "h1", "1/b.jpg": "h3", "1/c.jpg": "h1", "1/n.json": ""})
+	second := openTest(t, map[string]string{"2/a.jpg": "h2", "2/b.jpg": "h3"})
+	empty := openTest(t, nil)
+
+	type group struct {
+		Hash string
+		Refs []Ref
+	}
+	var got []group
+	err := Merge([]*Index[testValue]{first, empty, second}, func(hash string, refs []Ref) error {
+		got = append(got, group{hash, refs})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	want := []group{
+		{"h1", []Ref{{0, "1/a.jpg"}, {0, "1/c.jpg"}}},
+		{"h2", []Ref{{2, "2/a.jpg"}}},
+		{"h3", []Ref{{0, "1/b.jpg"}, {2, "2/b.jpg"}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge groups = %v, want %v", got, want)
+	}
+}
+
+func TestReplaceAndReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	if _, err := OpenReadOnly[testValue](path); !os.IsNotExist(err) {
+		t.Fatalf("OpenReadOnly(missing) = %v, want not exist", err)
+	}
+
+	for _, size := range []int64{1, 2} {
+		err := Replace(path, func(tx *Tx[testValue]) error {
+			return tx.Put("a.jpg", "h1", testValue{Size: size})
+		})
+		if err != nil {
+			t.Fatalf("Replace: %v", err)
+		}
+	}
+
+	x, err := OpenReadOnly[testValue](path)
+	if err != nil {
+		t.Fatalf("OpenReadOnly: %v", err)
+	}
+	defer x.Close()
+	if v, found, err := x.Get("a.jpg"); err != nil || !found || v.Size != 2 {
+		t.Errorf("Get = %v, %v, %v, want the last build", v, found, err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), ".index.db.tmp")); !os.IsNotExist(err) {
+		t.Errorf("Replace left its temporary file behind")
+	}
+}
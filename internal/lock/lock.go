@@ -0,0 +1,148 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// lock.go is an advisory per-directory run lock: flock on <dir>/.gpb.lock. The kernel
+// releases it when the holder exits, so a crashed run never keeps it held; the holder
+// metadata written into the file only tells others who has it (or who crashed with it).
+
+// FileName is the lock file created in every locked directory
+const FileName = ".gpb.lock"
+
+// Holder describes the process holding a lock
+type Holder struct {
+	PID     int       `json:"pid"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+	Host    string    `json:"host,omitempty"`
+}
+
+func (h Holder) String() string {
+	return fmt.Sprintf("pid %d (%s) since %s", h.PID, h.Command, h.Started.Format("02/01/2006 15:04:05"))
+}
+
+// Running reports whether the holder process still exists on this host
+func (h Holder) Running() bool {
+	if h.PID <= 0 {
+		return false
+	}
+	err := unix.Kill(h.PID, 0)
+	return err == nil || err == unix.EPERM
+}
+
+// HeldError is returned when another process holds the lock and waiting was not requested
+type HeldError struct {
+	Path   string
+	Holder *Holder // nil if the holder did not write its metadata
+}
+
+func (e *HeldError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("%s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by %s", e.Path, e.Holder)
+}
+
+// Lock is a held lock
+type Lock struct {
+	Path  string
+	Stale *Holder // Metadata left by a previous holder that exited without releasing
+	file  *os.File
+}
+
+// Acquire locks dir for command. When the lock is held elsewhere it fails with a
+// HeldError, or with wait blocks until it is released (onWait is called first).
+func Acquire(dir, command string, wait bool, onWait func(*HeldError)) (*Lock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, FileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		if err != unix.EWOULDBLOCK {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		held := &HeldError{Path: dir, Holder: readHolder(f)}
+		if !wait {
+			f.Close()
+			return nil, held
+		}
+		if onWait != nil {
+			onWait(held)
+		}
+		if err := flockRetry(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+	}
+
+	l := &Lock{Path: path, Stale: readHolder(f), file: f}
+	host, _ := os.Hostname()
+	data, _ := json.Marshal(Holder{PID: os.Getpid(), Command: command, Started: time.Now(), Host: host})
+	if err := l.write(append(data, '\n')); err != nil {
+		l.Release()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Release clears the holder metadata and unlocks. Safe to call on a nil Lock.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	l.file.Truncate(0)
+	err := unix.Flock(int(l.file.Fd()), unix.LOCK_UN)
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	return err
+}
+
+func (l *Lock) write(data []byte) error {
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.file.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// flockRetry blocks on the lock, retrying when interrupted by a signal
+func flockRetry(f *os.File) error {
+	for {
+		err := unix.Flock(int(f.Fd()), unix.LOCK_EX)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+// readHolder returns the metadata stored in the lock file, nil if empty or unreadable
+func readHolder(f *os.File) *Holder {
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 4096))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var h Holder
+	if json.Unmarshal(data, &h) != nil || h.PID == 0 {
+		return nil
+	}
+	return &h
+}
@@ -0,0 +1,134 @@
+package persist
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google-photos-backup/internal/logger"
+)
+
+// persist.go writes state files so that a crash or a full disk never leaves them
+// truncated: the new content goes to a temp file in the same directory, which is fsynced
+// and renamed over the old one before the directory itself is fsynced. State files also
+// keep their previous generation as <file>.bak, used by --recover.
+
+// BackupSuffix is appended to the previous generation of a state file
+const BackupSuffix = ".bak"
+
+// Recover allows Load to fall back to the .bak of a corrupt file (set by --recover)
+var Recover bool
+
+// CorruptError reports a state file that exists but cannot be parsed
+type CorruptError struct {
+	Path string
+	Err  error
+}
+
+func (e *CorruptError) Error() string {
+	hint := "restore it or remove it"
+	if _, err := os.Stat(e.Path + BackupSuffix); err == nil {
+		hint = "run with --recover to restore the previous version from " + filepath.Base(e.Path) + BackupSuffix
+	}
+	return fmt.Sprintf("%s is corrupt (%v): %s", e.Path, e.Err, hint)
+}
+
+func (e *CorruptError) Unwrap() error { return e.Err }
+
+// WriteFile atomically replaces path with data
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return write(path, data, perm, false)
+}
+
+// WriteState atomically replaces path with data, keeping the previous content as path.bak
+func WriteState(path string, data []byte, perm os.FileMode) error {
+	return write(path, data, perm, true)
+}
+
+func write(path string, data []byte, perm os.FileMode, keepBackup bool) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, perm)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if keepBackup {
+		// A second name for the current inode: the rename below leaves it as the .bak
+		bak := path + BackupSuffix
+		os.Remove(bak)
+		if err := os.Link(path, bak); err != nil && !os.IsNotExist(err) {
+			logger.Debug("Could not keep %s: %v", bak, err)
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// Load reads path and hands it to parse. A parse failure is returned as a CorruptError,
+// unless Recover is set and path.bak parses: then the corrupt file is kept as
+// path.corrupt and replaced by the backup. Errors reading the file are returned as is.
+func Load(path string, parse func([]byte) error) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	perr := parse(data)
+	if perr == nil {
+		return nil
+	}
+	corrupt := &CorruptError{Path: path, Err: perr}
+	if !Recover {
+		return corrupt
+	}
+
+	bak, err := os.ReadFile(path + BackupSuffix)
+	if err != nil {
+		return corrupt
+	}
+	if err := parse(bak); err != nil {
+		return corrupt
+	}
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := WriteFile(path+".corrupt", data, perm); err != nil {
+		return err
+	}
+	if err := WriteFile(path, bak, perm); err != nil {
+		return err
+	}
+	logger.Info("🩹 Recovered %s from %s (corrupt file kept as %s.corrupt)", path, filepath.Base(path)+BackupSuffix, filepath.Base(path))
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}
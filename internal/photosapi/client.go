@@ -0,0 +1,234 @@
+package photosapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// client.go pages mediaItems:search and downloads originals. Access tokens are refreshed
+// (and saved) transparently; rate limits and server errors are retried with backoff.
+
+const (
+	pageSize   = 100
+	maxRetries = 4
+)
+
+// MediaItem is the subset of the Library API mediaItem used for backups
+type MediaItem struct {
+	ID            string        `json:"id"`
+	Filename      string        `json:"filename"`
+	MimeType      string        `json:"mimeType"`
+	BaseURL       string        `json:"baseUrl"`
+	Description   string        `json:"description,omitempty"`
+	MediaMetadata MediaMetadata `json:"mediaMetadata"`
+}
+
+// MediaMetadata carries the capture time; Video is set for videos
+type MediaMetadata struct {
+	CreationTime time.Time       `json:"creationTime"`
+	Width        string          `json:"width,omitempty"`
+	Height       string          `json:"height,omitempty"`
+	Video        json.RawMessage `json:"video,omitempty"`
+}
+
+// IsVideo reports whether the item must be downloaded with "=dv"
+func (m MediaItem) IsVideo() bool {
+	return len(m.MediaMetadata.Video) > 0
+}
+
+// Client calls the Library API on behalf of one account
+type Client struct {
+	OAuth     *OAuth
+	Token     *Token
+	TokenPath string // Refreshed tokens are saved here
+	HTTP      *http.Client
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
+
+// accessToken returns a valid access token, refreshing and saving it when needed
+func (c *Client) accessToken(ctx context.Context, force bool) (string, error) {
+	if !force && c.Token.Valid() {
+		return c.Token.AccessToken, nil
+	}
+	fresh, err := c.OAuth.Refresh(ctx, c.Token)
+	if err != nil {
+		return "", fmt.Errorf("token refresh failed: %w", err)
+	}
+	c.Token = fresh
+	if c.TokenPath != "" {
+		if err := fresh.Save(c.TokenPath); err != nil {
+			return "", err
+		}
+	}
+	return fresh.AccessToken, nil
+}
+
+// ListSince calls fn for every item created at or after since, oldest first, except the
+// items in seenIDs created exactly at since (downloaded by the run that stopped there).
+// The date filter has day granularity: older items of the first day are skipped here.
+func (c *Client) ListSince(ctx context.Context, since time.Time, seenIDs []string, fn func(MediaItem) error) error {
+	// orderBy is only accepted together with a dateFilter: the first run asks for
+	// everything since the epoch
+	start := since.UTC()
+	if since.IsZero() {
+		start = time.Unix(0, 0).UTC()
+	}
+	body := map[string]interface{}{
+		"pageSize": pageSize,
+		"orderBy":  "MediaMetadata.creation_time",
+		"filters": map[string]interface{}{
+			"dateFilter": map[string]interface{}{
+				"ranges": []map[string]interface{}{{
+					"startDate": apiDate(start),
+					"endDate":   apiDate(time.Now().UTC().AddDate(0, 0, 1)),
+				}},
+			},
+		},
+	}
+	seen := make(map[string]bool, len(seenIDs))
+	for _, id := range seenIDs {
+		seen[id] = true
+	}
+
+	for {
+		var page struct {
+			MediaItems    []MediaItem `json:"mediaItems"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		if err := c.postJSON(ctx, "/v1/mediaItems:search", body, &page); err != nil {
+			return err
+		}
+		for _, item := range page.MediaItems {
+			created := item.MediaMetadata.CreationTime
+			if created.Before(since) || (created.Equal(since) && seen[item.ID]) {
+				continue
+			}
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		if page.NextPageToken == "" {
+			return nil
+		}
+		body["pageToken"] = page.NextPageToken
+	}
+}
+
+// Download writes the original bytes of item to dest (via dest.part) and returns its size
+func (c *Client) Download(ctx context.Context, item MediaItem, dest string) (int64, error) {
+	suffix := "=d"
+	if item.IsVideo() {
+		suffix = "=dv"
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, err
+	}
+
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, item.BaseURL+suffix, nil)
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	tmp := dest + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, resp.Body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return n, os.Rename(tmp, dest)
+}
+
+func (c *Client) postJSON(ctx context.Context, path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.OAuth.Endpoints.APIURL+path, bytes.NewReader(data))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, err
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// do sends an authorized request, refreshing the token once on 401 and retrying
+// 429 / 5xx responses with exponential backoff. The caller closes the body.
+func (c *Client) do(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	forceRefresh := false
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		token, err := c.accessToken(ctx, forceRefresh)
+		if err != nil {
+			return nil, err
+		}
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := c.httpClient().Do(req)
+		retry := err != nil
+		if err == nil {
+			switch {
+			case resp.StatusCode == http.StatusOK:
+				return resp, nil
+			case resp.StatusCode == http.StatusUnauthorized && !forceRefresh:
+				forceRefresh = true
+				resp.Body.Close()
+				continue
+			case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+				retry = true
+				err = fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+			default:
+				msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+				resp.Body.Close()
+				return nil, fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+			}
+			resp.Body.Close()
+		}
+		if !retry || attempt >= maxRetries {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func apiDate(t time.Time) map[string]int {
+	return map[string]int{"year": t.Year(), "month": int(t.Month()), "day": t.Day()}
+}
@@ -0,0 +1,183 @@
+package photosapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubLibrary serves the token endpoint, two pages of mediaItems:search and the
+// download URLs of the items. It only accepts the access token issued by a refresh.
+type stubLibrary struct {
+	t         *testing.T
+	items     []MediaItem
+	refreshes int
+	searches  []map[string]interface{}
+}
+
+func (s *stubLibrary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != "refresh" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		s.refreshes++
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "fresh", "expires_in": 3600})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer fresh" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/v1/mediaItems:search":
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			s.t.Errorf("search body: %v", err)
+		}
+		s.searches = append(s.searches, body)
+		page := map[string]interface{}{"mediaItems": s.items[:2], "nextPageToken": "page2"}
+		if body["pageToken"] == "page2" {
+			page = map[string]interface{}{"mediaItems": s.items[2:]}
+		}
+		json.NewEncoder(w).Encode(page)
+	case strings.HasPrefix(r.URL.Path, "/media/"):
+		// The body names the requested download variant
+		w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/media/")))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newStubClient(t *testing.T, items []MediaItem) (*Client, *stubLibrary) {
+	stub := &stubLibrary{t: t, items: items}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	for i := range stub.items {
+		stub.items[i].BaseURL = server.URL + "/media/" + stub.items[i].ID
+	}
+
+	client := &Client{
+		OAuth: &OAuth{
+			ClientID:  "client",
+			Endpoints: Endpoints{TokenURL: server.URL + "/token", APIURL: server.URL},
+		},
+		// Not expired, but rejected by the server: the first request gets a 401
+		Token:     &Token{AccessToken: "stale", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)},
+		TokenPath: filepath.Join(t.TempDir(), "token.json"),
+	}
+	return client, stub
+}
+
+func item(id string, created time.Time, video bool) MediaItem {
+	it := MediaItem{ID: id, Filename: id + ".jpg", MediaMetadata: MediaMetadata{CreationTime: created}}
+	if video {
+		it.MediaMetadata.Video = json.RawMessage(`{"fps":30}`)
+	}
+	return it
+}
+
+func TestListSince(t *testing.T) {
+	cursor := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	client, stub := newStubClient(t, []MediaItem{
+		item("earlier-same-day", cursor.Add(-time.Hour), false),
+		item("at-cursor-seen", cursor, false),
+		item("at-cursor-new", cursor, false),
+		item("later", cursor.Add(time.Hour), true),
+	})
+
+	var got []string
+	err := client.ListSince(context.Background(), cursor, []string{"at-cursor-seen"}, func(it MediaItem) error {
+		got = append(got, it.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListSince: %v", err)
+	}
+
+	if want := []string{"at-cursor-new", "later"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("items = %v, want %v", got, want)
+	}
+	if stub.refreshes != 1 {
+		t.Errorf("refreshes = %d, want 1 (on the first 401 only)", stub.refreshes)
+	}
+	if client.Token.AccessToken != "fresh" {
+		t.Errorf("token not replaced: %q", client.Token.AccessToken)
+	}
+	if saved, err := LoadToken(client.TokenPath); err != nil || saved.AccessToken != "fresh" || saved.RefreshToken != "refresh" {
+		t.Errorf("refreshed token not saved: %+v, %v", saved, err)
+	}
+	if len(stub.searches) != 2 {
+		t.Fatalf("searches = %d, want 2 pages", len(stub.searches))
+	}
+	if stub.searches[1]["pageToken"] != "page2" {
+		t.Errorf("second page requested without nextPageToken: %v", stub.searches[1])
+	}
+	if _, ok := stub.searches[0]["filters"].(map[string]interface{})["dateFilter"]; !ok {
+		t.Errorf("search without dateFilter: %v", stub.searches[0])
+	}
+}
+
+func TestListSinceFirstRunSendsDateFilter(t *testing.T) {
+	client, stub := newStubClient(t, []MediaItem{
+		item("a", time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), false),
+		item("b", time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC), false),
+	})
+
+	count := 0
+	if err := client.ListSince(context.Background(), time.Time{}, nil, func(MediaItem) error { count++; return nil }); err != nil {
+		t.Fatalf("ListSince: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("items = %d, want 2", count)
+	}
+
+	// orderBy is rejected without a dateFilter: the first run asks for 1970-01-01..tomorrow
+	filters, _ := stub.searches[0]["filters"].(map[string]interface{})
+	dateFilter, _ := filters["dateFilter"].(map[string]interface{})
+	ranges, _ := dateFilter["ranges"].([]interface{})
+	if len(ranges) != 1 {
+		t.Fatalf("dateFilter = %v, want one range", stub.searches[0]["filters"])
+	}
+	start := ranges[0].(map[string]interface{})["startDate"].(map[string]interface{})
+	if start["year"] != 1970.0 || start["month"] != 1.0 || start["day"] != 1.0 {
+		t.Errorf("startDate = %v, want 1970-01-01", start)
+	}
+}
+
+func TestDownloadVariant(t *testing.T) {
+	photo, video := item("photo", time.Now(), false), item("video", time.Now(), true)
+	client, _ := newStubClient(t, []MediaItem{photo, video})
+	photo.BaseURL = client.OAuth.Endpoints.APIURL + "/media/photo"
+	video.BaseURL = client.OAuth.Endpoints.APIURL + "/media/video"
+	dir := t.TempDir()
+
+	for _, tc := range []struct {
+		item MediaItem
+		want string
+	}{
+		{photo, "photo=d"},
+		{video, "video=dv"},
+	} {
+		dest := filepath.Join(dir, tc.item.ID)
+		n, err := client.Download(context.Background(), tc.item, dest)
+		if err != nil {
+			t.Fatalf("Download %s: %v", tc.item.ID, err)
+		}
+		data, _ := os.ReadFile(dest)
+		if string(data) != tc.want || n != int64(len(tc.want)) {
+			t.Errorf("Download %s fetched %q (%d bytes), want %q", tc.item.ID, data, n, tc.want)
+		}
+		if _, err := os.Stat(dest + ".part"); !os.IsNotExist(err) {
+			t.Errorf("%s.part left behind", tc.item.ID)
+		}
+	}
+}
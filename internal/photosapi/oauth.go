@@ -0,0 +1,281 @@
+package photosapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google-photos-backup/internal/persist"
+)
+
+// oauth.go implements the two OAuth flows usable by a CLI (loopback redirect and device
+// code) and token refresh, with plain net/http. Every URL comes from Endpoints so the
+// whole exchange can be pointed at a local stub server.
+
+// Scope is read-only access to the user's library
+const Scope = "https://www.googleapis.com/auth/photoslibrary.readonly"
+
+// Endpoints are the URLs used by the OAuth flows and the API client
+type Endpoints struct {
+	AuthURL   string // Browser consent page (loopback flow)
+	TokenURL  string // Code exchange and refresh
+	DeviceURL string // Device code request (device flow)
+	APIURL    string // Library API base, e.g. https://photoslibrary.googleapis.com
+}
+
+// Token is an OAuth token as stored in token_path
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// Valid reports whether the access token can still be used (with a minute of margin)
+func (t *Token) Valid() bool {
+	return t != nil && t.AccessToken != "" && time.Now().Add(time.Minute).Before(t.Expiry)
+}
+
+// LoadToken reads a token saved by Save
+func LoadToken(path string) (*Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Token
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Save writes the token readable by the owner only
+func (t *Token) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return persist.WriteFile(path, data, 0600)
+}
+
+// OAuth holds the client credentials of the Google Cloud project
+type OAuth struct {
+	ClientID     string
+	ClientSecret string
+	Endpoints    Endpoints
+	HTTP         *http.Client
+}
+
+func (o *OAuth) httpClient() *http.Client {
+	if o.HTTP != nil {
+		return o.HTTP
+	}
+	return http.DefaultClient
+}
+
+// LoopbackLogin runs the installed-app flow: a one-shot HTTP server on 127.0.0.1 receives
+// the authorization code after the user consents in the browser opened by openURL.
+func (o *OAuth) LoopbackLogin(ctx context.Context, openURL func(string)) (*Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	redirectURI := "http://" + listener.Addr().String() + "/"
+
+	state := randomString()
+	authURL := o.Endpoints.AuthURL + "?" + url.Values{
+		"client_id":     {o.ClientID},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"scope":         {Scope},
+		"access_type":   {"offline"}, // Ask for a refresh token
+		"prompt":        {"consent"},
+		"state":         {state},
+	}.Encode()
+
+	type result struct {
+		code string
+		err  error
+	}
+	done := make(chan result, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("state") != state:
+			http.Error(w, "Invalid state", http.StatusBadRequest)
+			return
+		case q.Get("error") != "":
+			fmt.Fprintln(w, "Authorization failed. You can close this window.")
+			done <- result{err: fmt.Errorf("authorization denied: %s", q.Get("error"))}
+		default:
+			fmt.Fprintln(w, "Authorization complete. You can close this window.")
+			done <- result{code: q.Get("code")}
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	openURL(authURL)
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-done:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return o.exchange(ctx, url.Values{
+			"grant_type":   {"authorization_code"},
+			"code":         {res.code},
+			"redirect_uri": {redirectURI},
+		})
+	}
+}
+
+// DeviceCode is what the user must enter on another device
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// DeviceLogin runs the device flow for headless machines: show is called with the code
+// and URL to visit, then the token endpoint is polled until the user approves.
+func (o *OAuth) DeviceLogin(ctx context.Context, show func(userCode, verificationURL string)) (*Token, error) {
+	var dc DeviceCode
+	if err := o.postForm(ctx, o.Endpoints.DeviceURL, url.Values{
+		"client_id": {o.ClientID},
+		"scope":     {Scope},
+	}, &dc); err != nil {
+		return nil, fmt.Errorf("device code request failed: %w", err)
+	}
+	show(dc.UserCode, dc.VerificationURL)
+
+	interval := time.Duration(dc.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(dc.ExpiresIn) * time.Second)
+	for dc.ExpiresIn <= 0 || time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		token, err := o.exchange(ctx, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {dc.DeviceCode},
+		})
+		if err == nil {
+			return token, nil
+		}
+		if oerr, ok := err.(*oauthError); ok {
+			switch oerr.Code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += 5 * time.Second
+				continue
+			}
+		}
+		return nil, err
+	}
+	return nil, fmt.Errorf("device code expired before authorization")
+}
+
+// Refresh obtains a new access token; the refresh token is kept when none is returned
+func (o *OAuth) Refresh(ctx context.Context, t *Token) (*Token, error) {
+	if t == nil || t.RefreshToken == "" {
+		return nil, fmt.Errorf("no refresh token: run 'api-sync --login'")
+	}
+	fresh, err := o.exchange(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {t.RefreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fresh.RefreshToken == "" {
+		fresh.RefreshToken = t.RefreshToken
+	}
+	return fresh, nil
+}
+
+// oauthError is an error response of the token endpoint (RFC 6749 section 5.2)
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *oauthError) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+func (o *OAuth) exchange(ctx context.Context, form url.Values) (*Token, error) {
+	form.Set("client_id", o.ClientID)
+	if o.ClientSecret != "" {
+		form.Set("client_secret", o.ClientSecret)
+	}
+	var resp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+	if err := o.postForm(ctx, o.Endpoints.TokenURL, form, &resp); err != nil {
+		return nil, err
+	}
+	return &Token{
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+		TokenType:    resp.TokenType,
+		Expiry:       time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}, nil
+}
+
+func (o *OAuth) postForm(ctx context.Context, endpoint string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := o.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var oerr oauthError
+		if json.NewDecoder(resp.Body).Decode(&oerr) == nil && oerr.Code != "" {
+			return &oerr
+		}
+		return fmt.Errorf("%s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
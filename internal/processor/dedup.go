@@ -1,12 +1,12 @@
 package processor
 
 import (
-	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
-	"syscall"
 
+	"google-photos-backup/internal/fsutil"
+	"google-photos-backup/internal/kvindex"
 	"google-photos-backup/internal/logger"
 )
 
@@ -21,17 +21,21 @@ type Instance struct {
 func (m *Manager) DeduplicateAndOrganize() error {
 	logger.Info("🔄 Starting Phase 2: Global Deduplication & Organization...")
 
-	// 1. Build Global Hash Map
-	// Hash -> []Instance
-	hashMap := make(map[string][]Instance)
-	totalFiles := 0
-
-	// Iterate over all known exports (from global state or directory walk)
+	// 1. Open the file index of every processed export. Their hashes are merged from disk
+	// in hash order, so only one group of duplicates is in memory at a time.
 	entries, err := os.ReadDir(m.InputDir)
 	if err != nil {
 		return err
 	}
 
+	var exportIDs []string
+	var indexes []*kvindex.Index[FileMetadata]
+	var dirs []string
+	defer func() {
+		for _, index := range indexes {
+			index.Close()
+		}
+	}()
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
@@ -43,40 +47,41 @@ func (m *Manager) DeduplicateAndOrganize() error {
 			continue
 		}
 
-		// Load Local Index
-		indexPath := filepath.Join(m.InputDir, exportID, IndexFileName)
-		data, err := os.ReadFile(indexPath)
+		exportIndex, err := OpenExportIndex(filepath.Join(m.InputDir, exportID))
 		if err != nil {
 			logger.Info("⚠️  Could not read index for %s: %v", exportID, err)
 			continue
 		}
-
-		var savedState State
-		if err := json.Unmarshal(data, &savedState); err != nil {
-			logger.Error("❌ Failed to parse index for %s: %v", exportID, err)
+		if exportIndex.index == nil {
 			continue
 		}
-
-		for path, meta := range savedState.FileIndex {
-			if meta.IsJSON || meta.Hash == "" {
-				continue
-			}
-			hashMap[meta.Hash] = append(hashMap[meta.Hash], Instance{
-				Path:     path, // Absolute path
-				ExportID: exportID,
-			})
-			totalFiles++
-		}
+		exportIDs = append(exportIDs, exportID)
+		indexes = append(indexes, exportIndex.index)
+		dirs = append(dirs, exportIndex.dir)
 	}
 
-	logger.Info("📊 Validation: Analyzed %d files. Found %d unique hashes.", totalFiles, len(hashMap))
-
 	// 2. Deduplicate In-Place
-	dedupedCount := 0
+	linker := fsutil.Default()
+	shares := linker.SharesStorage()
+	if !shares {
+		logger.Info("Link strategy is '%s': duplicates are kept as independent files.", linker.Strategy)
+	}
+	splitter := linker.Splitting()
+	totalFiles, uniqueHashes, dedupedCount := 0, 0, 0
+
+	err = kvindex.Merge(indexes, func(hash string, refs []kvindex.Ref) error {
+		totalFiles += len(refs)
+		uniqueHashes++
+		if len(refs) < 2 || !shares {
+			return nil
+		}
 
-	for _, instances := range hashMap {
-		if len(instances) < 2 {
-			continue
+		instances := make([]Instance, len(refs))
+		for i, ref := range refs {
+			instances[i] = Instance{
+				Path:     absolutePath(dirs[ref.Index], ref.Path),
+				ExportID: exportIDs[ref.Index],
+			}
 		}
 
 		// A. Select Primary (Best path among existing files)
@@ -88,39 +93,40 @@ func (m *Manager) DeduplicateAndOrganize() error {
 				continue
 			}
 
-			// Sanity check: If primary == instance (same path), skip
-			if instance.Path == primary.Path {
-				continue
-			}
-
 			// Logic:
 			// instance -> primary
 
-			// Check if ALREADY hardlinked (same inode)
-			if m.areHardlinked(instance.Path, primary.Path) {
+			// Check if ALREADY linked (same inode / symlink to primary)
+			if linker.IsLinked(primary.Path, instance.Path) {
 				continue
 			}
 
-			// If it's a file, delete it so we can create the link
+			// Replace the duplicate with a link to primary.Path
 			// (We rely on HASH equality to know content is same)
-			if _, err := os.Stat(instance.Path); err == nil {
-				if err := os.Remove(instance.Path); err != nil {
-					logger.Error("❌ Failed to remove duplicate %s: %v", instance.Path, err)
-					continue
-				}
+			_, err := splitter.Replace(primary.Path, instance.Path)
+			if fsutil.IsLinkLimit(err) {
+				// Primary inode is full: this copy starts a new generation for the rest of the group
+				logger.Info("🔀 Link limit reached for %s. New generation starts at %s", primary.Path, instance.Path)
+				primary = instance
+				continue
 			}
-
-			// Create Hardlink
-			// primary.Path (existing) -> instance.Path (new link)
-			if err := os.Link(primary.Path, instance.Path); err != nil {
-				logger.Error("❌ Failed to hardlink %s -> %s: %v", instance.Path, primary.Path, err)
+			if err != nil {
+				logger.Error("❌ Failed to link %s -> %s: %v", instance.Path, primary.Path, err)
 			} else {
 				dedupedCount++
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	logger.Info("✅ Deduplication Complete. %d duplicates hardlinked.", dedupedCount)
+	logger.Info("📊 Validation: Analyzed %d files. Found %d unique hashes.", totalFiles, uniqueHashes)
+	if !shares {
+		return nil
+	}
+	logger.Info("✅ Deduplication Complete. %d duplicates linked (%s).", dedupedCount, linker.Strategy)
 
 	return nil
 }
@@ -177,23 +183,3 @@ func (m *Manager) scorePath(path string) int {
 
 	return score
 }
-
-// areHardlinked checks if two paths point to the same inode on the same device
-func (m *Manager) areHardlinked(p1, p2 string) bool {
-	fi1, err := os.Stat(p1)
-	if err != nil {
-		return false
-	}
-	fi2, err := os.Stat(p2)
-	if err != nil {
-		return false
-	}
-
-	stat1, ok1 := fi1.Sys().(*syscall.Stat_t)
-	stat2, ok2 := fi2.Sys().(*syscall.Stat_t)
-	if !ok1 || !ok2 {
-		return false
-	}
-
-	return stat1.Ino == stat2.Ino && stat1.Dev == stat2.Dev
-}
@@ -0,0 +1,79 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+
+	"google-photos-backup/internal/fsutil"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/registry"
+)
+
+// deletions.go detects files that disappeared upstream between two full exports
+
+// DeletedUpstreamDir is the folder (inside backup_path) that keeps disappeared files reachable
+const DeletedUpstreamDir = "Deleted upstream"
+
+// DetectUpstreamDeletions returns the media entries of prevIdx whose content (hash)
+// is no longer present anywhere in newIdx, plus the number of media entries checked.
+// Since Takeout is a full export, these are files deleted in Google Photos.
+// Sidecars and other ignored files are excluded: their content changes between exports.
+func DetectUpstreamDeletions(prevIdx, newIdx *registry.Index) ([]registry.FileIndexEntry, int, error) {
+	var disappeared []registry.FileIndexEntry
+	checked := 0
+	err := prevIdx.ForEach(func(relPath string, entry registry.FileIndexEntry) error {
+		if IsIgnoredFile(relPath) {
+			return nil
+		}
+		checked++
+		if !newIdx.HasHash(entry.Hash) {
+			disappeared = append(disappeared, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return disappeared, checked, nil
+}
+
+// PreserveDeleted links disappeared files (and their JSON sidecars) from the previous
+// snapshot into deletedRoot, so they stay reachable even after old snapshots are pruned.
+// Returns the number of media files preserved.
+func PreserveDeleted(prevSnapshot string, prevIdx *registry.Index, disappeared []registry.FileIndexEntry, deletedRoot string) (int, error) {
+	// A symlink would dangle once the previous snapshot is pruned
+	linker := fsutil.Default()
+	if linker.Strategy == fsutil.StrategySymlink {
+		linker = fsutil.New(fsutil.StrategyHardlink)
+	}
+
+	preserved := 0
+	for _, entry := range disappeared {
+		paths := []string{entry.RelPath}
+		for _, suffix := range []string{".json", ".supplemental-metadata.json"} {
+			if _, ok := prevIdx.Get(entry.RelPath + suffix); ok {
+				paths = append(paths, entry.RelPath+suffix)
+			}
+		}
+
+		for i, relPath := range paths {
+			src := filepath.Join(prevSnapshot, relPath)
+			dst := filepath.Join(deletedRoot, relPath)
+
+			if _, err := os.Lstat(dst); err == nil {
+				continue // Already preserved
+			}
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return preserved, err
+			}
+			if _, err := linker.Link(resolveView(src), dst); err != nil {
+				logger.Error("Failed to preserve deleted file %s: %v", relPath, err)
+				continue
+			}
+			if i == 0 {
+				preserved++
+			}
+		}
+	}
+	return preserved, nil
+}
@@ -0,0 +1,185 @@
+package processor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// exif.go resolves capture dates for files that have no Google JSON sidecar (ingested
+// folders): EXIF DateTimeOriginal first, then well-known camera/phone filename patterns.
+
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// EXIF tags read to find dates
+const (
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+	tagDateTime          = 0x0132
+	tagExifIFD           = 0x8769
+)
+
+var reNonDigit = regexp.MustCompile(`\D`)
+
+var filenameDatePatterns = []*regexp.Regexp{
+	// IMG_20230115_123456, PXL_20230115_123456789, VID_20230115_123456, 20230115_123456
+	regexp.MustCompile(`(\d{8})[_-](\d{6})`),
+	// Screenshot_2023-01-15-12-34-56, 2023-01-15 12.34.56, 2023-01-15_12-34-56
+	regexp.MustCompile(`(\d{4}-\d{2}-\d{2})[ _-](\d{2}[.\-]\d{2}[.\-]\d{2})`),
+	// IMG-20230115-WA0001 (WhatsApp: date only)
+	regexp.MustCompile(`(\d{8})-WA\d+`),
+}
+
+// ResolveDate returns the capture date of a media file from EXIF or its filename
+func ResolveDate(path string) (time.Time, string, bool) {
+	if t, ok := ExifDate(path); ok {
+		return t, "exif", true
+	}
+	if t, ok := FilenameDate(filepath.Base(path)); ok {
+		return t, "filename", true
+	}
+	return time.Time{}, "", false
+}
+
+// FilenameDate parses dates embedded in common camera and phone filenames
+func FilenameDate(name string) (time.Time, bool) {
+	for _, re := range filenameDatePatterns {
+		m := re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		digits := reNonDigit.ReplaceAllString(strings.Join(m[1:], ""), "")
+		layout := "20060102150405"
+		if len(digits) == 8 {
+			layout = "20060102"
+		}
+		t, err := time.ParseInLocation(layout, digits, time.Local)
+		if err == nil && plausibleDate(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ExifDate reads DateTimeOriginal (or DateTimeDigitized / DateTime) from a JPEG or a
+// TIFF-based file (most RAW formats). EXIF has no zone: the camera's local time is assumed.
+func ExifDate(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+
+	tiff, err := findTIFF(bufio.NewReader(f))
+	if err != nil {
+		return time.Time{}, false
+	}
+	dates := readExifDates(tiff)
+	for _, tag := range []uint16{tagDateTimeOriginal, tagDateTimeDigitized, tagDateTime} {
+		if s, ok := dates[tag]; ok {
+			if t, err := time.ParseInLocation(exifDateLayout, s, time.Local); err == nil && plausibleDate(t) {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// findTIFF returns the TIFF block (EXIF payload) of a JPEG, or the head of a TIFF file
+func findTIFF(r *bufio.Reader) ([]byte, error) {
+	head, err := r.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	if string(head) == "II*\x00" || string(head) == "MM\x00*" {
+		buf := make([]byte, 256*1024)
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	if head[0] != 0xFF || head[1] != 0xD8 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	r.Discard(2)
+
+	// Walk JPEG segments until APP1 "Exif\0\0"
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xFF || marker[1] == 0xDA { // Start of scan: no EXIF
+			return nil, io.ErrUnexpectedEOF
+		}
+		size := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if size < 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if marker[1] != 0xE1 {
+			if _, err := r.Discard(size); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		seg := make([]byte, size)
+		if _, err := io.ReadFull(r, seg); err != nil {
+			return nil, err
+		}
+		if len(seg) > 6 && string(seg[:6]) == "Exif\x00\x00" {
+			return seg[6:], nil
+		}
+	}
+}
+
+// readExifDates collects the ASCII date tags of IFD0 and the EXIF sub-IFD
+func readExifDates(tiff []byte) map[uint16]string {
+	dates := make(map[uint16]string)
+	if len(tiff) < 8 {
+		return dates
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if tiff[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	var walk func(offset uint32, depth int)
+	walk = func(offset uint32, depth int) {
+		if depth > 2 || int(offset)+2 > len(tiff) {
+			return
+		}
+		count := int(order.Uint16(tiff[offset:]))
+		for i := 0; i < count; i++ {
+			entry := int(offset) + 2 + i*12
+			if entry+12 > len(tiff) {
+				return
+			}
+			tag := order.Uint16(tiff[entry:])
+			typ := order.Uint16(tiff[entry+2:])
+			n := order.Uint32(tiff[entry+4:])
+			value := order.Uint32(tiff[entry+8:])
+
+			switch {
+			case tag == tagExifIFD:
+				walk(value, depth+1)
+			case (tag == tagDateTimeOriginal || tag == tagDateTimeDigitized || tag == tagDateTime) && typ == 2 && n >= 19:
+				if int(value)+19 <= len(tiff) {
+					dates[tag] = string(tiff[value : value+19])
+				}
+			}
+		}
+	}
+	walk(order.Uint32(tiff[4:]), 0)
+	return dates
+}
+
+// plausibleDate rejects zero dates ("0000:00:00") and clocks that were never set
+func plausibleDate(t time.Time) bool {
+	return t.Year() >= 1990 && t.Before(time.Now().Add(48*time.Hour))
+}
@@ -6,7 +6,6 @@ import (
 	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -22,38 +21,33 @@ import (
 // ProcessExports parses history and handles per-export processing
 // ProcessExports parses history and handles per-export processing
 func (m *Manager) ProcessExports() (bool, error) {
+	// Global deduplication reads the export indexes after this returns
+	defer m.closeFileIndex()
+
 	// 0. Load Global State (to know what's already done)
 	if err := m.LoadState(m.OutputDir, true); err != nil {
 		logger.Error("⚠️  Failed to load global state: %v", err)
+		return false, err
 	}
 
 	// 1. Try to read history.json from parent of downloads
 	historyPath := filepath.Join(filepath.Dir(m.InputDir), "history.json")
 
 	logger.Info("🔍 Checking history at: %s", historyPath)
-	content, err := os.ReadFile(historyPath)
+	if _, err := os.Stat(historyPath); err != nil {
+		logger.Error("❌ Could not read history.json: %v", err)
+		return false, err
+	}
+	reg, err := registry.New(historyPath)
 	if err != nil {
 		logger.Error("❌ Could not read history.json: %v", err)
 		return false, err
 	}
 
-	lines := strings.Split(string(content), "\n")
 	foundAny := false
 	workPerformed := false
 
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		var entry struct {
-			ID     string `json:"id"`
-			Status string `json:"status"`
-		}
-		if err := json.Unmarshal([]byte(line), &entry); err != nil {
-			continue
-		}
-
+	for _, entry := range reg.Exports {
 		if entry.ID == "" {
 			continue
 		}
@@ -85,12 +79,15 @@ func (m *Manager) ProcessExports() (bool, error) {
 			logger.Info("📂 Processing export: %s (Status: %s)", entry.ID, entry.Status)
 
 			// --- Per-Export Context Setup ---
-			m.FileIndex = make(map[string]FileMetadata)
-			// ... (rest of logic proceeds naturally)
+			m.resetFileIndex(false)
 			m.ProcessedArchives = make(map[string]bool)
 
 			// Load Local State (ALWAYS needed for index)
-			m.LoadState(exportDir, false)
+			if err := m.LoadState(exportDir, false); err != nil {
+				logger.Error("❌ Failed to load state of export %s: %v", entry.ID, err)
+				m.Failed[entry.ID] = err
+				continue
+			}
 
 			// If Forcing Extraction, ignore loaded "ProcessedArchive" state
 			if m.ForceExtraction {
@@ -100,6 +97,7 @@ func (m *Manager) ProcessExports() (bool, error) {
 			localRaw := filepath.Join(exportDir, "raw")
 			if err := os.MkdirAll(localRaw, 0755); err != nil {
 				logger.Error("❌ Failed to create raw dir for export %s: %v", entry.ID, err)
+				m.Failed[entry.ID] = err
 				continue
 			}
 
@@ -118,7 +116,7 @@ func (m *Manager) ProcessExports() (bool, error) {
 				// If we are forcing a deep deduplication check, we must DISCARD the loaded index
 				// to force a fresh re-scan of the files on disk.
 				if shouldDedup {
-					m.FileIndex = make(map[string]FileMetadata)
+					m.resetFileIndex(true)
 				}
 
 				m.ScanRaw(localRaw, needHash)
@@ -127,10 +125,14 @@ func (m *Manager) ProcessExports() (bool, error) {
 					// Process (Extract missing archives)
 					if err := m.processExport(entry.ID, exportDir, localRaw); err != nil {
 						logger.Error("❌ Error processing %s: %v", entry.ID, err)
+						m.Failed[entry.ID] = err
+						// Not marked as processed: update-backup must not pick up a partial extraction
+						m.SaveState(exportDir)
+						continue
 					}
 				}
 			} else {
-				if len(m.FileIndex) == 0 {
+				if !m.hasFiles() {
 					logger.Info("ℹ️  Index empty, scanning raw files (with hashes)...")
 					m.ScanRaw(localRaw, true)
 				}
@@ -195,7 +197,30 @@ func (m *Manager) processExport(id, dir, rawDir string) error {
 		}
 	}
 
-	// 2. Extract
+	// 2. Space: extracted content is about the archive size (media barely compresses).
+	// With DeleteOrigin each archive is removed once extracted, so only the largest counts.
+	var need, largest int64
+	for _, f := range state.Files {
+		if m.ProcessedArchives[id+"/"+f.Filename] {
+			continue
+		}
+		size := f.SizeBytes
+		if info, err := os.Stat(filepath.Join(dir, f.Filename)); err == nil {
+			size = info.Size()
+		}
+		need += size
+		if size > largest {
+			largest = size
+		}
+	}
+	if m.DeleteOrigin {
+		need = largest
+	}
+	if err := m.Space.Ensure(m.Context, need); err != nil {
+		return err
+	}
+
+	// 3. Extract
 	for _, f := range state.Files {
 		key := id + "/" + f.Filename
 		if m.ProcessedArchives[key] {
@@ -205,6 +230,13 @@ func (m *Manager) processExport(id, dir, rawDir string) error {
 		path := filepath.Join(dir, f.Filename)
 		ext := strings.ToLower(filepath.Ext(path))
 
+		// Re-check before each archive: other processes may have filled the disk meanwhile
+		if info, err := os.Stat(path); err == nil {
+			if err := m.Space.Ensure(m.Context, info.Size()); err != nil {
+				return err
+			}
+		}
+
 		logger.Info("➡️  Processing archive: %s", f.Filename)
 
 		if ext == ".zip" {
@@ -338,13 +370,13 @@ func (m *Manager) extractReader(name string, r io.Reader, dest string, mode os.F
 	hash := hex.EncodeToString(hasher.Sum(nil))
 	ext := strings.ToLower(filepath.Ext(fpath))
 
-	m.FileIndex[absPath] = FileMetadata{
+	m.setFile(absPath, FileMetadata{
 		Path:      absPath,
 		Hash:      hash,
 		Size:      written,
 		Extension: ext,
 		IsJSON:    ext == ".json",
-	}
+	})
 
 	return nil
 }
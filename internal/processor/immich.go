@@ -11,6 +11,8 @@ import (
 
 	"strings"
 
+	"google-photos-backup/internal/fsutil"
+	"google-photos-backup/internal/integrity"
 	"google-photos-backup/internal/logger"
 	"google-photos-backup/internal/registry"
 )
@@ -27,6 +29,7 @@ func EnsureSnapshotIndex(snapshotPath string) (*registry.Index, error) {
 		logger.Error("Failed to load existing index (will rebuild): %v", err)
 		existingIndex = registry.NewIndex()
 	}
+	defer existingIndex.Close()
 
 	newIndex := registry.NewIndex()
 	totalFiles := 0
@@ -39,8 +42,9 @@ func EnsureSnapshotIndex(snapshotPath string) (*registry.Index, error) {
 		if info.IsDir() {
 			return nil
 		}
-		// Skip index.json itself
-		if filepath.Base(path) == "index.json" {
+		// Skip index.json itself and the manifests derived from it, which only live at the
+		// snapshot root: a photo folder may hold files with the same names
+		if rel, _ := filepath.Rel(snapshotPath, path); rel == "index.json" || integrity.IsManifestFile(rel) {
 			return nil
 		}
 		// Skip system files
@@ -48,6 +52,16 @@ func EnsureSnapshotIndex(snapshotPath string) (*registry.Index, error) {
 			return nil
 		}
 
+		// Object store views may be symlinks: index the object they point to
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil {
+				logger.Error("Broken link %s: %v", path, err)
+				return nil
+			}
+			info = target
+		}
+
 		totalFiles++
 		relPath, _ := filepath.Rel(snapshotPath, path)
 
@@ -60,7 +74,8 @@ func EnsureSnapshotIndex(snapshotPath string) (*registry.Index, error) {
 
 		hash := ""
 		// Inode Optimization check
-		if existingEntry, ok := existingIndex.Get(relPath); ok {
+		existingEntry, hasExisting := existingIndex.Get(relPath)
+		if hasExisting {
 			// Check if Inode matches (and ModTime/Size for safety)
 			if existingEntry.Inode == inode &&
 				existingEntry.ModTime.Equal(info.ModTime()) &&
@@ -80,18 +95,26 @@ func EnsureSnapshotIndex(snapshotPath string) (*registry.Index, error) {
 			rehashedFiles++
 		}
 
+		// Keep the link generation assigned by fix-hardlinks while the content is unchanged
+		generation := 0
+		if hasExisting && existingEntry.Hash == hash {
+			generation = existingEntry.Generation
+		}
+
 		newIndex.AddOrUpdate(registry.FileIndexEntry{
-			RelPath: relPath,
-			Hash:    hash,
-			Size:    info.Size(),
-			ModTime: info.ModTime(),
-			Inode:   inode,
+			RelPath:    relPath,
+			Hash:       hash,
+			Size:       info.Size(),
+			ModTime:    info.ModTime(),
+			Inode:      inode,
+			Generation: generation,
 		})
 
 		return nil
 	})
 
 	if err != nil {
+		newIndex.Close()
 		return nil, err
 	}
 
@@ -99,30 +122,37 @@ func EnsureSnapshotIndex(snapshotPath string) (*registry.Index, error) {
 
 	// Save Index
 	if err := newIndex.Save(indexPath); err != nil {
+		newIndex.Close()
 		return nil, fmt.Errorf("failed to save index: %w", err)
 	}
 
+	// SHA256SUMS for verification with standard tools (derived from the index, no rehashing)
+	if _, err := integrity.WriteSums(snapshotPath, newIndex); err != nil {
+		newIndex.Close()
+		return nil, fmt.Errorf("failed to write %s: %w", integrity.SumsFileName, err)
+	}
+
 	return newIndex, nil
 }
 
 // LinkSnapshotToMaster integrates a snapshot into the master directory.
-// masterHashMap: Map[Hash] -> RelPath (in master)
-func LinkSnapshotToMaster(snapshotPath string, snapshotIndex *registry.Index, masterRoot string, masterIndex *registry.Index, masterHashMap map[string]string) error {
+// Content already in masterIndex (by hash) is skipped.
+func LinkSnapshotToMaster(snapshotPath string, snapshotIndex *registry.Index, masterRoot string, masterIndex *registry.Index) error {
 
-	for relPath, entry := range snapshotIndex.Files {
+	return snapshotIndex.ForEach(func(relPath string, entry registry.FileIndexEntry) error {
 		// 1. Check Deduplication
-		if _, exists := masterHashMap[entry.Hash]; exists {
+		if masterIndex.HasHash(entry.Hash) {
 			// Already in Master
-			continue
+			return nil
 		}
 
 		// Check ignored extensions
 		if IsIgnoredFile(relPath) {
-			continue
+			return nil
 		}
 
 		// 2. Not in Master: Link it
-		srcPath := filepath.Join(snapshotPath, relPath)
+		srcPath := resolveView(filepath.Join(snapshotPath, relPath))
 
 		// Destination: YYYY/MM/Filename
 		year := entry.ModTime.Format("2006")
@@ -155,13 +185,13 @@ func LinkSnapshotToMaster(snapshotPath string, snapshotIndex *registry.Index, ma
 			counter++
 		}
 
-		// Create Hardlink
-		if err := os.Link(srcPath, destFullPath); err != nil {
+		// Create Link (configured strategy)
+		if _, err := fsutil.Default().Link(srcPath, destFullPath); err != nil {
 			logger.Error("Failed to link to master %s: %v", destRelPath, err)
-			continue
+			return nil
 		}
 
-		// Update Master Index & Hash Map
+		// Update Master Index
 		// Get Inode of the new link
 		var inode uint64
 		if info, err := os.Stat(destFullPath); err == nil {
@@ -178,13 +208,23 @@ func LinkSnapshotToMaster(snapshotPath string, snapshotIndex *registry.Index, ma
 			Inode:   inode,
 		}
 		masterIndex.AddOrUpdate(newEntry)
-		masterHashMap[entry.Hash] = destRelPath
-	}
-	return nil
+		return nil
+	})
 }
 
 // Helpers
 
+// resolveView returns the file behind a symlinked view of the object store, so that a
+// hardlink to it does not copy a relative symlink into another directory.
+func resolveView(path string) string {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			return resolved
+		}
+	}
+	return path
+}
+
 func calculateHash(filePath string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -199,14 +239,6 @@ func calculateHash(filePath string) (string, error) {
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-func GetMasterHashMap(idx *registry.Index) map[string]string {
-	m := make(map[string]string)
-	for path, entry := range idx.Files {
-		m[entry.Hash] = path
-	}
-	return m
-}
-
 // IsIgnoredFile checks if a file should be excluded from Immich Master
 func IsIgnoredFile(filename string) bool {
 	ext := strings.ToLower(filepath.Ext(filename))
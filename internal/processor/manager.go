@@ -1,6 +1,7 @@
 package processor
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -11,6 +12,8 @@ import (
 	"syscall"
 	"time"
 
+	"google-photos-backup/internal/diskspace"
+	"google-photos-backup/internal/kvindex"
 	"google-photos-backup/internal/logger"
 )
 
@@ -19,14 +22,13 @@ type Manager struct {
 	OutputDir            string
 	AlbumsDir            string
 	DeleteOrigin         bool
-	TargetExport         string // If set, process only this Export ID
-	ForceMetadata        bool   // Force metadata correction even if export is done
-	ForceExtraction      bool   // Force extraction even if export is done
-	ForceDedup           bool   // Force global deduplication check
-	FixAmbiguousMetadata string // "yes", "no", "interactive"
-
-	// Index: Key = Absolute Path, Value = Metadata
-	FileIndex map[string]FileMetadata
+	TargetExport         string           // If set, process only this Export ID
+	ForceMetadata        bool             // Force metadata correction even if export is done
+	ForceExtraction      bool             // Force extraction even if export is done
+	ForceDedup           bool             // Force global deduplication check
+	FixAmbiguousMetadata string           // "yes", "no", "interactive"
+	Space                *diskspace.Guard // Free-space checks before extracting (nil: none)
+	Context              context.Context  // Cancels a free-space pause (nil: waits for space)
 
 	// Set of processed Export IDs to avoid reprocessing
 	ProcessedExports map[string]bool
@@ -37,6 +39,17 @@ type Manager struct {
 
 	// Index: Key = Inode, Value = Absolute Path (First seen)
 	InodeIndex map[uint64]string
+
+	// Exports that failed in this run and were not marked as processed
+	Failed map[string]error
+
+	// File index of the current export (see file and forEachFile): the directory it is
+	// saved to, its processing_index.db (read on demand, never loaded whole), the files
+	// not saved yet, and whether the saved entries are ignored and cleared on next save
+	indexDir     string
+	savedFiles   *kvindex.Index[FileMetadata]
+	pendingFiles map[string]FileMetadata
+	discardSaved bool
 }
 
 type FileMetadata struct {
@@ -52,10 +65,11 @@ func NewManager(inputDir, outputDir, albumsDir string) *Manager {
 		InputDir:          inputDir,
 		OutputDir:         outputDir,
 		AlbumsDir:         albumsDir,
-		FileIndex:         make(map[string]FileMetadata),
 		ProcessedExports:  make(map[string]bool),
 		ProcessedArchives: make(map[string]bool),
 		InodeIndex:        make(map[uint64]string),
+		Failed:            make(map[string]error),
+		pendingFiles:      make(map[string]FileMetadata),
 	}
 }
 
@@ -94,7 +108,7 @@ func (m *Manager) ScanRaw(dir string, computeHash bool) error {
 		if info.IsDir() {
 			return nil
 		}
-		if info.Name() == "processing_index.json" || info.Name() == "state.json" || info.Name() == ".DS_Store" {
+		if info.Name() == IndexFileName || info.Name() == IndexDBName || info.Name() == "state.json" || info.Name() == ".DS_Store" {
 			return nil
 		}
 
@@ -105,7 +119,7 @@ func (m *Manager) ScanRaw(dir string, computeHash bool) error {
 
 		absPath, _ := filepath.Abs(path)
 		// Skip if already in index
-		if _, ok := m.FileIndex[absPath]; ok {
+		if _, ok := m.file(absPath); ok {
 			return nil
 		}
 
@@ -121,7 +135,7 @@ func (m *Manager) ScanRaw(dir string, computeHash bool) error {
 		// Check Inode Cache
 		if existingPath, ok := m.InodeIndex[inode]; ok {
 			// Found same inode! Reuse hash from existing file
-			if meta, exists := m.FileIndex[existingPath]; exists {
+			if meta, exists := m.file(existingPath); exists {
 				hash = meta.Hash
 			}
 		}
@@ -143,13 +157,13 @@ func (m *Manager) ScanRaw(dir string, computeHash bool) error {
 
 		// Update Indices
 		ext := strings.ToLower(filepath.Ext(path))
-		m.FileIndex[absPath] = FileMetadata{
+		m.setFile(absPath, FileMetadata{
 			Path:      absPath,
 			Hash:      hash,
 			Size:      info.Size(),
 			Extension: ext,
 			IsJSON:    ext == ".json",
-		}
+		})
 
 		// Register Inode if it has a hash and not already in index
 		if hash != "" {
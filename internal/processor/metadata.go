@@ -45,12 +45,18 @@ func (m *Manager) CorrectMetadata() error {
 	jsonFiles := make(map[string]bool)
 	jsonByDir := make(map[string][]string)
 
-	for path, meta := range m.FileIndex {
+	totalFiles := 0
+	err := m.forEachFile(func(meta FileMetadata) error {
+		totalFiles++
 		if meta.IsJSON {
-			jsonFiles[path] = true
-			dir := filepath.Dir(path)
-			jsonByDir[dir] = append(jsonByDir[dir], filepath.Base(path))
+			jsonFiles[meta.Path] = true
+			dir := filepath.Dir(meta.Path)
+			jsonByDir[dir] = append(jsonByDir[dir], filepath.Base(meta.Path))
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	logger.Info("   Indexed %d JSON sidecars.", len(jsonFiles))
@@ -60,12 +66,13 @@ func (m *Manager) CorrectMetadata() error {
 	secureMatches := make(map[string]string)      // image -> json
 
 	// 2. Match Media Files - Pass 1: Secure Matches & Ambiguous Collection
-	for mediaPath, meta := range m.FileIndex {
+	err = m.forEachFile(func(meta FileMetadata) error {
 		if meta.IsJSON {
-			continue
+			return nil
 		}
+		mediaPath := meta.Path
 
-		bestJSON, isAmbiguous, ambiguousCandidate := m.findBestJSON(mediaPath, jsonFiles, jsonByDir)
+		bestJSON, isAmbiguous, ambiguousCandidate := findBestJSON(mediaPath, jsonFiles, jsonByDir)
 		if bestJSON != "" {
 			secureMatches[mediaPath] = bestJSON
 		} else if isAmbiguous {
@@ -74,6 +81,10 @@ func (m *Manager) CorrectMetadata() error {
 			list = append(list, mediaPath)
 			ambiguousMatches[ambiguousCandidate] = list
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Apply Secure Matches
@@ -112,8 +123,8 @@ func (m *Manager) CorrectMetadata() error {
 			// Show Full Summary (Interactive or No)
 
 			// Internationalized Messages
-			msgEn := fmt.Sprintf("\n⚠️  Found %d secure matches. For %d other files, a secure JSON could not be identified, but %d of them have a POSSIBLE match if we ignore filename length safety checks.", secureCount, len(m.FileIndex)-len(jsonFiles)-secureCount, ambiguousCount)
-			msgEs := fmt.Sprintf("\n⚠️  Se encontraron %d coincidencias seguras. Para otros %d archivos no se pudo identificar un JSON seguro, pero %d de ellos tienen una coincidencia POSIBLE si ignoramos las comprobaciones de seguridad de longitud de nombre.", secureCount, len(m.FileIndex)-len(jsonFiles)-secureCount, ambiguousCount)
+			msgEn := fmt.Sprintf("\n⚠️  Found %d secure matches. For %d other files, a secure JSON could not be identified, but %d of them have a POSSIBLE match if we ignore filename length safety checks.", secureCount, totalFiles-len(jsonFiles)-secureCount, ambiguousCount)
+			msgEs := fmt.Sprintf("\n⚠️  Se encontraron %d coincidencias seguras. Para otros %d archivos no se pudo identificar un JSON seguro, pero %d de ellos tienen una coincidencia POSIBLE si ignoramos las comprobaciones de seguridad de longitud de nombre.", secureCount, totalFiles-len(jsonFiles)-secureCount, ambiguousCount)
 
 			fmt.Println(msgEn)
 			fmt.Println(msgEs)
@@ -178,8 +189,15 @@ func (m *Manager) CorrectMetadata() error {
 	return nil
 }
 
+// MatchSidecar returns the JSON sidecar of mediaPath, or "" when there is no secure match.
+// jsonFiles holds every sidecar path, jsonByDir their names grouped by directory.
+func MatchSidecar(mediaPath string, jsonFiles map[string]bool, jsonByDir map[string][]string) string {
+	jsonPath, _, _ := findBestJSON(mediaPath, jsonFiles, jsonByDir)
+	return jsonPath
+}
+
 // findBestJSON implements the heuristics to find the matching JSON file
-func (m *Manager) findBestJSON(mediaPath string, allJsonFiles map[string]bool, jsonByDir map[string][]string) (string, bool, string) {
+func findBestJSON(mediaPath string, allJsonFiles map[string]bool, jsonByDir map[string][]string) (string, bool, string) {
 	mediaName := filepath.Base(mediaPath)
 	dir := filepath.Dir(mediaPath)
 
@@ -271,17 +289,22 @@ func isMediaExt(ext string) bool {
 	return false
 }
 
-func (m *Manager) applyDate(mediaPath, jsonPath string) error {
+// ReadSidecar parses a Google Photos JSON sidecar
+func ReadSidecar(jsonPath string) (*PhotoMetadata, error) {
 	data, err := os.ReadFile(jsonPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var meta PhotoMetadata
 	if err := json.Unmarshal(data, &meta); err != nil {
-		return err
+		return nil, err
 	}
+	return &meta, nil
+}
 
+// TakenAt returns the capture date of the sidecar's photo
+func (meta *PhotoMetadata) TakenAt() (time.Time, error) {
 	// Prefer PhotoTakenTime, fall back to CreationTime
 	tsStr := meta.PhotoTakenTime.Timestamp
 	if tsStr == "" || tsStr == "0" {
@@ -289,15 +312,25 @@ func (m *Manager) applyDate(mediaPath, jsonPath string) error {
 	}
 
 	if tsStr == "" || tsStr == "0" {
-		return fmt.Errorf("no valid timestamp found")
+		return time.Time{}, fmt.Errorf("no valid timestamp found")
 	}
 
 	ts, err := strconv.ParseInt(tsStr, 10, 64)
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
+	return time.Unix(ts, 0), nil
+}
 
-	t := time.Unix(ts, 0)
+func (m *Manager) applyDate(mediaPath, jsonPath string) error {
+	meta, err := ReadSidecar(jsonPath)
+	if err != nil {
+		return err
+	}
+	t, err := meta.TakenAt()
+	if err != nil {
+		return err
+	}
 
 	// Apply to file (Mtime and Atime)
 	return os.Chtimes(mediaPath, t, t)
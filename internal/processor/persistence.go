@@ -2,82 +2,459 @@ package processor
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	"google-photos-backup/internal/kvindex"
 	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/persist"
+	"google-photos-backup/internal/schema"
 )
 
-// persistence.go handles saving/loading the processing state
+// persistence.go handles saving/loading the processing state. processing_index.json only
+// holds the small sets (processed exports and archives); the file index of an export
+// lives in processing_index.db (see kvindex) and is updated incrementally.
 
-const IndexFileName = "processing_index.json"
+const (
+	IndexFileName = "processing_index.json"
+	IndexDBName   = "processing_index.db"
+)
 
 type State struct {
-	FileIndex         map[string]FileMetadata `json:"file_index"`
-	ProcessedExports  map[string]bool         `json:"processed_exports"`
-	ProcessedArchives map[string]bool         `json:"processed_archives"`
+	SchemaVersion     int             `json:"schema_version"`
+	ProcessedExports  map[string]bool `json:"processed_exports"`
+	ProcessedArchives map[string]bool `json:"processed_archives"`
 }
 
-func (m *Manager) LoadState(dir string, isGlobal bool) error {
-	indexPath := filepath.Join(dir, IndexFileName)
-	data, err := os.ReadFile(indexPath)
-	if os.IsNotExist(err) {
-		// No custom index found, check if global one exists? No, usage is specific.
+func init() {
+	schema.Register(schema.Migration{Format: schema.ProcessingIndex, From: 1, Description: "move file_index to " + IndexDBName, Apply: moveFileIndex})
+}
+
+// moveFileIndex writes the file_index of a v1 processing index to processing_index.db
+// next to it, unless that database already exists
+func moveFileIndex(ctx schema.Context, doc schema.Doc) error {
+	raw, ok := doc["file_index"]
+	delete(doc, "file_index")
+	if !ok || ctx.DryRun {
 		return nil
 	}
+	dbPath := filepath.Join(filepath.Dir(ctx.Path), IndexDBName)
+	if _, err := os.Stat(dbPath); err == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(raw)
 	if err != nil {
 		return err
 	}
+	var index map[string]FileMetadata
+	if err := json.Unmarshal(data, &index); err != nil {
+		return err
+	}
+	if len(index) == 0 {
+		return nil
+	}
+	return kvindex.Replace(dbPath, func(tx *kvindex.Tx[FileMetadata]) error {
+		for path, meta := range index {
+			meta.Path = path
+			if err := tx.Put(path, indexHash(meta), meta); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
 
+// indexHash is the hash a file is grouped under for deduplication: sidecars are never
+// deduplicated
+func indexHash(meta FileMetadata) string {
+	if meta.IsJSON {
+		return ""
+	}
+	return meta.Hash
+}
+
+// ReadState reads the processing index stored in dir without touching any Manager state
+func ReadState(dir string) (*State, error) {
 	var savedState State
+	path := filepath.Join(dir, IndexFileName)
+	err := persist.Load(path, func(data []byte) error {
+		savedState = State{}
+		data, _, err := schema.Upgrade(schema.Context{Path: path}, schema.ProcessingIndex, data)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, &savedState)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &savedState, nil
+}
 
-	if err := json.Unmarshal(data, &savedState); err != nil {
+// saveStateFile atomically writes the processing index of dir, keeping the previous one as .bak
+func saveStateFile(dir string, state *State) error {
+	state.SchemaVersion = schema.Current(schema.ProcessingIndex)
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return persist.WriteState(filepath.Join(dir, IndexFileName), data, 0644)
+}
+
+func (m *Manager) LoadState(dir string, isGlobal bool) error {
+	savedState, err := ReadState(dir)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
 	// Load state specifically
 	if isGlobal {
-		if savedState.ProcessedExports != nil {
+		if savedState != nil && savedState.ProcessedExports != nil {
 			m.ProcessedExports = savedState.ProcessedExports
 		}
-	} else {
-		// Verify existence of files (basic check)
-		validCount := 0
-		for path, meta := range savedState.FileIndex {
-			if _, err := os.Stat(path); err == nil {
-				m.FileIndex[path] = meta
-				validCount++
-			}
+		return nil
+	}
+
+	if savedState != nil && savedState.ProcessedArchives != nil {
+		m.ProcessedArchives = savedState.ProcessedArchives
+	}
+
+	// The file index of this export stays on disk and is saved back to dir
+	m.closeFileIndex()
+	index, err := kvindex.Open[FileMetadata](filepath.Join(dir, IndexDBName))
+	if err != nil {
+		return err
+	}
+	m.indexDir = dir
+	m.savedFiles = index
+	if n, err := index.Len(); err == nil {
+		logger.Info("📥 Loaded local state: %d files.", n)
+	}
+	return nil
+}
+
+// closeFileIndex releases the processing_index.db of the current export
+func (m *Manager) closeFileIndex() {
+	if m.savedFiles != nil {
+		m.savedFiles.Close()
+		m.savedFiles = nil
+	}
+}
+
+func (m *Manager) SaveState(dir string) error {
+	if dir == m.indexDir {
+		if err := m.flushFileIndex(); err != nil {
+			return err
 		}
+	}
+	return saveStateFile(dir, &State{
+		ProcessedExports:  m.ProcessedExports,
+		ProcessedArchives: m.ProcessedArchives,
+	})
+}
 
-		if savedState.ProcessedArchives != nil {
-			m.ProcessedArchives = savedState.ProcessedArchives
+// setFile records a file of the current export. It is written to processing_index.db by
+// the next SaveState.
+func (m *Manager) setFile(path string, meta FileMetadata) {
+	m.pendingFiles[path] = meta
+}
+
+// file returns the metadata recorded for the file at path (absolute), looking it up in
+// processing_index.db unless it was recorded since the last save
+func (m *Manager) file(path string) (FileMetadata, bool) {
+	if meta, ok := m.pendingFiles[path]; ok {
+		return meta, true
+	}
+	if m.savedFiles == nil || m.discardSaved {
+		return FileMetadata{}, false
+	}
+	meta, found, err := m.savedFiles.Get(relativePath(m.indexDir, path))
+	if err != nil || !found {
+		return FileMetadata{}, false
+	}
+	meta.Path = path
+	return meta, true
+}
+
+// hasFiles reports whether any file of the current export is recorded
+func (m *Manager) hasFiles() bool {
+	if len(m.pendingFiles) > 0 {
+		return true
+	}
+	if m.savedFiles == nil || m.discardSaved {
+		return false
+	}
+	n, err := m.savedFiles.Len()
+	return err == nil && n > 0
+}
+
+// forEachFile calls fn for every file of the current export, in path order, with
+// meta.Path absolute. The pending files are saved first so that the whole index streams
+// from processing_index.db.
+func (m *Manager) forEachFile(fn func(meta FileMetadata) error) error {
+	if m.savedFiles == nil {
+		paths := make([]string, 0, len(m.pendingFiles))
+		for path := range m.pendingFiles {
+			paths = append(paths, path)
 		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			if err := fn(m.pendingFiles[path]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := m.flushFileIndex(); err != nil {
+		return err
+	}
+	return m.savedFiles.ForEach(func(key, _ string, meta FileMetadata) error {
+		meta.Path = absolutePath(m.indexDir, key)
+		return fn(meta)
+	})
+}
 
-		logger.Info("📥 Loaded local state: %d files.", validCount)
+// resetFileIndex forgets the files recorded since the last save. With discardSaved, the
+// saved index of the export is also ignored, and cleared on the next SaveState (used to
+// force a fresh scan).
+func (m *Manager) resetFileIndex(discardSaved bool) {
+	m.pendingFiles = make(map[string]FileMetadata)
+	m.discardSaved = discardSaved
+}
+
+// flushFileIndex writes the files recorded since the last save in one transaction
+func (m *Manager) flushFileIndex() error {
+	if len(m.pendingFiles) == 0 && !m.discardSaved {
+		return nil
 	}
+	if m.savedFiles == nil {
+		return fmt.Errorf("no export file index is open")
+	}
+	dir := m.indexDir
 
+	err := m.savedFiles.Update(func(tx *kvindex.Tx[FileMetadata]) error {
+		if m.discardSaved {
+			if err := tx.Clear(); err != nil {
+				return err
+			}
+		}
+		for path, meta := range m.pendingFiles {
+			key := relativePath(dir, path)
+			meta.Path = key
+			if err := tx.Put(key, indexHash(meta), meta); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m.pendingFiles = make(map[string]FileMetadata)
+	m.discardSaved = false
 	return nil
 }
 
-func (m *Manager) SaveState(dir string) error {
-	// Use a mutex if we were parallel, but serial is fine.
-	indexPath := filepath.Join(dir, IndexFileName)
+// MarkExportProcessed records id as completely processed in the processing index of dir
+func MarkExportProcessed(dir, id string) error {
+	state, err := ReadState(dir)
+	if err != nil {
+		return err
+	}
+	if state.ProcessedExports == nil {
+		state.ProcessedExports = make(map[string]bool)
+	}
+	state.ProcessedExports[id] = true
+	return saveStateFile(dir, state)
+}
 
-	state := State{
-		FileIndex:         m.FileIndex,
-		ProcessedExports:  m.ProcessedExports,
-		ProcessedArchives: m.ProcessedArchives,
+// ForgetExport removes id and its archives from the processing index of dir, so the
+// next 'process' handles the export again. A missing index is not an error.
+func ForgetExport(dir, id string) error {
+	state, err := ReadState(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
 	}
+	if !state.ProcessedExports[id] && !hasArchivesOf(state, id) {
+		return nil
+	}
+	delete(state.ProcessedExports, id)
+	for key := range state.ProcessedArchives {
+		if strings.HasPrefix(key, id+"/") {
+			delete(state.ProcessedArchives, key)
+		}
+	}
+	return saveStateFile(dir, state)
+}
 
-	// Atomic write?
-	// Marshal first
-	data, err := json.MarshalIndent(state, "", "  ")
+func hasArchivesOf(state *State, id string) bool {
+	for key := range state.ProcessedArchives {
+		if strings.HasPrefix(key, id+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// UpgradeState rewrites the processing index of dir in the current schema version
+func UpgradeState(dir string) error {
+	state, err := ReadState(dir)
+	if err != nil {
+		return err
+	}
+	return saveStateFile(dir, state)
+}
+
+// ExportIndex is the saved file index of one export, read from disk on demand instead
+// of being loaded into memory. A nil or empty ExportIndex has no files.
+type ExportIndex struct {
+	dir   string
+	index *kvindex.Index[FileMetadata]
+}
+
+// OpenExportIndex opens the file index of exportDir for reading, migrating a legacy
+// processing_index.json on the way. An export without index gives an empty ExportIndex.
+func OpenExportIndex(exportDir string) (*ExportIndex, error) {
+	absDir, err := filepath.Abs(exportDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ReadState(absDir); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	e := &ExportIndex{dir: absDir}
+	e.index, err = kvindex.OpenReadOnly[FileMetadata](filepath.Join(absDir, IndexDBName))
+	if os.IsNotExist(err) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Close releases the index
+func (e *ExportIndex) Close() {
+	if e != nil && e.index != nil {
+		e.index.Close()
+	}
+}
+
+// Empty reports whether the export has no saved file index
+func (e *ExportIndex) Empty() bool {
+	if e == nil || e.index == nil {
+		return true
+	}
+	n, err := e.index.Len()
+	return err != nil || n == 0
+}
+
+// Get returns the metadata saved for the file at path (absolute)
+func (e *ExportIndex) Get(path string) (FileMetadata, bool) {
+	if e == nil || e.index == nil {
+		return FileMetadata{}, false
+	}
+	meta, found, err := e.index.Get(relativePath(e.dir, path))
+	if err != nil || !found {
+		return FileMetadata{}, false
+	}
+	meta.Path = path
+	return meta, true
+}
+
+// ForEach calls fn for every file of the export, with meta.Path absolute
+func (e *ExportIndex) ForEach(fn func(meta FileMetadata) error) error {
+	if e == nil || e.index == nil {
+		return nil
+	}
+	return e.index.ForEach(func(key, _ string, meta FileMetadata) error {
+		meta.Path = absolutePath(e.dir, key)
+		return fn(meta)
+	})
+}
+
+// RewriteStatePaths passes every absolute path stored in the file index of dir through
+// rewrite and stores it relative to dir. Used when the working directory moves; legacy
+// indexes (absolute keys) are migrated on the way.
+func RewriteStatePaths(dir string, rewrite func(string) string) error {
+	savedState, err := ReadState(dir)
+	if err != nil {
+		return err
+	}
+	if err := saveStateFile(dir, savedState); err != nil {
+		return err
+	}
+
+	dbPath := filepath.Join(dir, IndexDBName)
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return nil
+	}
+	index, err := kvindex.Open[FileMetadata](dbPath)
 	if err != nil {
 		return err
 	}
+	defer index.Close()
 
-	return os.WriteFile(indexPath, data, 0644)
+	// Only legacy entries are absolute: collect them, then move them in one transaction
+	var legacy []FileMetadata
+	err = index.ForEach(func(key, _ string, meta FileMetadata) error {
+		if filepath.IsAbs(key) {
+			meta.Path = key
+			legacy = append(legacy, meta)
+		}
+		return nil
+	})
+	if err != nil || len(legacy) == 0 {
+		return err
+	}
+	return index.Update(func(tx *kvindex.Tx[FileMetadata]) error {
+		for _, meta := range legacy {
+			if err := tx.Delete(meta.Path); err != nil {
+				return err
+			}
+			meta.Path = relativePath(dir, rewrite(meta.Path))
+			if err := tx.Put(meta.Path, indexHash(meta), meta); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// relativePath returns path relative to dir when it is inside it, so the index survives
+// moving the working directory. Paths outside dir stay absolute.
+func relativePath(dir, path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(absDir, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return rel
+	}
+	return path
+}
+
+// absolutePath is the inverse of relativePath: relative paths are resolved against dir.
+// Legacy absolute paths are returned unchanged.
+func absolutePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return path
+	}
+	return filepath.Join(absDir, path)
 }
 
 // RebuildIndexFromDiskIfMissing scans the output directory to rebuild hashes
@@ -102,7 +479,7 @@ func (m *Manager) RebuildIndexFromDisk() error {
 
 		// Skip if already in index
 		absPath, _ := filepath.Abs(path)
-		if _, ok := m.FileIndex[absPath]; ok {
+		if _, ok := m.file(absPath); ok {
 			return nil
 		}
 
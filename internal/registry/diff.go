@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"sort"
+	"time"
+)
+
+// ChangeType classifies a single difference between two indexes
+type ChangeType string
+
+const (
+	ChangeAdded        ChangeType = "added"
+	ChangeRemoved      ChangeType = "removed"
+	ChangeModified     ChangeType = "modified"      // Same path, different hash
+	ChangeMoved        ChangeType = "moved"         // Same hash, different path
+	ChangeMetadataOnly ChangeType = "metadata_only" // Same path and hash, different mtime
+)
+
+// Change represents one entry of an IndexDiff
+type Change struct {
+	Type    ChangeType `json:"type"`
+	Path    string     `json:"path"`               // Path in the newer index (or older one for removals)
+	OldPath string     `json:"old_path,omitempty"` // Only for moves
+	Hash    string     `json:"hash"`
+	OldHash string     `json:"old_hash,omitempty"` // Only for modifications
+	Size    int64      `json:"size"`
+	ModTime time.Time  `json:"mod_time"`
+	OldTime *time.Time `json:"old_mod_time,omitempty"` // Older mtime, for modifications, moves and metadata changes
+}
+
+// IndexDiff is the result of comparing an older index against a newer one
+type IndexDiff struct {
+	Changes   []Change `json:"changes"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// Count returns the number of changes of the given type
+func (d *IndexDiff) Count(t ChangeType) int {
+	n := 0
+	for _, c := range d.Changes {
+		if c.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+// DiffIndexes compares two indexes keyed by relative path.
+// Paths present only on one side are paired by hash to detect moves before
+// being reported as plain additions or removals.
+func DiffIndexes(older, newer *Index) (*IndexDiff, error) {
+	diff := &IndexDiff{}
+
+	var removed, added []FileIndexEntry
+
+	err := older.ForEach(func(relPath string, oldEntry FileIndexEntry) error {
+		newEntry, ok := newer.Get(relPath)
+		if !ok {
+			removed = append(removed, oldEntry)
+			return nil
+		}
+
+		switch {
+		case oldEntry.Hash != newEntry.Hash:
+			diff.Changes = append(diff.Changes, Change{
+				Type:    ChangeModified,
+				Path:    relPath,
+				Hash:    newEntry.Hash,
+				OldHash: oldEntry.Hash,
+				Size:    newEntry.Size,
+				ModTime: newEntry.ModTime,
+				OldTime: &oldEntry.ModTime,
+			})
+		case !oldEntry.ModTime.Equal(newEntry.ModTime):
+			diff.Changes = append(diff.Changes, Change{
+				Type:    ChangeMetadataOnly,
+				Path:    relPath,
+				Hash:    newEntry.Hash,
+				Size:    newEntry.Size,
+				ModTime: newEntry.ModTime,
+				OldTime: &oldEntry.ModTime,
+			})
+		default:
+			diff.Unchanged++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Both sides are walked in path order, so that pairing of moves is deterministic
+	err = newer.ForEach(func(relPath string, newEntry FileIndexEntry) error {
+		if _, ok := older.Get(relPath); !ok {
+			added = append(added, newEntry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Hash -> Removed entries still waiting for a partner
+	removedByHash := make(map[string][]FileIndexEntry)
+	for _, e := range removed {
+		removedByHash[e.Hash] = append(removedByHash[e.Hash], e)
+	}
+
+	for _, e := range added {
+		if candidates := removedByHash[e.Hash]; len(candidates) > 0 {
+			oldEntry := candidates[0]
+			removedByHash[e.Hash] = candidates[1:]
+			diff.Changes = append(diff.Changes, Change{
+				Type:    ChangeMoved,
+				Path:    e.RelPath,
+				OldPath: oldEntry.RelPath,
+				Hash:    e.Hash,
+				Size:    e.Size,
+				ModTime: e.ModTime,
+				OldTime: &oldEntry.ModTime,
+			})
+			continue
+		}
+		diff.Changes = append(diff.Changes, Change{
+			Type:    ChangeAdded,
+			Path:    e.RelPath,
+			Hash:    e.Hash,
+			Size:    e.Size,
+			ModTime: e.ModTime,
+		})
+	}
+
+	for _, e := range removed {
+		// Only the ones not consumed by a move are left in removedByHash
+		left := removedByHash[e.Hash]
+		if len(left) == 0 || left[0].RelPath != e.RelPath {
+			continue
+		}
+		removedByHash[e.Hash] = left[1:]
+		diff.Changes = append(diff.Changes, Change{
+			Type:    ChangeRemoved,
+			Path:    e.RelPath,
+			Hash:    e.Hash,
+			Size:    e.Size,
+			ModTime: e.ModTime,
+		})
+	}
+
+	sort.SliceStable(diff.Changes, func(i, j int) bool {
+		if diff.Changes[i].Type != diff.Changes[j].Type {
+			return diff.Changes[i].Type < diff.Changes[j].Type
+		}
+		return diff.Changes[i].Path < diff.Changes[j].Path
+	})
+
+	return diff, nil
+}
@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffIndexes(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+	entry := func(relPath, hash string, mtime time.Time) FileIndexEntry {
+		return FileIndexEntry{RelPath: relPath, Hash: hash, Size: 10, ModTime: mtime}
+	}
+
+	tests := []struct {
+		name      string
+		older     []FileIndexEntry
+		newer     []FileIndexEntry
+		want      []Change
+		unchanged int
+	}{
+		{
+			name:      "unchanged",
+			older:     []FileIndexEntry{entry("a.jpg", "h1", t0)},
+			newer:     []FileIndexEntry{entry("a.jpg", "h1", t0)},
+			unchanged: 1,
+		},
+		{
+			name:  "added and removed",
+			older: []FileIndexEntry{entry("a.jpg", "h1", t0)},
+			newer: []FileIndexEntry{entry("b.jpg", "h2", t1)},
+			want: []Change{
+				{Type: ChangeAdded, Path: "b.jpg", Hash: "h2", Size: 10, ModTime: t1},
+				{Type: ChangeRemoved, Path: "a.jpg", Hash: "h1", Size: 10, ModTime: t0},
+			},
+		},
+		{
+			name:  "modified",
+			older: []FileIndexEntry{entry("a.jpg", "h1", t0)},
+			newer: []FileIndexEntry{entry("a.jpg", "h2", t1)},
+			want: []Change{
+				{Type: ChangeModified, Path: "a.jpg", Hash: "h2", OldHash: "h1", Size: 10, ModTime: t1, OldTime: &t0},
+			},
+		},
+		{
+			name:  "metadata only",
+			older: []FileIndexEntry{entry("a.jpg", "h1", t0)},
+			newer: []FileIndexEntry{entry("a.jpg", "h1", t1)},
+			want: []Change{
+				{Type: ChangeMetadataOnly, Path: "a.jpg", Hash: "h1", Size: 10, ModTime: t1, OldTime: &t0},
+			},
+		},
+		{
+			name:  "moved",
+			older: []FileIndexEntry{entry("Album/a.jpg", "h1", t0)},
+			newer: []FileIndexEntry{entry("Other/a.jpg", "h1", t1)},
+			want: []Change{
+				{Type: ChangeMoved, Path: "Other/a.jpg", OldPath: "Album/a.jpg", Hash: "h1", Size: 10, ModTime: t1, OldTime: &t0},
+			},
+		},
+		{
+			// Two copies removed, one added: the first removed path (in path order) is the
+			// move, the other one a removal
+			name:  "moves paired by hash in path order",
+			older: []FileIndexEntry{entry("x/1.jpg", "h1", t0), entry("x/2.jpg", "h1", t0), entry("keep.jpg", "h3", t0)},
+			newer: []FileIndexEntry{entry("y/1.jpg", "h1", t0), entry("z.jpg", "h2", t0), entry("keep.jpg", "h3", t0)},
+			want: []Change{
+				{Type: ChangeAdded, Path: "z.jpg", Hash: "h2", Size: 10, ModTime: t0},
+				{Type: ChangeMoved, Path: "y/1.jpg", OldPath: "x/1.jpg", Hash: "h1", Size: 10, ModTime: t0, OldTime: &t0},
+				{Type: ChangeRemoved, Path: "x/2.jpg", Hash: "h1", Size: 10, ModTime: t0},
+			},
+			unchanged: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			older, newer := NewIndex(), NewIndex()
+			defer older.Close()
+			defer newer.Close()
+			for _, e := range tt.older {
+				older.AddOrUpdate(e)
+			}
+			for _, e := range tt.newer {
+				newer.AddOrUpdate(e)
+			}
+
+			diff, err := DiffIndexes(older, newer)
+			if err != nil {
+				t.Fatalf("DiffIndexes: %v", err)
+			}
+			if !reflect.DeepEqual(diff.Changes, tt.want) {
+				t.Errorf("changes = %+v\nwant %+v", diff.Changes, tt.want)
+			}
+			if diff.Unchanged != tt.unchanged {
+				t.Errorf("unchanged = %d, want %d", diff.Unchanged, tt.unchanged)
+			}
+		})
+	}
+}
@@ -1,68 +1,378 @@
 package registry
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
+	"sort"
 	"time"
+
+	"google-photos-backup/internal/kvindex"
+	"google-photos-backup/internal/persist"
+	"google-photos-backup/internal/schema"
+)
+
+// index.go is the file index of a snapshot or of the Immich master. On disk it stays
+// index.json (the sealed manifest); in memory an index holds its entries in a map only
+// while it is small, and past spillThreshold moves them to a scratch kvindex, so that
+// indexes of any size are read, iterated in path order and looked up by hash without
+// being held whole. Close removes the scratch file.
+
+// spillThreshold is the number of entries an index keeps in memory before moving them to
+// disk. Once on disk, writes are buffered and committed flushBatch at a time.
+var (
+	spillThreshold = 50000
+	flushBatch     = 10000
 )
 
 // FileIndexEntry represents a single file's metadata for deduplication
 type FileIndexEntry struct {
-	RelPath string    `json:"rel_path"`
-	Hash    string    `json:"hash"`
-	Size    int64     `json:"size"`
-	ModTime time.Time `json:"mod_time"`
-	Inode   uint64    `json:"inode,omitempty"` // Optimization for local filesystem
+	RelPath    string    `json:"rel_path"`
+	Hash       string    `json:"hash"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	Inode      uint64    `json:"inode,omitempty"`      // Optimization for local filesystem
+	Generation int       `json:"generation,omitempty"` // Separate inode for this content once the hardlink limit (EMLINK) was hit
 }
 
-// Index represents the complete index of a directory (snapshot or master)
+// Index represents the complete index of a directory (snapshot or master), keyed by
+// relative path
 type Index struct {
-	Files map[string]FileIndexEntry `json:"files"` // Key can be RelPath or Hash depending on usage, usually RelPath
+	files  map[string]FileIndexEntry // Every entry while in memory; once on disk, the unflushed ones
+	hashes map[string][]string       // Hash -> paths, while in memory
+	disk   *kvindex.Index[FileIndexEntry]
+	walks  int   // Iterations in progress: entries updated meanwhile stay buffered
+	err    error // First failure writing to disk, reported by Save and the iterators
 }
 
 // NewIndex creates a new empty Index
 func NewIndex() *Index {
 	return &Index{
-		Files: make(map[string]FileIndexEntry),
+		files:  make(map[string]FileIndexEntry),
+		hashes: make(map[string][]string),
 	}
 }
 
-// LoadIndex loads an index from a JSON file
+// LoadIndex loads an index from a JSON file, entry by entry. A missing file gives an
+// empty index.
 func LoadIndex(path string) (*Index, error) {
-	data, err := os.ReadFile(path)
+	idx := NewIndex()
+	err := persist.Load(path, func(data []byte) error {
+		idx.Close()
+		idx = NewIndex()
+		data, _, err := schema.Upgrade(schema.Context{Path: path}, schema.SnapshotIndex, data)
+		if err != nil {
+			return err
+		}
+		return idx.decode(data)
+	})
 	if err != nil {
+		idx.Close()
 		if os.IsNotExist(err) {
 			return NewIndex(), nil
 		}
 		return nil, err
 	}
+	return idx, nil
+}
 
-	var idx Index
-	if err := json.Unmarshal(data, &idx); err != nil {
-		return nil, err
+// decode reads an encoded index without unmarshalling its files map at once
+func (idx *Index) decode(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if key != "files" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok == nil {
+			continue // "files": null
+		}
+		if tok != json.Delim('{') {
+			return fmt.Errorf("files: expected an object, found %v", tok)
+		}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			var entry FileIndexEntry
+			if err := dec.Decode(&entry); err != nil {
+				return err
+			}
+			idx.put(tok.(string), entry)
+		}
+		if err := expectDelim(dec, '}'); err != nil {
+			return err
+		}
 	}
-	if idx.Files == nil {
-		idx.Files = make(map[string]FileIndexEntry)
+	if err := expectDelim(dec, '}'); err != nil {
+		return err
 	}
-	return &idx, nil
+	return idx.err
 }
 
-// Save writes the index to a JSON file
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v, found %v", delim, tok)
+	}
+	return nil
+}
+
+// Save writes the index to a JSON file, in the layout json.MarshalIndent gives it
 func (idx *Index) Save(path string) error {
-	data, err := json.MarshalIndent(idx, "", "  ")
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "{\n  \"schema_version\": %d,\n  \"files\": {", schema.Current(schema.SnapshotIndex))
+	n := 0
+	err := idx.ForEach(func(relPath string, entry FileIndexEntry) error {
+		key, err := json.Marshal(relPath)
+		if err != nil {
+			return err
+		}
+		value, err := json.MarshalIndent(entry, "    ", "  ")
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString("\n    ")
+		buf.Write(key)
+		buf.WriteString(": ")
+		buf.Write(value)
+		n++
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0644)
+	if n > 0 {
+		buf.WriteString("\n  ")
+	}
+	buf.WriteString("}\n}")
+	// No .bak: snapshot directories must only hold what was sealed. A damaged index is
+	// rebuilt with rebuild-index.
+	return persist.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Close releases the scratch file of an index that moved to disk. The index must not be
+// used afterwards.
+func (idx *Index) Close() {
+	if idx != nil && idx.disk != nil {
+		idx.disk.Close()
+		idx.disk = nil
+	}
 }
 
 // AddOrUpdate updates an entry in the index
 func (idx *Index) AddOrUpdate(entry FileIndexEntry) {
-	idx.Files[entry.RelPath] = entry
+	idx.put(entry.RelPath, entry)
+}
+
+func (idx *Index) put(relPath string, entry FileIndexEntry) {
+	if idx.disk != nil {
+		idx.files[relPath] = entry
+		if len(idx.files) >= flushBatch && idx.walks == 0 {
+			idx.flush()
+		}
+		return
+	}
+
+	if old, ok := idx.files[relPath]; ok {
+		idx.unhash(old.Hash, relPath)
+	}
+	idx.files[relPath] = entry
+	if entry.Hash != "" {
+		idx.hashes[entry.Hash] = append(idx.hashes[entry.Hash], relPath)
+	}
+	if len(idx.files) >= spillThreshold && idx.walks == 0 && idx.err == nil {
+		idx.spill()
+	}
+}
+
+func (idx *Index) unhash(hash, relPath string) {
+	paths := idx.hashes[hash]
+	for i, p := range paths {
+		if p == relPath {
+			paths = append(paths[:i], paths[i+1:]...)
+			break
+		}
+	}
+	if len(paths) == 0 {
+		delete(idx.hashes, hash)
+	} else {
+		idx.hashes[hash] = paths
+	}
+}
+
+// spill moves the index to a scratch kvindex. On failure it stays in memory.
+func (idx *Index) spill() {
+	disk, err := kvindex.OpenTemp[FileIndexEntry]("")
+	if err != nil {
+		idx.err = err
+		return
+	}
+	idx.disk = disk
+	idx.hashes = nil
+	idx.flush()
+}
+
+// flush commits the buffered entries of an index on disk in one transaction. It waits for
+// the walks in progress, which hold a read transaction.
+func (idx *Index) flush() {
+	if idx.disk == nil || idx.walks > 0 || len(idx.files) == 0 {
+		return
+	}
+	err := idx.disk.Update(func(tx *kvindex.Tx[FileIndexEntry]) error {
+		for relPath, entry := range idx.files {
+			if err := tx.Put(relPath, entry.Hash, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if idx.err == nil {
+			idx.err = err
+		}
+		return
+	}
+	idx.files = make(map[string]FileIndexEntry)
 }
 
 // Get returns an entry by relative path
 func (idx *Index) Get(relPath string) (FileIndexEntry, bool) {
-	entry, ok := idx.Files[relPath]
-	return entry, ok
+	if entry, ok := idx.files[relPath]; ok || idx.disk == nil {
+		return entry, ok
+	}
+	entry, found, err := idx.disk.Get(relPath)
+	if err != nil {
+		return FileIndexEntry{}, false
+	}
+	return entry, found
+}
+
+// Len returns the number of entries
+func (idx *Index) Len() int {
+	if idx.disk == nil {
+		return len(idx.files)
+	}
+	idx.flush()
+	n, _ := idx.disk.Len()
+	return n
+}
+
+// ForEach calls fn for every entry in path order. fn may update existing entries, but
+// must not add new ones.
+func (idx *Index) ForEach(fn func(relPath string, entry FileIndexEntry) error) error {
+	idx.walks++
+	defer func() { idx.walks-- }()
+	if idx.disk == nil {
+		paths := make([]string, 0, len(idx.files))
+		for relPath := range idx.files {
+			paths = append(paths, relPath)
+		}
+		sort.Strings(paths)
+		for _, relPath := range paths {
+			if err := fn(relPath, idx.files[relPath]); err != nil {
+				return err
+			}
+		}
+		return idx.err
+	}
+	idx.flush()
+	if idx.err != nil {
+		return idx.err
+	}
+	return idx.disk.ForEach(func(relPath, _ string, entry FileIndexEntry) error {
+		if updated, ok := idx.files[relPath]; ok {
+			entry = updated
+		}
+		return fn(relPath, entry)
+	})
+}
+
+// ForEachHash calls fn once per content hash, in hash order, with the paths holding it
+// (sorted). Entries without hash are skipped. fn must not modify the index.
+func (idx *Index) ForEachHash(fn func(hash string, relPaths []string) error) error {
+	idx.walks++
+	defer func() { idx.walks-- }()
+	if idx.disk == nil {
+		hashes := make([]string, 0, len(idx.hashes))
+		for hash := range idx.hashes {
+			hashes = append(hashes, hash)
+		}
+		sort.Strings(hashes)
+		for _, hash := range hashes {
+			if err := fn(hash, idx.pathsOf(hash)); err != nil {
+				return err
+			}
+		}
+		return idx.err
+	}
+	idx.flush()
+	if idx.err != nil {
+		return idx.err
+	}
+	return kvindex.Merge([]*kvindex.Index[FileIndexEntry]{idx.disk}, func(hash string, refs []kvindex.Ref) error {
+		relPaths := make([]string, len(refs))
+		for i, ref := range refs {
+			relPaths[i] = ref.Path
+		}
+		return fn(hash, relPaths)
+	})
+}
+
+// ByHash returns the entries holding hash, in path order
+func (idx *Index) ByHash(hash string) []FileIndexEntry {
+	var relPaths []string
+	if idx.disk == nil {
+		relPaths = idx.pathsOf(hash)
+	} else {
+		idx.flush()
+		relPaths, _ = idx.disk.PathsByHash(hash)
+	}
+	entries := make([]FileIndexEntry, 0, len(relPaths))
+	for _, relPath := range relPaths {
+		if entry, ok := idx.Get(relPath); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// HasHash reports whether any entry holds hash
+func (idx *Index) HasHash(hash string) bool {
+	if idx.disk == nil {
+		return len(idx.hashes[hash]) > 0
+	}
+	idx.flush()
+	relPaths, _ := idx.disk.PathsByHash(hash)
+	return len(relPaths) > 0
+}
+
+// pathsOf returns the sorted paths holding hash of an index in memory
+func (idx *Index) pathsOf(hash string) []string {
+	relPaths := append([]string(nil), idx.hashes[hash]...)
+	sort.Strings(relPaths)
+	return relPaths
 }
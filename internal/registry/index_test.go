@@ -0,0 +1,203 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google-photos-backup/internal/schema"
+)
+
+// lowThresholds makes an index move to disk after a few entries
+func lowThresholds(t *testing.T) {
+	oldSpill, oldBatch := spillThreshold, flushBatch
+	spillThreshold, flushBatch = 3, 2
+	t.Cleanup(func() { spillThreshold, flushBatch = oldSpill, oldBatch })
+}
+
+func testEntries() []FileIndexEntry {
+	mtime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	var entries []FileIndexEntry
+	for i := 0; i < 7; i++ {
+		entries = append(entries, FileIndexEntry{
+			RelPath: fmt.Sprintf("Google Photos/Album/IMG_%d.jpg", i),
+			Hash:    fmt.Sprintf("hash%d", i%3),
+			Size:    int64(100 + i),
+			ModTime: mtime.Add(time.Duration(i) * time.Hour),
+			Inode:   uint64(1000 + i),
+		})
+	}
+	return entries
+}
+
+func collect(t *testing.T, idx *Index) map[string]FileIndexEntry {
+	t.Helper()
+	got := make(map[string]FileIndexEntry)
+	var last string
+	err := idx.ForEach(func(relPath string, e FileIndexEntry) error {
+		if relPath <= last {
+			t.Errorf("ForEach: %q after %q, want path order", relPath, last)
+		}
+		last = relPath
+		got[relPath] = e
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEach: %v", err)
+	}
+	return got
+}
+
+func TestIndexSaveMatchesMarshalIndent(t *testing.T) {
+	for _, n := range []int{0, 1, 7} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			idx := NewIndex()
+			defer idx.Close()
+			files := make(map[string]FileIndexEntry)
+			for _, e := range testEntries()[:n] {
+				idx.AddOrUpdate(e)
+				files[e.RelPath] = e
+			}
+
+			path := filepath.Join(t.TempDir(), "index.json")
+			if err := idx.Save(path); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := json.MarshalIndent(struct {
+				SchemaVersion int                       `json:"schema_version"`
+				Files         map[string]FileIndexEntry `json:"files"`
+			}{schema.Current(schema.SnapshotIndex), files}, "", "  ")
+			if string(got) != string(want) {
+				t.Errorf("Save wrote\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestIndexSpillsToDisk(t *testing.T) {
+	lowThresholds(t)
+	idx := NewIndex()
+	defer idx.Close()
+	want := make(map[string]FileIndexEntry)
+	for _, e := range testEntries() {
+		idx.AddOrUpdate(e)
+		want[e.RelPath] = e
+	}
+	if idx.disk == nil {
+		t.Fatal("index did not move to disk")
+	}
+
+	// Replacing an entry moves it to its new hash
+	moved := want["Google Photos/Album/IMG_0.jpg"]
+	moved.Hash = "hash9"
+	idx.AddOrUpdate(moved)
+	want[moved.RelPath] = moved
+
+	if n := idx.Len(); n != len(want) {
+		t.Errorf("Len = %d, want %d", n, len(want))
+	}
+	if got := collect(t, idx); !reflect.DeepEqual(got, want) {
+		t.Errorf("ForEach = %v, want %v", got, want)
+	}
+	if e, ok := idx.Get(moved.RelPath); !ok || e.Hash != "hash9" {
+		t.Errorf("Get = %v, %v", e, ok)
+	}
+
+	var paths []string
+	for _, e := range idx.ByHash("hash0") {
+		paths = append(paths, e.RelPath)
+	}
+	if wantPaths := []string{"Google Photos/Album/IMG_3.jpg", "Google Photos/Album/IMG_6.jpg"}; !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("ByHash(hash0) = %v, want %v", paths, wantPaths)
+	}
+	if !idx.HasHash("hash9") || idx.HasHash("missing") {
+		t.Error("HasHash does not follow the updated entry")
+	}
+
+	groups := make(map[string]int)
+	err := idx.ForEachHash(func(hash string, relPaths []string) error {
+		groups[hash] = len(relPaths)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachHash: %v", err)
+	}
+	if wantGroups := map[string]int{"hash0": 2, "hash1": 2, "hash2": 2, "hash9": 1}; !reflect.DeepEqual(groups, wantGroups) {
+		t.Errorf("ForEachHash groups = %v, want %v", groups, wantGroups)
+	}
+
+	path := filepath.Join(t.TempDir(), "index.json")
+	if err := idx.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := LoadIndex(path)
+	if err != nil {
+		t.Fatalf("LoadIndex: %v", err)
+	}
+	defer loaded.Close()
+	if got := collect(t, loaded); !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded index = %v, want %v", got, want)
+	}
+}
+
+func TestIndexUpdatedWhileWalking(t *testing.T) {
+	for _, spill := range []bool{false, true} {
+		t.Run(fmt.Sprintf("spilled=%v", spill), func(t *testing.T) {
+			if spill {
+				lowThresholds(t)
+			}
+			idx := NewIndex()
+			defer idx.Close()
+			for _, e := range testEntries() {
+				idx.AddOrUpdate(e)
+			}
+
+			err := idx.ForEach(func(relPath string, e FileIndexEntry) error {
+				e.Generation = 2
+				idx.AddOrUpdate(e)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("ForEach: %v", err)
+			}
+			for relPath, e := range collect(t, idx) {
+				if e.Generation != 2 {
+					t.Errorf("%s: generation %d, want 2", relPath, e.Generation)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	idx, err := LoadIndex(filepath.Join(dir, "missing.json"))
+	if err != nil || idx.Len() != 0 {
+		t.Fatalf("LoadIndex(missing) = %d entries, %v", idx.Len(), err)
+	}
+
+	path := filepath.Join(dir, "index.json")
+	data := fmt.Sprintf(`{"schema_version": %d, "files": null, "extra": [1, {"a": 2}]}`, schema.Current(schema.SnapshotIndex))
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if idx, err := LoadIndex(path); err != nil || idx.Len() != 0 {
+		t.Errorf("LoadIndex(files: null) = %v, %v", idx, err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"schema_version": 1, "files": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIndex(path); err == nil {
+		t.Error("LoadIndex accepted a damaged index")
+	}
+}
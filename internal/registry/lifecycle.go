@@ -0,0 +1,300 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google-photos-backup/internal/schema"
+)
+
+// lifecycle.go is the export state machine. An export moves forward
+//
+//	requested -> in_progress -> ready -> downloading -> downloaded -> extracted -> snapshotted
+//
+// and can end expired, failed or cancelled. Every status change goes through the
+// Registry, which refuses the transitions not listed here. Going back to ready (a new
+// download) is always allowed except from cancelled, so that 'history retry' works.
+// Manual edits ('history') are recorded in an audit trail next to history.json.
+
+func init() {
+	// v1 -> v2: "processed" is split into extracted and snapshotted, and downloaded
+	// exports left "ready" get their real status
+	schema.Register(schema.Migration{Format: schema.History, From: 1, Description: "infer lifecycle statuses (downloaded, extracted, snapshotted)", Apply: inferStatus})
+}
+
+// Statuses lists every export status, in lifecycle order
+var Statuses = []ExportStatus{
+	StatusRequested, StatusInProgress, StatusReady, StatusDownloading, StatusDownloaded,
+	StatusExtracted, StatusSnapshotted, StatusExpired, StatusFailed, StatusCancelled,
+}
+
+// transitions maps a status to the statuses an export may move to from it
+var transitions = map[ExportStatus][]ExportStatus{
+	StatusRequested:   {StatusInProgress, StatusReady, StatusExpired, StatusFailed, StatusCancelled},
+	StatusInProgress:  {StatusReady, StatusExpired, StatusFailed, StatusCancelled},
+	StatusReady:       {StatusDownloading, StatusDownloaded, StatusExpired, StatusFailed, StatusCancelled}, // downloaded: written directly (api-sync)
+	StatusDownloading: {StatusDownloaded, StatusReady, StatusExpired, StatusFailed, StatusCancelled},
+	StatusDownloaded:  {StatusExtracted, StatusReady, StatusFailed},
+	StatusExtracted:   {StatusSnapshotted, StatusReady, StatusFailed},
+	StatusSnapshotted: {StatusReady},
+	StatusExpired:     {StatusReady, StatusFailed, StatusCancelled}, // Wrongly marked expired
+	StatusFailed:      {StatusReady, StatusDownloading, StatusDownloaded, StatusExtracted, StatusSnapshotted, StatusExpired, StatusCancelled},
+	StatusCancelled:   {},
+}
+
+// ValidStatus reports whether s is a known status
+func ValidStatus(s ExportStatus) bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanTransition reports whether an export may move from one status to another
+func CanTransition(from, to ExportStatus) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves the i-th export to status to. Unless force is set, the change must
+// be allowed by the lifecycle.
+func (r *Registry) Transition(i int, to ExportStatus, force bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i < 0 || i >= len(r.Exports) {
+		return fmt.Errorf("no export #%d", i+1)
+	}
+	if !ValidStatus(to) {
+		return fmt.Errorf("unknown status %q", to)
+	}
+	if !force {
+		if err := checkTransition(r.Exports[i].Status, to); err != nil {
+			return err
+		}
+	}
+	r.Exports[i].Status = to
+	if to != StatusFailed {
+		r.Exports[i].Error = ""
+	}
+	return nil
+}
+
+// forward is the main path of the lifecycle
+var forward = []ExportStatus{
+	StatusRequested, StatusInProgress, StatusReady, StatusDownloading, StatusDownloaded,
+	StatusExtracted, StatusSnapshotted,
+}
+
+// Advance moves export id forward to status to, through the intermediate statuses when
+// there is no direct transition (an export being backed up was necessarily downloaded
+// and extracted). Moving to the current status is a no-op.
+func (r *Registry) Advance(id string, to ExportStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.Exports {
+		if r.Exports[i].ID != id {
+			continue
+		}
+		status := r.Exports[i].Status
+		for status != to && !CanTransition(status, to) {
+			next := nextForward(status, to)
+			if next == "" {
+				return fmt.Errorf("export %s: %w", id, checkTransition(status, to))
+			}
+			status = next
+		}
+		r.Exports[i].Status = to
+		return nil
+	}
+	return fmt.Errorf("no export %s in history", id)
+}
+
+// nextForward returns the status after from on the main path, if to lies further on it
+func nextForward(from, to ExportStatus) ExportStatus {
+	pos := func(s ExportStatus) int {
+		for i, f := range forward {
+			if f == s {
+				return i
+			}
+		}
+		return -1
+	}
+	i, j := pos(from), pos(to)
+	if i < 0 || j <= i || !CanTransition(from, forward[i+1]) {
+		return ""
+	}
+	return forward[i+1]
+}
+
+// checkTransition returns an error unless from -> to is allowed (or from == to)
+func checkTransition(from, to ExportStatus) error {
+	if !ValidStatus(to) {
+		return fmt.Errorf("unknown status %q", to)
+	}
+	if from == to || CanTransition(from, to) {
+		return nil
+	}
+	return fmt.Errorf("cannot move from %s to %s (allowed: %v)", from, to, transitions[from])
+}
+
+// Extracted reports whether the export went through 'process'
+func (e ExportEntry) Extracted() bool {
+	return e.Status == StatusExtracted || e.Status == StatusSnapshotted
+}
+
+// inferStatus replaces the old "processed" status, and the "ready" left on exports
+// that older versions downloaded without recording it, from what is on disk:
+// snapshotted when update-backup already removed downloads/<ID>/raw of a processed
+// export, extracted or downloaded otherwise
+func inferStatus(ctx schema.Context, doc schema.Doc) error {
+	status, _ := doc["status"].(string)
+	id, _ := doc["id"].(string)
+	if id == "" || (status != "processed" && status != string(StatusReady)) {
+		return nil
+	}
+	exportDir := filepath.Join(filepath.Dir(ctx.Path), "downloads", id)
+	_, err := os.Stat(filepath.Join(exportDir, "raw"))
+	rawGone := os.IsNotExist(err)
+
+	if status == "processed" {
+		doc["status"] = string(StatusExtracted)
+		if rawGone {
+			doc["status"] = string(StatusSnapshotted)
+		}
+		return nil
+	}
+
+	// ready: only exports whose parts are all on disk moved on
+	data, err := os.ReadFile(filepath.Join(exportDir, schema.DownloadState))
+	if err != nil {
+		return nil
+	}
+	var state struct {
+		Files []struct {
+			Filename string `json:"filename"`
+			Status   string `json:"status"`
+		} `json:"files"`
+	}
+	if json.Unmarshal(data, &state) != nil || len(state.Files) == 0 {
+		return nil
+	}
+	archivesGone := true
+	for _, f := range state.Files {
+		if f.Status != "completed" {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(exportDir, f.Filename)); err == nil {
+			archivesGone = false
+		}
+	}
+	// A downloaded export never extracted has no raw/ either: it was only backed up if
+	// 'process' recorded it or already deleted its archives
+	doc["status"] = string(StatusDownloaded)
+	if rawGone && (archivesGone || processedExport(filepath.Dir(ctx.Path), id)) {
+		doc["status"] = string(StatusSnapshotted)
+	}
+	return nil
+}
+
+// processedExport reports whether a global processing index of workingDir (in downloads/
+// or output/, where 'process' and 'run' keep it) lists export id as processed
+func processedExport(workingDir, id string) bool {
+	for _, dir := range []string{"downloads", "output"} {
+		data, err := os.ReadFile(filepath.Join(workingDir, dir, schema.ProcessingIndex))
+		if err != nil {
+			continue
+		}
+		var index struct {
+			ProcessedExports map[string]bool `json:"processed_exports"`
+		}
+		if json.Unmarshal(data, &index) == nil && index.ProcessedExports[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveAt removes the i-th export and returns it
+func (r *Registry) RemoveAt(i int) (ExportEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i < 0 || i >= len(r.Exports) {
+		return ExportEntry{}, fmt.Errorf("no export #%d", i+1)
+	}
+	entry := r.Exports[i]
+	r.Exports = append(r.Exports[:i], r.Exports[i+1:]...)
+	return entry, nil
+}
+
+// AuditFileName is the audit trail of manual history changes, next to history.json
+const AuditFileName = "history_audit.jsonl"
+
+// AuditEntry records one manual change of history.json
+type AuditEntry struct {
+	Timestamp time.Time    `json:"timestamp"`
+	Action    string       `json:"action"` // set-status, forget, retry
+	ID        string       `json:"id"`
+	From      ExportStatus `json:"from,omitempty"`
+	To        ExportStatus `json:"to,omitempty"`
+	Forced    bool         `json:"forced,omitempty"`
+	Reason    string       `json:"reason,omitempty"`
+	Cleaned   []string     `json:"cleaned,omitempty"` // Paths removed with the change
+}
+
+// AuditPath returns the audit trail of the registry
+func (r *Registry) AuditPath() string {
+	return filepath.Join(filepath.Dir(r.FilePath), AuditFileName)
+}
+
+// AppendAudit appends e to the audit trail (append-only, one JSON object per line)
+func (r *Registry) AppendAudit(e AuditEntry) error {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.AuditPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadAudit returns the audit entries of export id ("" for all), oldest first
+func (r *Registry) ReadAudit(id string) ([]AuditEntry, error) {
+	data, err := os.ReadFile(r.AuditPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []AuditEntry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var e AuditEntry
+		if err := dec.Decode(&e); err != nil {
+			return entries, err
+		}
+		if id == "" || e.ID == id {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,183 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google-photos-backup/internal/schema"
+)
+
+func newTestRegistry(statuses ...ExportStatus) *Registry {
+	r := &Registry{}
+	for i, s := range statuses {
+		r.Add(ExportEntry{ID: fmt.Sprintf("E%d", i), Status: s})
+	}
+	return r
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		from, to ExportStatus
+		ok       bool
+	}{
+		{StatusReady, StatusDownloading, true},
+		{StatusDownloaded, StatusExtracted, true},
+		{StatusExtracted, StatusExtracted, true}, // Same status: other fields change
+		{StatusSnapshotted, StatusReady, true},   // history retry
+		{StatusFailed, StatusExtracted, true},
+		{StatusReady, StatusSnapshotted, false},
+		{StatusDownloaded, StatusSnapshotted, false},
+		{StatusSnapshotted, StatusExtracted, false},
+		{StatusCancelled, StatusReady, false},
+		{StatusReady, "processed", false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s->%s", tt.from, tt.to), func(t *testing.T) {
+			r := newTestRegistry(tt.from)
+			err := r.Update(ExportEntry{ID: "E0", Status: tt.to, Error: "changed"})
+			if (err == nil) != tt.ok {
+				t.Fatalf("Update: err = %v, want ok = %v", err, tt.ok)
+			}
+			got := r.Get("E0")
+			if tt.ok && (got.Status != tt.to || got.Error != "changed") {
+				t.Errorf("entry = %+v, want the update applied", got)
+			}
+			if !tt.ok && (got.Status != tt.from || got.Error != "") {
+				t.Errorf("entry = %+v, want it unchanged", got)
+			}
+		})
+	}
+
+	if err := newTestRegistry().Update(ExportEntry{ID: "missing"}); err == nil {
+		t.Error("Update accepted an unknown export")
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	tests := []struct {
+		from, to ExportStatus
+		ok       bool
+	}{
+		{StatusReady, StatusSnapshotted, true}, // Through downloaded and extracted
+		{StatusDownloading, StatusExtracted, true},
+		{StatusFailed, StatusSnapshotted, true},
+		{StatusExtracted, StatusExtracted, true},
+		{StatusSnapshotted, StatusExtracted, false},
+		{StatusCancelled, StatusSnapshotted, false},
+		{StatusExpired, StatusExtracted, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s->%s", tt.from, tt.to), func(t *testing.T) {
+			r := newTestRegistry(tt.from)
+			err := r.Advance("E0", tt.to)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Advance: err = %v, want ok = %v", err, tt.ok)
+			}
+			want := tt.to
+			if !tt.ok {
+				want = tt.from
+			}
+			if got := r.Get("E0").Status; got != want {
+				t.Errorf("status = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestGetLastDownloaded(t *testing.T) {
+	r := newTestRegistry(StatusExtracted, StatusFailed, StatusReady, StatusFailed, StatusCancelled)
+	r.Add(ExportEntry{Status: StatusFailed}) // Request that failed before getting an ID
+	onDisk := func(id string) bool { return id == "E1" }
+
+	if got := r.GetLastDownloaded(onDisk); got == nil || got.ID != "E1" {
+		t.Errorf("GetLastDownloaded = %+v, want E1 (failed, download on disk)", got)
+	}
+	if got := r.GetLastDownloaded(func(string) bool { return false }); got == nil || got.ID != "E0" {
+		t.Errorf("GetLastDownloaded = %+v, want E0 without failed downloads on disk", got)
+	}
+	if got := newTestRegistry(StatusReady).GetLastDownloaded(onDisk); got != nil {
+		t.Errorf("GetLastDownloaded = %+v, want nil", got)
+	}
+}
+
+func TestInferStatusMigration(t *testing.T) {
+	dir := t.TempDir()
+	export := func(id string, raw bool, parts map[string]string) {
+		exportDir := filepath.Join(dir, "downloads", id)
+		if err := os.MkdirAll(exportDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if raw {
+			os.Mkdir(filepath.Join(exportDir, "raw"), 0755)
+		}
+		if parts == nil {
+			return
+		}
+		var files []string
+		for name, status := range parts {
+			files = append(files, fmt.Sprintf(`{"filename": %q, "status": %q}`, name, status))
+			if strings.HasPrefix(name, "kept") {
+				os.WriteFile(filepath.Join(exportDir, name), nil, 0644)
+			}
+		}
+		state := `{"files": [` + strings.Join(files, ",") + `]}`
+		if err := os.WriteFile(filepath.Join(exportDir, schema.DownloadState), []byte(state), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	export("P1", true, nil)                                           // processed, raw still there
+	export("P2", false, nil)                                          // processed, raw removed by update-backup
+	export("R1", true, map[string]string{"kept-1.zip": "completed"})  // downloaded, raw/ still there
+	export("R2", false, map[string]string{"gone-1.zip": "completed"}) // archives and raw removed
+	export("R3", false, map[string]string{"kept-1.zip": "completed"}) // never extracted
+	export("R4", false, map[string]string{"kept-1.zip": "completed"}) // listed by the processing index
+	export("R5", false, map[string]string{"kept-1.zip": "completed", "kept-2.zip": "pending"})
+	export("R6", false, nil) // no state.json
+
+	index := `{"processed_exports": {"R4": true}}`
+	os.MkdirAll(filepath.Join(dir, "output"), 0755)
+	if err := os.WriteFile(filepath.Join(dir, "output", schema.ProcessingIndex), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]ExportStatus{
+		"P1": StatusExtracted,
+		"P2": StatusSnapshotted,
+		"R1": StatusDownloaded,
+		"R2": StatusSnapshotted,
+		"R3": StatusDownloaded,
+		"R4": StatusSnapshotted,
+		"R5": StatusReady,
+		"R6": StatusReady,
+		"F1": StatusFailed,
+	}
+	var lines []string
+	for _, id := range []string{"P1", "P2", "R1", "R2", "R3", "R4", "R5", "R6", "F1"} {
+		status := "ready"
+		switch id[0] {
+		case 'P':
+			status = "processed"
+		case 'F':
+			status = "failed"
+		}
+		lines = append(lines, fmt.Sprintf(`{"id": %q, "status": %q, "schema_version": 1}`, id, status))
+	}
+	path := filepath.Join(dir, "history.json")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := New(path)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for id, status := range want {
+		if got := r.Get(id); got == nil || got.Status != status {
+			t.Errorf("%s: status %v, want %s", id, got, status)
+		}
+	}
+}
@@ -2,10 +2,15 @@ package registry
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
 	"sync"
 	"time"
+
+	"google-photos-backup/internal/persist"
+	"google-photos-backup/internal/schema"
 )
 
 type ExportStatus string
@@ -15,7 +20,9 @@ const (
 	StatusInProgress  ExportStatus = "in_progress"
 	StatusReady       ExportStatus = "ready"       // Ready to download
 	StatusDownloading ExportStatus = "downloading" // Downloading
-	StatusProcessed   ExportStatus = "processed"   // Extracted and organized (Success)
+	StatusDownloaded  ExportStatus = "downloaded"  // Every part on disk
+	StatusExtracted   ExportStatus = "extracted"   // Extracted and organized by 'process'
+	StatusSnapshotted ExportStatus = "snapshotted" // Backed up by 'update-backup' (Success)
 	StatusExpired     ExportStatus = "expired"
 	StatusFailed      ExportStatus = "failed"
 	StatusCancelled   ExportStatus = "cancelled"
@@ -29,11 +36,9 @@ type ExportEntry struct {
 	CompletedAt    time.Time    `json:"completed_at,omitempty"`
 	FileCount      int          `json:"file_count,omitempty"`       // Number of zip files
 	TotalSize      string       `json:"total_size,omitempty"`       // String like "50 GB"
-	NewPhotosCount int          `json:"new_photos_count,omitempty"` // Added to backup
+	NewPhotosCount int          `json:"new_photos_count,omitempty"` // Media files added to the snapshot
 	Error          string       `json:"error,omitempty"`
-	// Deprecated: Files are now stored in a separate state.json file per export.
-	// This field is kept for migration purposes only.
-	Files []DownloadFile `json:"files,omitempty"` // List of files to download
+	SchemaVersion  int          `json:"schema_version,omitempty"` // Per line, see internal/schema
 }
 
 type DownloadFile struct {
@@ -67,48 +72,55 @@ func (r *Registry) Load() error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	f, err := os.Open(r.FilePath)
+	err := persist.Load(r.FilePath, r.parse)
 	if os.IsNotExist(err) {
 		return nil
 	}
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	return err
+}
 
-	// Leemos línea a línea (JSONL)
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		var entry ExportEntry
+// parse reads history.json: one JSON entry per line (JSONL). A line that does not parse
+// means the file is damaged, not that the entry can be skipped.
+func (r *Registry) parse(data []byte) error {
+	var exports []ExportEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
 		line := scanner.Bytes()
-		if len(line) == 0 {
+		if len(bytes.TrimSpace(line)) == 0 {
 			continue
 		}
-		if err := json.Unmarshal(line, &entry); err == nil {
-			r.Exports = append(r.Exports, entry)
+		line, _, err := schema.Upgrade(schema.Context{Path: r.FilePath}, schema.History, line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		var entry ExportEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
 		}
+		exports = append(exports, entry)
 	}
-	return scanner.Err()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	r.Exports = append([]ExportEntry{}, exports...)
+	return nil
 }
 
 func (r *Registry) Save() error {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	f, err := os.Create(r.FilePath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	enc := json.NewEncoder(f)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
 	// Guardamos en formato JSONL (una línea por entrada) para que sea tipo log legible
 	for _, entry := range r.Exports {
+		entry.SchemaVersion = schema.Current(schema.History)
 		if err := enc.Encode(entry); err != nil {
 			return err
 		}
 	}
-	return nil
+	return persist.WriteState(r.FilePath, buf.Bytes(), 0644)
 }
 
 func (r *Registry) Add(entry ExportEntry) {
@@ -117,26 +129,51 @@ func (r *Registry) Add(entry ExportEntry) {
 	r.Exports = append(r.Exports, entry)
 }
 
-// GetLastSuccessful returns the last export with StatusProcessed (fully completed)
+// GetLastSuccessful returns the last export with StatusSnapshotted (fully completed)
 func (r *Registry) GetLastSuccessful() *ExportEntry {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	for i := len(r.Exports) - 1; i >= 0; i-- {
-		if r.Exports[i].Status == StatusProcessed {
-			return &r.Exports[i]
+		if r.Exports[i].Status == StatusSnapshotted {
+			entry := r.Exports[i]
+			return &entry
 		}
 	}
 	return nil
 }
 
-// Get devuelve un puntero a la entrada si existe, o nil
+// GetLastDownloaded returns the last export whose download finished: snapshotted, or
+// still in (or failing at) 'process' and 'update-backup'. A failed export counts only
+// when onDisk reports that its download is still there.
+func (r *Registry) GetLastDownloaded(onDisk func(id string) bool) *ExportEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := len(r.Exports) - 1; i >= 0; i-- {
+		switch r.Exports[i].Status {
+		case StatusFailed:
+			if r.Exports[i].ID == "" || !onDisk(r.Exports[i].ID) {
+				continue
+			}
+		case StatusDownloaded, StatusExtracted, StatusSnapshotted:
+		default:
+			continue
+		}
+		entry := r.Exports[i]
+		return &entry
+	}
+	return nil
+}
+
+// Get devuelve una copia de la entrada si existe, o nil. Los cambios se guardan con Update.
 func (r *Registry) Get(id string) *ExportEntry {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	for i := range r.Exports {
 		if r.Exports[i].ID == id {
-			return &r.Exports[i]
+			entry := r.Exports[i]
+			return &entry
 		}
 	}
 	return nil
@@ -161,16 +198,21 @@ func (r *Registry) MergeOrphan(id string, createdAt time.Time) bool {
 	return false
 }
 
-// Update actualiza una entrada existente
-func (r *Registry) Update(entry ExportEntry) {
+// Update actualiza una entrada existente. Un cambio de estado que el ciclo de vida no
+// permite (ver lifecycle.go) se rechaza sin modificar nada.
+func (r *Registry) Update(entry ExportEntry) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	for i, e := range r.Exports {
 		if e.ID == entry.ID {
+			if err := checkTransition(e.Status, entry.Status); err != nil {
+				return fmt.Errorf("export %s: %w", entry.ID, err)
+			}
 			r.Exports[i] = entry
-			return
+			return nil
 		}
 	}
+	return fmt.Errorf("no export %s in history", entry.ID)
 }
 
 // Exists comprueba si existe una exportación con ese ID
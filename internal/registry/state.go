@@ -5,23 +5,32 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"google-photos-backup/internal/persist"
+	"google-photos-backup/internal/schema"
 )
 
 type DownloadState struct {
-	ID          string         `json:"id"`
-	LastUpdated time.Time      `json:"last_updated"`
-	Files       []DownloadFile `json:"files"`
+	SchemaVersion int            `json:"schema_version"`
+	ID            string         `json:"id"`
+	LastUpdated   time.Time      `json:"last_updated"`
+	Files         []DownloadFile `json:"files"`
+	// Incremental exports (api-sync) only hold new items: update-backup carries the
+	// previous snapshot forward instead of treating everything else as deleted upstream.
+	Incremental bool `json:"incremental,omitempty"`
 }
 
 func LoadDownloadState(path string) (*DownloadState, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
 	var state DownloadState
-	if err := json.NewDecoder(f).Decode(&state); err != nil {
+	err := persist.Load(path, func(data []byte) error {
+		state = DownloadState{}
+		data, _, err := schema.Upgrade(schema.Context{Path: path}, schema.DownloadState, data)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, &state)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &state, nil
@@ -34,13 +43,10 @@ func (s *DownloadState) Save(path string) error {
 		return err
 	}
 
-	f, err := os.Create(path)
+	s.SchemaVersion = schema.Current(schema.DownloadState)
+	data, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	return enc.Encode(s)
+	return persist.WriteState(path, append(data, '\n'), 0644)
 }
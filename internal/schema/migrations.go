@@ -0,0 +1,72 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google-photos-backup/internal/i18n"
+	"google-photos-backup/internal/logger"
+	"google-photos-backup/internal/persist"
+)
+
+// migrations.go holds the registered migrations. Each one works on the generic document
+// so that it keeps working after the Go structs move on.
+
+func init() {
+	// v0 -> v1: history entries used to carry their file list, now kept in downloads/<ID>/state.json
+	Register(Migration{Format: History, From: 0, Description: "move legacy file lists to downloads/<ID>/state.json", Apply: moveLegacyFiles})
+
+	// v0 -> v1 for the other formats only adds schema_version
+	for _, format := range []string{DownloadState, ProcessingIndex, SnapshotIndex, BackupLog} {
+		Register(Migration{Format: format, From: 0, Description: "add schema_version", Apply: func(Context, Doc) error { return nil }})
+	}
+}
+
+// moveLegacyFiles writes the "files" of a history entry to the export's state.json (unless
+// one already exists) and drops them from the entry. Lists with empty filenames come from
+// an old scraping bug and are discarded so that sync scans the export again.
+func moveLegacyFiles(ctx Context, doc Doc) error {
+	files, ok := doc["files"].([]interface{})
+	if !ok || len(files) == 0 {
+		delete(doc, "files")
+		return nil
+	}
+	id, _ := doc["id"].(string)
+
+	first, _ := files[0].(map[string]interface{})
+	if name, _ := first["filename"].(string); name == "" || id == "" {
+		if !ctx.DryRun {
+			logger.Info(i18n.T("discarding_bad_state"))
+		}
+		delete(doc, "files")
+		return nil
+	}
+
+	statePath := filepath.Join(filepath.Dir(ctx.Path), "downloads", id, DownloadState)
+	if _, err := os.Stat(statePath); err == nil || ctx.DryRun {
+		delete(doc, "files")
+		return nil
+	}
+
+	logger.Info(i18n.T("migrating_state"))
+	data, err := json.MarshalIndent(Doc{
+		Field:          Current(DownloadState),
+		"id":           id,
+		"last_updated": time.Now(),
+		"files":        files,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return err
+	}
+	if err := persist.WriteState(statePath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", statePath, err)
+	}
+	delete(doc, "files")
+	return nil
+}
@@ -0,0 +1,173 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// schema.go versions the on-disk formats. Every format stores a schema_version (per line
+// for the JSONL ones); loaders pass what they read through Upgrade, which runs the
+// registered migrations from the stored version up to the current one. Files without the
+// field are version 0.
+
+// Formats, named after the file that holds them
+const (
+	History         = "history.json"          // JSONL, one export per line
+	DownloadState   = "state.json"            // downloads/<ID>/state.json
+	ProcessingIndex = "processing_index.json" // Global and per-export processing index
+	SnapshotIndex   = "index.json"            // Snapshot and Immich master index
+	BackupLog       = "backup_log.jsonl"      // JSONL, one snapshot per line
+)
+
+// jsonl formats store one document per line, each with its own version
+var jsonl = map[string]bool{History: true, BackupLog: true}
+
+// Field is the key holding the version of a document
+const Field = "schema_version"
+
+// Current versions written by this build
+var current = map[string]int{
+	History:         2,
+	DownloadState:   1,
+	ProcessingIndex: 2,
+	SnapshotIndex:   1,
+	BackupLog:       1,
+}
+
+// Current returns the version written by this build for format
+func Current(format string) int {
+	return current[format]
+}
+
+// Doc is one decoded document: a whole file, or one line of a JSONL file
+type Doc map[string]interface{}
+
+// Context describes where a document comes from. Migrations that touch other files
+// (e.g. moving data out of history.json) must not write anything when DryRun is set.
+type Context struct {
+	Path   string
+	DryRun bool
+}
+
+// Migration upgrades a document of Format from version From to From+1
+type Migration struct {
+	Format      string
+	From        int
+	Description string
+	Apply       func(ctx Context, doc Doc) error
+}
+
+var migrations = make(map[string]map[int]Migration)
+
+// Register adds a migration. Registering two migrations for the same step is a bug.
+func Register(m Migration) {
+	if migrations[m.Format] == nil {
+		migrations[m.Format] = make(map[int]Migration)
+	}
+	if _, dup := migrations[m.Format][m.From]; dup {
+		panic(fmt.Sprintf("schema: duplicate migration for %s v%d", m.Format, m.From))
+	}
+	migrations[m.Format][m.From] = m
+}
+
+// TooNewError reports a document written by a newer version of the program
+type TooNewError struct {
+	Format  string
+	Version int
+}
+
+func (e *TooNewError) Error() string {
+	return fmt.Sprintf("%s has schema version %d, this build only knows up to %d: upgrade google-photos-backup", e.Format, e.Version, Current(e.Format))
+}
+
+// Version returns the version stored in doc (0 when absent)
+func Version(doc Doc) int {
+	if v, ok := doc[Field].(float64); ok {
+		return int(v)
+	}
+	return 0
+}
+
+// Migrate upgrades doc in place to the current version of format and returns the
+// descriptions of the migrations it applied
+func Migrate(ctx Context, format string, doc Doc) ([]string, error) {
+	target := Current(format)
+	version := Version(doc)
+	if version > target {
+		return nil, &TooNewError{Format: format, Version: version}
+	}
+
+	var applied []string
+	for ; version < target; version++ {
+		m, ok := migrations[format][version]
+		if !ok {
+			return applied, fmt.Errorf("no migration for %s v%d", format, version)
+		}
+		if err := m.Apply(ctx, doc); err != nil {
+			return applied, fmt.Errorf("%s v%d -> v%d (%s): %w", format, version, version+1, m.Description, err)
+		}
+		applied = append(applied, m.Description)
+	}
+	doc[Field] = target
+	return applied, nil
+}
+
+// Upgrade migrates one encoded document. Data already at the current version is returned
+// unchanged without being decoded twice; otherwise the migrated document is re-encoded.
+func Upgrade(ctx Context, format string, data []byte) ([]byte, []string, error) {
+	var peek struct {
+		Version int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &peek); err != nil {
+		return nil, nil, err
+	}
+	if peek.Version == Current(format) {
+		return data, nil, nil
+	}
+	if peek.Version > Current(format) {
+		return nil, nil, &TooNewError{Format: format, Version: peek.Version}
+	}
+
+	var doc Doc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	applied, err := Migrate(ctx, format, doc)
+	if err != nil {
+		return nil, applied, err
+	}
+	out, err := json.Marshal(doc)
+	return out, applied, err
+}
+
+// Pending returns the migrations that loading data (a whole file of format) would apply,
+// without side effects. JSONL files are checked line by line.
+func Pending(path, format string, data []byte) ([]string, error) {
+	docs := [][]byte{data}
+	if jsonl[format] {
+		docs = bytes.Split(data, []byte("\n"))
+	}
+
+	var pending []string
+	seen := make(map[string]bool)
+	for n, doc := range docs {
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		_, applied, err := Upgrade(Context{Path: path, DryRun: true}, format, doc)
+		if err != nil {
+			if jsonl[format] {
+				return pending, fmt.Errorf("line %d: %w", n+1, err)
+			}
+			return pending, err
+		}
+		for _, desc := range applied {
+			if !seen[desc] {
+				seen[desc] = true
+				pending = append(pending, desc)
+			}
+		}
+	}
+	return pending, nil
+}
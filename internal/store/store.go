@@ -0,0 +1,214 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"google-photos-backup/internal/fsutil"
+)
+
+// store.go is the content-addressed object store: every unique file is kept once under
+// objects/<first 2 hex chars>/<sha256>. Snapshots are tree manifests (index.json) and their
+// directory views are materialised from the store as hardlinks or relative symlinks.
+
+const (
+	ObjectsDir = "objects"
+
+	BackendHardlink = "hardlink" // Classic hardlink forest between snapshots (default)
+	BackendObjects  = "objects"  // Content-addressed store + materialised views
+)
+
+// Store is an object store rooted at <backup_path>/objects
+type Store struct {
+	Root string
+}
+
+// Open returns the store that belongs to backupPath
+func Open(backupPath string) *Store {
+	return &Store{Root: filepath.Join(backupPath, ObjectsDir)}
+}
+
+// ObjectPath returns where the object with the given hash lives
+func (s *Store) ObjectPath(hash string) string {
+	if len(hash) < 2 {
+		return filepath.Join(s.Root, hash)
+	}
+	return filepath.Join(s.Root, hash[:2], hash)
+}
+
+// Has reports whether the object is present
+func (s *Store) Has(hash string) bool {
+	_, err := os.Stat(s.ObjectPath(hash))
+	return err == nil
+}
+
+// Put stores src under its hash (computed if empty). With move the source is renamed into
+// the store when possible; src must then be a regular file, and a given hash is verified
+// before the rename as the copy path does. Returns the hash and whether a new object was
+// written.
+func (s *Store) Put(src, hash string, move bool) (string, bool, error) {
+	verified := false
+	if hash == "" {
+		h, err := HashFile(src)
+		if err != nil {
+			return "", false, err
+		}
+		hash = h
+		verified = true
+	}
+
+	objPath := s.ObjectPath(hash)
+	if _, err := os.Stat(objPath); err == nil {
+		return hash, false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(objPath), 0755); err != nil {
+		return "", false, err
+	}
+
+	if move {
+		info, err := os.Lstat(src)
+		if err != nil {
+			return "", false, err
+		}
+		if !info.Mode().IsRegular() {
+			return "", false, fmt.Errorf("cannot move %s into the store: not a regular file", src)
+		}
+		if !verified {
+			got, err := HashFile(src)
+			if err != nil {
+				return "", false, err
+			}
+			if got != hash {
+				return "", false, fmt.Errorf("hash mismatch storing %s: expected %s, got %s", src, hash, got)
+			}
+		}
+		if err := os.Rename(src, objPath); err == nil {
+			return hash, true, nil
+		}
+		// Cross-device: fall through to copy
+	}
+
+	// Copy to a temporary name, verify, then rename so a partial object never exists
+	tmpPath := objPath + ".tmp"
+	got, err := copyHashed(src, tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", false, err
+	}
+	if got != hash {
+		os.Remove(tmpPath)
+		return "", false, fmt.Errorf("hash mismatch storing %s: expected %s, got %s", src, hash, got)
+	}
+	if info, err := os.Stat(src); err == nil {
+		os.Chtimes(tmpPath, info.ModTime(), info.ModTime())
+	}
+	if err := os.Rename(tmpPath, objPath); err != nil {
+		os.Remove(tmpPath)
+		return "", false, err
+	}
+	return hash, true, nil
+}
+
+// Materialize makes dest show the object's content using mode as link strategy
+// (hardlink, symlink, reflink or copy), with the usual fallbacks.
+func (s *Store) Materialize(hash, dest, mode string) error {
+	objPath := s.ObjectPath(hash)
+	if _, err := os.Stat(objPath); err != nil {
+		return fmt.Errorf("object %s not found: %w", hash, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	strategy, err := fsutil.ParseStrategy(mode)
+	if err != nil {
+		return err
+	}
+	_, err = fsutil.New(strategy).Link(objPath, dest)
+	return err
+}
+
+// ObjectInfo describes one object found by Walk
+type ObjectInfo struct {
+	Hash  string
+	Path  string
+	Size  int64
+	Links uint64 // Hardlink count (1 = only the store references it on disk)
+}
+
+// Walk calls fn for every object in the store
+func (s *Store) Walk(fn func(ObjectInfo) error) error {
+	return filepath.Walk(s.Root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == s.Root {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(info.Name(), ".tmp") {
+			return nil
+		}
+		obj := ObjectInfo{Hash: info.Name(), Path: path, Size: info.Size(), Links: 1}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			obj.Links = uint64(stat.Nlink)
+		}
+		return fn(obj)
+	})
+}
+
+// HashOfLink returns the hash of the object a symlink points into, or "" if it does not
+func (s *Store) HashOfLink(path string) string {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	if rel, err := filepath.Rel(s.Root, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// HashFile returns the SHA-256 of a file
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyHashed(src, dst string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, h), in); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}